@@ -20,16 +20,31 @@ func _() {
 	_ = x[exifTypeSignedRat8-10]
 	_ = x[exifTypeSignedFloat4-11]
 	_ = x[exifTypeSignedDouble8-12]
+	_ = x[exifTypeIFD4-13]
+	_ = x[exifTypeUnsignedLong8-16]
+	_ = x[exifTypeSignedLong8-17]
+	_ = x[exifTypeIFD8-18]
 }
 
-const _exifType_name = "exifTypeUnsignedByteexifTypeUnsignedASCIIexifTypeUnsignedShortexifTypeUnsignedLongexifTypeUnsignedRatexifTypeSignedByteexifTypeUndefexifTypeSignedShortexifTypeSignedLongexifTypeSignedRatexifTypeSignedFloatexifTypeSignedDouble"
+const (
+	_exifType_name_0 = "exifTypeUnsignedByteexifTypeUnsignedASCIIexifTypeUnsignedShortexifTypeUnsignedLongexifTypeUnsignedRatexifTypeSignedByteexifTypeUndefexifTypeSignedShortexifTypeSignedLongexifTypeSignedRatexifTypeSignedFloatexifTypeSignedDoubleexifTypeIFD"
+	_exifType_name_1 = "exifTypeUnsignedLong8exifTypeSignedLong8exifTypeIFD8"
+)
 
-var _exifType_index = [...]uint8{0, 20, 41, 62, 82, 101, 119, 132, 151, 169, 186, 205, 225}
+var (
+	_exifType_index_0 = [...]uint8{0, 20, 41, 62, 82, 101, 119, 132, 151, 169, 186, 205, 225, 236}
+	_exifType_index_1 = [...]uint8{0, 21, 40, 52}
+)
 
 func (i exifType) String() string {
-	i -= 1
-	if i >= exifType(len(_exifType_index)-1) {
-		return "exifType(" + strconv.FormatInt(int64(i+1), 10) + ")"
+	switch {
+	case 1 <= i && i <= 13:
+		i -= 1
+		return _exifType_name_0[_exifType_index_0[i]:_exifType_index_0[i+1]]
+	case 16 <= i && i <= 18:
+		i -= 16
+		return _exifType_name_1[_exifType_index_1[i]:_exifType_index_1[i+1]]
+	default:
+		return "exifType(" + strconv.FormatInt(int64(i), 10) + ")"
 	}
-	return _exifType_name[_exifType_index[i]:_exifType_index[i+1]]
 }
@@ -0,0 +1,366 @@
+// Copyright 2024 Bjørn Erik Pedersen
+// SPDX-License-Identifier: MIT
+
+package imagemeta_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"sort"
+	"testing"
+
+	"github.com/bep/imagemeta"
+)
+
+// The helpers in this file builds small synthetic images in memory.
+// This allows us to test edge cases that are hard to find in real images,
+// without having to commit lots of binary test files.
+// Prefer the real images in testdata when they cover the case.
+
+const (
+	tiffTypeByte      = 1
+	tiffTypeASCII     = 2
+	tiffTypeShort     = 3
+	tiffTypeLong      = 4
+	tiffTypeRational  = 5
+	tiffTypeUndef     = 7
+	tiffTypeSShort    = 8
+	tiffTypeSLong     = 9
+	tiffTypeSRational = 10
+	tiffTypeDouble    = 12
+	tiffTypeIFD       = 13
+	tiffTypeLong8     = 16
+	tiffTypeSLong8    = 17
+	tiffTypeIFD8      = 18
+)
+
+// tiffEntry is a single IFD entry.
+type tiffEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+
+	// If set, this entry is a pointer to this IFD.
+	ifd []tiffEntry
+}
+
+// tiffBuilder builds TIFF structures (as used in TIFF files and EXIF blocks).
+type tiffBuilder struct {
+	order binary.ByteOrder
+
+	// If set, build BigTIFF, with 8 byte counts and offsets.
+	big bool
+}
+
+func newTIFFBuilder() tiffBuilder {
+	return tiffBuilder{order: binary.BigEndian}
+}
+
+func newBigTIFFBuilder() tiffBuilder {
+	return tiffBuilder{order: binary.LittleEndian, big: true}
+}
+
+func (b tiffBuilder) raw(tag, typ uint16, count uint32, value []byte) tiffEntry {
+	return tiffEntry{tag: tag, typ: typ, count: count, value: value}
+}
+
+func (b tiffBuilder) ascii(tag uint16, s string) tiffEntry {
+	v := append([]byte(s), 0)
+	return b.raw(tag, tiffTypeASCII, uint32(len(v)), v)
+}
+
+func (b tiffBuilder) bytes(tag, typ uint16, v []byte) tiffEntry {
+	return b.raw(tag, typ, uint32(len(v)), v)
+}
+
+func (b tiffBuilder) short(tag uint16, vals ...uint16) tiffEntry {
+	v := make([]byte, 2*len(vals))
+	for i, vv := range vals {
+		b.order.PutUint16(v[i*2:], vv)
+	}
+	return b.raw(tag, tiffTypeShort, uint32(len(vals)), v)
+}
+
+func (b tiffBuilder) long(tag uint16, vals ...uint32) tiffEntry {
+	v := make([]byte, 4*len(vals))
+	for i, vv := range vals {
+		b.order.PutUint32(v[i*4:], vv)
+	}
+	return b.raw(tag, tiffTypeLong, uint32(len(vals)), v)
+}
+
+func (b tiffBuilder) double(tag uint16, vals ...float64) tiffEntry {
+	v := make([]byte, 8*len(vals))
+	for i, vv := range vals {
+		b.order.PutUint64(v[i*8:], math.Float64bits(vv))
+	}
+	return b.raw(tag, tiffTypeDouble, uint32(len(vals)), v)
+}
+
+// rational creates a rational entry from numerator/denominator pairs.
+func (b tiffBuilder) rational(tag uint16, vals ...uint32) tiffEntry {
+	e := b.long(tag, vals...)
+	e.typ = tiffTypeRational
+	e.count /= 2
+	return e
+}
+
+// srational creates a signed rational entry from numerator/denominator pairs.
+func (b tiffBuilder) srational(tag uint16, vals ...int32) tiffEntry {
+	v := make([]byte, 4*len(vals))
+	for i, vv := range vals {
+		b.order.PutUint32(v[i*4:], uint32(vv))
+	}
+	return b.raw(tag, tiffTypeSRational, uint32(len(vals)/2), v)
+}
+
+// withTag returns a copy of e with the given tag.
+func (e tiffEntry) withTag(tag uint16) tiffEntry {
+	e.tag = tag
+	return e
+}
+
+func (b tiffBuilder) sub(tag uint16, entries ...tiffEntry) tiffEntry {
+	// Non-nil, so an empty IFD is written.
+	return tiffEntry{tag: tag, typ: tiffTypeLong, count: 1, ifd: append([]tiffEntry{}, entries...)}
+}
+
+func (b tiffBuilder) byteOrderMark() []byte {
+	if b.order == binary.LittleEndian {
+		return []byte("II")
+	}
+	return []byte("MM")
+}
+
+// build creates a TIFF structure with the given IFDs chained together (IFD0, IFD1 ...).
+func (b tiffBuilder) build(ifds ...[]tiffEntry) []byte {
+	buf := b.byteOrderMark()
+	if b.big {
+		// The version, the offset size, a reserved zero and the IFD0 offset.
+		buf = appendUint16(b.order, buf, 43)
+		buf = appendUint16(b.order, buf, 8)
+		buf = appendUint16(b.order, buf, 0)
+		buf = appendUint64(b.order, buf, 16)
+	} else {
+		buf = appendUint16(b.order, buf, 42)
+		buf = appendUint32(b.order, buf, 8)
+	}
+
+	var prevNext int
+	for i, ifd := range ifds {
+		var start int
+		buf, start = b.writeIFD(buf, ifd)
+		if i > 0 {
+			b.putOffset(buf[prevNext:], start)
+		}
+		prevNext = start + b.countSize() + b.entrySize()*len(ifd)
+	}
+	return buf
+}
+
+// fieldSize returns the size of the entry count, the value counts and the value fields.
+func (b tiffBuilder) fieldSize() int {
+	if b.big {
+		return 8
+	}
+	return 4
+}
+
+func (b tiffBuilder) countSize() int {
+	if b.big {
+		return 8
+	}
+	return 2
+}
+
+func (b tiffBuilder) entrySize() int {
+	return 4 + 2*b.fieldSize()
+}
+
+func (b tiffBuilder) putOffset(buf []byte, v int) {
+	if b.big {
+		b.order.PutUint64(buf, uint64(v))
+	} else {
+		b.order.PutUint32(buf, uint32(v))
+	}
+}
+
+func (b tiffBuilder) writeIFD(buf []byte, entries []tiffEntry) ([]byte, int) {
+	entries = append([]tiffEntry(nil), entries...)
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+
+	fieldSize, entrySize, countSize := b.fieldSize(), b.entrySize(), b.countSize()
+
+	start := len(buf)
+	if b.big {
+		buf = appendUint64(b.order, buf, uint64(len(entries)))
+	} else {
+		buf = appendUint16(b.order, buf, uint16(len(entries)))
+	}
+	buf = append(buf, make([]byte, entrySize*len(entries)+fieldSize)...)
+
+	for i, e := range entries {
+		pos := start + countSize + entrySize*i
+		valuePos := pos + 4 + fieldSize
+		b.order.PutUint16(buf[pos:], e.tag)
+		b.order.PutUint16(buf[pos+2:], e.typ)
+		b.putOffset(buf[pos+4:], int(e.count))
+		switch {
+		case e.ifd != nil:
+			if b.big {
+				b.order.PutUint16(buf[pos+2:], tiffTypeIFD8)
+			}
+			var offset int
+			buf, offset = b.writeIFD(buf, e.ifd)
+			b.putOffset(buf[valuePos:], offset)
+		case len(e.value) <= fieldSize:
+			copy(buf[valuePos:valuePos+fieldSize], e.value)
+		default:
+			b.putOffset(buf[valuePos:], len(buf))
+			buf = append(buf, e.value...)
+			if len(buf)%2 != 0 {
+				buf = append(buf, 0)
+			}
+		}
+	}
+
+	return buf, start
+}
+
+func appendUint16(order binary.ByteOrder, b []byte, v uint16) []byte {
+	var buf [2]byte
+	order.PutUint16(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendUint32(order binary.ByteOrder, b []byte, v uint32) []byte {
+	var buf [4]byte
+	order.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendUint64(order binary.ByteOrder, b []byte, v uint64) []byte {
+	var buf [8]byte
+	order.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// jpegSegment creates a JPEG marker segment.
+func jpegSegment(marker uint16, payload []byte) []byte {
+	b := appendUint16(binary.BigEndian, nil, marker)
+	b = appendUint16(binary.BigEndian, b, uint16(len(payload)+2))
+	return append(b, payload...)
+}
+
+func jpegEXIFSegment(tiff []byte) []byte {
+	return jpegSegment(0xffe1, append([]byte("Exif\x00\x00"), tiff...))
+}
+
+func jpegXMPSegment(xmp string) []byte {
+	return jpegSegment(0xffe1, append([]byte("http://ns.adobe.com/xap/1.0/\x00"), xmp...))
+}
+
+// jpegFile creates a JPEG file with the given segments followed by a minimal scan.
+func jpegFile(segments ...[]byte) []byte {
+	b := []byte{0xff, 0xd8}
+	for _, s := range segments {
+		b = append(b, s...)
+	}
+	b = append(b, jpegSegment(0xffda, []byte{0, 0, 0, 0})...)
+	b = append(b, 0x12, 0x34, 0xff, 0xd9)
+	return b
+}
+
+// pngChunk creates a PNG chunk with a valid CRC.
+func pngChunk(typ string, data []byte) []byte {
+	b := appendUint32(binary.BigEndian, nil, uint32(len(data)))
+	b = append(b, typ...)
+	b = append(b, data...)
+	return appendUint32(binary.BigEndian, b, crc32.ChecksumIEEE(b[4:]))
+}
+
+// pngFile creates a PNG file with the given chunks between IHDR and IEND.
+func pngFile(chunks ...[]byte) []byte {
+	b := []byte("\x89PNG\r\n\x1a\n")
+	b = append(b, pngChunk("IHDR", []byte{0, 0, 0, 1, 0, 0, 0, 1, 8, 2, 0, 0, 0})...)
+	for _, c := range chunks {
+		b = append(b, c...)
+	}
+	return append(b, pngChunk("IEND", nil)...)
+}
+
+// webpChunk creates a RIFF chunk.
+func webpChunk(fourCC string, data []byte) []byte {
+	b := append([]byte(fourCC), appendUint32(binary.LittleEndian, nil, uint32(len(data)))...)
+	b = append(b, data...)
+	if len(data)%2 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// webpVP8XChunk creates a VP8X chunk with the given feature flags.
+func webpVP8XChunk(flags byte) []byte {
+	return webpChunk("VP8X", []byte{flags, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+}
+
+// webpFile creates a WebP file with the given chunks.
+func webpFile(chunks ...[]byte) []byte {
+	var body []byte
+	body = append(body, "WEBP"...)
+	for _, c := range chunks {
+		body = append(body, c...)
+	}
+	b := append([]byte("RIFF"), appendUint32(binary.LittleEndian, nil, uint32(len(body)))...)
+	return append(b, body...)
+}
+
+// tiffEntryOffset returns the offset in tiff of the entry with the last tag in path,
+// following the IFD pointer tags before it from IFD0, or -1 if not found.
+func tiffEntryOffset(tiff []byte, path ...uint16) int {
+	var order binary.ByteOrder = binary.BigEndian
+	if string(tiff[:2]) == "II" {
+		order = binary.LittleEndian
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	for i, tag := range path {
+		n := int(order.Uint16(tiff[ifd:]))
+		offset := -1
+		for j := 0; j < n; j++ {
+			if e := ifd + 2 + j*12; order.Uint16(tiff[e:]) == tag {
+				offset = e
+				break
+			}
+		}
+		if offset == -1 || i == len(path)-1 {
+			return offset
+		}
+		ifd = int(order.Uint32(tiff[offset+8:]))
+	}
+	return -1
+}
+
+// decodeBytes decodes b with opts and returns the tags and any warnings.
+// The reader, format, tag handler and warning handler in opts are set by this function.
+func decodeBytes(t testing.TB, b []byte, format imagemeta.ImageFormat, opts imagemeta.Options) (imagemeta.Tags, []string) {
+	t.Helper()
+	var tags imagemeta.Tags
+	var warnings []string
+	opts.R = bytes.NewReader(b)
+	opts.ImageFormat = format
+	opts.HandleTag = func(ti imagemeta.TagInfo) error {
+		tags.Add(ti)
+		return nil
+	}
+	opts.Warnf = func(format string, args ...any) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+	if err := imagemeta.Decode(opts); err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	return tags, warnings
+}
@@ -6,10 +6,12 @@ package imagemeta
 import (
 	"bytes"
 	"encoding"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"math"
+	"math/big"
 	"runtime"
 	"strconv"
 	"strings"
@@ -20,6 +22,9 @@ import (
 // errInvalidFormat is used when the format is invalid.
 var errInvalidFormat = &InvalidFormatError{errors.New("invalid format")}
 
+// errIFDPointerHandled signals a bug in the decoder.
+var errIFDPointerHandled = errors.New("internal error: IFD pointer passed to HandleTag")
+
 // IsInvalidFormat reports whether the error was an InvalidFormatError.
 func IsInvalidFormat(err error) bool {
 	return errors.Is(err, errInvalidFormat)
@@ -40,11 +45,56 @@ func (e *InvalidFormatError) Is(target error) bool {
 	return ok
 }
 
+// errTruncated is used when the stream ends prematurely.
+var errTruncated = &TruncatedError{io.ErrUnexpectedEOF}
+
+// IsTruncated reports whether the error was a TruncatedError.
+func IsTruncated(err error) bool {
+	return errors.Is(err, errTruncated)
+}
+
+// TruncatedError is used when the stream ends in the middle of a structure,
+// e.g. a file that was cut off inside the EXIF segment or an XMP packet that ends inside an element.
+// A TruncatedError is also an InvalidFormatError.
+type TruncatedError struct {
+	Err error
+}
+
+func (e *TruncatedError) Error() string {
+	return "truncated: " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error, e.g. io.ErrUnexpectedEOF.
+func (e *TruncatedError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the target error is a TruncatedError or an InvalidFormatError.
+func (e *TruncatedError) Is(target error) bool {
+	switch target.(type) {
+	case *TruncatedError, *InvalidFormatError:
+		return true
+	default:
+		return false
+	}
+}
+
 func newInvalidFormatErrorf(format string, args ...any) error {
 	return &InvalidFormatError{fmt.Errorf(format, args...)}
 }
 
 func newInvalidFormatError(err error) error {
+	var (
+		truncatedErr     *TruncatedError
+		invalidFormatErr *InvalidFormatError
+	)
+	if errors.As(err, &truncatedErr) || errors.As(err, &invalidFormatErr) {
+		// Already classified.
+		return err
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return &TruncatedError{err}
+	}
 	return &InvalidFormatError{err}
 }
 
@@ -72,6 +122,18 @@ type Rat[T int32 | uint32] interface {
 	// String returns the string representation of the rational number.
 	// If the denominator is 1, the string will be the numerator only.
 	String() string
+
+	// Add returns the reduced sum of the rational number and o.
+	// An error is returned if the result does not fit in T.
+	Add(o Rat[T]) (Rat[T], error)
+
+	// Mul returns the reduced product of the rational number and o.
+	// An error is returned if the result does not fit in T.
+	Mul(o Rat[T]) (Rat[T], error)
+
+	// Inv returns the inverse of the rational number.
+	// An error is returned if the rational number is zero.
+	Inv() (Rat[T], error)
 }
 
 var (
@@ -110,6 +172,48 @@ func (r rat[T]) String() string {
 	return fmt.Sprintf("%d/%d", r.num, r.den)
 }
 
+// Add returns the reduced sum of r and o.
+func (r rat[T]) Add(o Rat[T]) (Rat[T], error) {
+	return newRatFromBig[T](new(big.Rat).Add(r.big(), toBigRat(o)))
+}
+
+// Mul returns the reduced product of r and o.
+func (r rat[T]) Mul(o Rat[T]) (Rat[T], error) {
+	return newRatFromBig[T](new(big.Rat).Mul(r.big(), toBigRat(o)))
+}
+
+// Inv returns the inverse of r.
+func (r rat[T]) Inv() (Rat[T], error) {
+	return NewRat(r.den, r.num)
+}
+
+func (r rat[T]) big() *big.Rat {
+	return toBigRat[T](r)
+}
+
+func toBigRat[T int32 | uint32](r Rat[T]) *big.Rat {
+	return big.NewRat(int64(r.Num()), int64(r.Den()))
+}
+
+// newRatFromBig creates a Rat from the already reduced r.
+func newRatFromBig[T int32 | uint32](r *big.Rat) (Rat[T], error) {
+	min, max := int64(math.MinInt32), int64(math.MaxInt32)
+	var zero T
+	if zero-1 > 0 {
+		// Unsigned.
+		min, max = 0, math.MaxUint32
+	}
+	num, den := r.Num(), r.Denom()
+	if !num.IsInt64() || !den.IsInt64() {
+		return nil, fmt.Errorf("rational number %s overflows", r)
+	}
+	n, d := num.Int64(), den.Int64()
+	if n < min || n > max || d > max {
+		return nil, fmt.Errorf("rational number %s overflows", r)
+	}
+	return &rat[T]{num: T(n), den: T(d)}, nil
+}
+
 func (r rat[T]) Format(w fmt.State, v rune) {
 	switch v {
 	case 'f':
@@ -214,6 +318,135 @@ func (c vc) convertBytesToStringSpaceDelim(ctx valueConverterContext, v any) any
 	return c.convertBytesToStringDelimBy(ctx, v, " ")
 }
 
+func (c vc) convertBytesToStringDotDelim(ctx valueConverterContext, v any) any {
+	return c.convertBytesToStringDelimBy(ctx, v, ".")
+}
+
+// convertBytesToString converts ASCII stored as bytes (e.g. ExifVersion "0232") to a string.
+func (c vc) convertBytesToString(ctx valueConverterContext, v any) any {
+	switch vv := v.(type) {
+	case string:
+		return printableString(vv)
+	case []byte:
+		return printableString(string(trimBytesNulls(vv)))
+	default:
+		ctx.warnf("expected string or []byte, got %T", v)
+		return ""
+	}
+}
+
+// convertEncodedString converts text stored as bytes with an 8-byte character code prefix
+// (e.g. GPSProcessingMethod "ASCII\x00\x00\x00GPS") to a string.
+// Values without a known prefix are converted as is.
+func (c vc) convertEncodedString(ctx valueConverterContext, v any) any {
+	b, ok := v.([]byte)
+	if !ok {
+		return c.convertBytesToString(ctx, v)
+	}
+	if len(b) >= 8 {
+		switch string(b[:8]) {
+		case "ASCII\x00\x00\x00", "UNICODE\x00", "JIS\x00\x00\x00\x00\x00", "\x00\x00\x00\x00\x00\x00\x00\x00":
+			b = b[8:]
+		}
+	}
+	return printableString(string(trimBytesNulls(b)))
+}
+
+// convertKeepBytes passes binary data (e.g. PrintIM) on as []byte,
+// as converting it to a printable string would mangle it.
+func (c vc) convertKeepBytes(ctx valueConverterContext, v any) any {
+	b, ok := typeAssertSlice[byte](ctx, v)
+	if !ok {
+		return nil
+	}
+	return b
+}
+
+// convertOpcodeList converts a DNG opcode list to []Opcode if decodeStructured is set.
+// The list is always big endian.
+func (c vc) convertOpcodeList(ctx valueConverterContext, v any) any {
+	if !ctx.decodeStructured {
+		return toPrintableValue(v)
+	}
+	b, ok := typeAssertSlice[byte](ctx, v)
+	if !ok || len(b) < 4 {
+		return []Opcode{}
+	}
+	count := binary.BigEndian.Uint32(b)
+	b = b[4:]
+	var opcodes []Opcode
+	for i := uint32(0); i < count; i++ {
+		if len(b) < 16 {
+			ctx.warnf("opcode %d: unexpected end of data", i)
+			break
+		}
+		op := Opcode{
+			ID:      binary.BigEndian.Uint32(b),
+			Version: fmt.Sprintf("%d.%d.%d.%d", b[4], b[5], b[6], b[7]),
+			Flags:   binary.BigEndian.Uint32(b[8:]),
+		}
+		size := binary.BigEndian.Uint32(b[12:])
+		b = b[16:]
+		if uint64(size) > uint64(len(b)) {
+			ctx.warnf("opcode %d: invalid parameter size %d", i, size)
+			break
+		}
+		params := b[:size]
+		b = b[size:]
+		switch op.ID {
+		case OpcodeWarpRectilinear:
+			op.Params = c.decodeWarpRectilinearParams(params)
+		case OpcodeFixVignetteRadial:
+			op.Params = c.decodeFixVignetteRadialParams(params)
+		}
+		if op.Params == nil {
+			op.Params = params
+		}
+		opcodes = append(opcodes, op)
+	}
+	return opcodes
+}
+
+// decodeWarpRectilinearParams returns nil if params is malformed.
+func (c vc) decodeWarpRectilinearParams(params []byte) any {
+	if len(params) < 4 {
+		return nil
+	}
+	numPlanes := binary.BigEndian.Uint32(params)
+	if uint64(len(params)) != 4+uint64(numPlanes)*6*8+2*8 {
+		return nil
+	}
+	f := c.float64s(params[4:])
+	p := &WarpRectilinearParams{Planes: make([]WarpRectilinearPlane, numPlanes)}
+	for i := range p.Planes {
+		copy(p.Planes[i].Radial[:], f[:4])
+		copy(p.Planes[i].Tangential[:], f[4:6])
+		f = f[6:]
+	}
+	p.CenterX, p.CenterY = f[0], f[1]
+	return p
+}
+
+// decodeFixVignetteRadialParams returns nil if params is malformed.
+func (c vc) decodeFixVignetteRadialParams(params []byte) any {
+	if len(params) != 7*8 {
+		return nil
+	}
+	f := c.float64s(params)
+	p := &FixVignetteRadialParams{CenterX: f[5], CenterY: f[6]}
+	copy(p.K[:], f[:5])
+	return p
+}
+
+// float64s decodes b as a list of big endian float64 values.
+func (vc) float64s(b []byte) []float64 {
+	f := make([]float64, len(b)/8)
+	for i := range f {
+		f[i] = math.Float64frombits(binary.BigEndian.Uint64(b[i*8:]))
+	}
+	return f
+}
+
 func (c vc) convertDegreesToDecimal(ctx valueConverterContext, v any) any {
 	d, err := c.toDegrees(v)
 	if err != nil {
@@ -224,8 +457,44 @@ func (c vc) convertDegreesToDecimal(ctx valueConverterContext, v any) any {
 	return d
 }
 
-func (vc) convertNumbersToSpaceLimited(ctx valueConverterContext, v any) any {
-	nums, ok := typeAssertSlice[any](ctx, v)
+func (c vc) convertGPSMapDatum(ctx valueConverterContext, v any) any {
+	s := printableString(toString(v))
+	if s != "" && !isWGS84(s) {
+		// We don't do any reprojection, but let the user know.
+		ctx.warnf("GPS coordinates use the %q datum, not WGS-84", strings.TrimSpace(s))
+	}
+	return s
+}
+
+// isWGS84 reports whether the GPSMapDatum s is WGS-84.
+func isWGS84(s string) bool {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	return s == "WGS-84" || s == "WGS84" || s == "WGS 84"
+}
+
+// toNumbers converts a single number or a list of numbers to a slice.
+func (vc) toNumbers(ctx valueConverterContext, v any) ([]any, bool) {
+	switch vv := v.(type) {
+	case []any:
+		return vv, true
+	case []byte:
+		// A list of bytes.
+		nums := make([]any, len(vv))
+		for i, b := range vv {
+			nums[i] = b
+		}
+		return nums, true
+	case uint8, uint16, uint32, int8, int16, int32, int:
+		// A single value.
+		return []any{vv}, true
+	default:
+		ctx.warnf("expected a number or a list of numbers, got %T", v)
+		return nil, false
+	}
+}
+
+func (c vc) convertNumbersToSpaceLimited(ctx valueConverterContext, v any) any {
+	nums, ok := c.toNumbers(ctx, v)
 	if !ok {
 		return ""
 	}
@@ -240,6 +509,31 @@ func (vc) convertNumbersToSpaceLimited(ctx valueConverterContext, v any) any {
 	return sb.String()
 }
 
+func (c vc) convertSubjectArea(ctx valueConverterContext, v any) any {
+	if !ctx.decodeStructured {
+		return c.convertNumbersToSpaceLimited(ctx, v)
+	}
+	nums, ok := c.toNumbers(ctx, v)
+	if !ok {
+		return SubjectArea{}
+	}
+	vals := make([]int, len(nums))
+	for i, n := range nums {
+		vals[i], _ = toInt(n)
+	}
+	switch len(vals) {
+	case 2:
+		return SubjectArea{Kind: SubjectAreaPoint, X: vals[0], Y: vals[1]}
+	case 3:
+		return SubjectArea{Kind: SubjectAreaCircle, X: vals[0], Y: vals[1], W: vals[2], H: vals[2]}
+	case 4:
+		return SubjectArea{Kind: SubjectAreaRectangle, X: vals[0], Y: vals[1], W: vals[2], H: vals[3]}
+	default:
+		ctx.warnf("expected 2, 3 or 4 values, got %d", len(vals))
+		return SubjectArea{}
+	}
+}
+
 func (c vc) convertBinaryData(ctx valueConverterContext, v any) any {
 	b, ok := typeAssert[[]byte](ctx, v)
 	if !ok {
@@ -248,6 +542,144 @@ func (c vc) convertBinaryData(ctx valueConverterContext, v any) any {
 	return fmt.Sprintf("(Binary data %d bytes)", len(b))
 }
 
+// convertRatsToFloat64s converts a list of rationals to []float64.
+// If any of the rationals is undefined, the value is undef, as NaN can't be marshaled to JSON.
+func (c vc) convertRatsToFloat64s(ctx valueConverterContext, v any) any {
+	vals, ok := v.([]any)
+	if !ok {
+		// A single value.
+		vals = []any{v}
+	}
+	floats := make([]float64, len(vals))
+	for i, vv := range vals {
+		switch vv := vv.(type) {
+		case float64Provider:
+			floats[i] = vv.Float64()
+			if isUndefined(floats[i]) {
+				return undef
+			}
+		case string:
+			return undef
+		default:
+			ctx.warnf("expected a rational, got %T", vv)
+			return floats[:0]
+		}
+	}
+	return floats
+}
+
+// convertRatToFloat64 converts a rational to float64.
+func (c vc) convertRatToFloat64(ctx valueConverterContext, v any) any {
+	switch vv := v.(type) {
+	case float64Provider:
+		return vv.Float64()
+	case string:
+		// undef.
+		return vv
+	default:
+		ctx.warnf("expected a rational, got %T", v)
+		return 0.0
+	}
+}
+
+// See https://exiftool.org/TagNames/EXIF.html
+var exifColorSpaceLabels = map[int]string{
+	0x1:    "sRGB",
+	0x2:    "Adobe RGB",
+	0xfffd: "Wide Gamut RGB",
+	0xfffe: "ICC Profile",
+	0xffff: "Uncalibrated",
+}
+
+// See https://exiftool.org/TagNames/GPS.html
+var (
+	exifGPSStatusLabels = map[string]string{
+		"A": "Measurement Active",
+		"V": "Measurement Void",
+	}
+	exifGPSDifferentialLabels = map[int]string{
+		0: "No Correction",
+		1: "Differential Corrected",
+	}
+)
+
+// convertEnumLabel returns a converter that, if enabled, converts the numeric value to its label in labels.
+// Values not in labels are converted to "Unknown (n)".
+func (c vc) convertEnumLabel(labels map[int]string) valueConverter {
+	return func(ctx valueConverterContext, v any) any {
+		if !ctx.decodeEnumLabels {
+			return v
+		}
+		n, ok := toInt(v)
+		if !ok {
+			ctx.warnf("expected a number, got %T", v)
+			return v
+		}
+		if label, found := labels[n]; found {
+			return label
+		}
+		return fmt.Sprintf("Unknown (%d)", n)
+	}
+}
+
+// convertStringEnumLabel is like convertEnumLabel, but for enumerated values stored as strings, e.g. GPSStatus.
+func (c vc) convertStringEnumLabel(labels map[string]string) valueConverter {
+	return func(ctx valueConverterContext, v any) any {
+		if !ctx.decodeEnumLabels {
+			return v
+		}
+		s := strings.TrimSpace(toString(v))
+		if label, found := labels[s]; found {
+			return label
+		}
+		return fmt.Sprintf("Unknown (%s)", s)
+	}
+}
+
+// convertClamp returns a converter that converts the numeric value to an int in the range [lo, hi].
+func (c vc) convertClamp(lo, hi int) valueConverter {
+	return func(ctx valueConverterContext, v any) any {
+		n, ok := toInt(v)
+		if !ok {
+			ctx.warnf("expected a number, got %T", v)
+			return v
+		}
+		return clampInt(n, lo, hi)
+	}
+}
+
+// convertColorMatrix converts a DNG ColorMatrix (rows x 3) to []float64 or, if structured, a Matrix.
+func (c vc) convertColorMatrix(ctx valueConverterContext, v any) any {
+	return c.convertMatrix(ctx, v, 0, 3)
+}
+
+// convertForwardMatrix converts a DNG ForwardMatrix (3 x columns) to []float64 or, if structured, a Matrix.
+func (c vc) convertForwardMatrix(ctx valueConverterContext, v any) any {
+	return c.convertMatrix(ctx, v, 3, 0)
+}
+
+// convertMatrix converts the rationals in v to a matrix with the given number of rows or columns;
+// the other is derived from the number of values.
+func (c vc) convertMatrix(ctx valueConverterContext, v any, rows, cols int) any {
+	vals, ok := c.convertRatsToFloat64s(ctx, v).([]float64)
+	if !ok {
+		return undef
+	}
+	if !ctx.decodeStructured {
+		return vals
+	}
+	if rows > 0 {
+		cols = len(vals) / rows
+	} else {
+		rows = len(vals) / cols
+	}
+	if rows*cols != len(vals) {
+		ctx.warnf("expected a multiple of 3 values, got %d", len(vals))
+		return Matrix{}
+	}
+	return Matrix{Rows: rows, Cols: cols, Values: vals}
+}
+
 func (c vc) convertRatsToSpaceLimited(ctx valueConverterContext, v any) any {
 	nums, ok := typeAssert[[]any](ctx, v)
 	if !ok {
@@ -283,6 +715,29 @@ func (c vc) convertRatsToSpaceLimited(ctx valueConverterContext, v any) any {
 	return sb.String()
 }
 
+// convertRats converts a list of unsigned rationals to []Rat[uint32] if decodeStructured is set,
+// else to a space delimited string.
+func (c vc) convertRats(ctx valueConverterContext, v any) any {
+	if !ctx.decodeStructured {
+		return c.convertRatsToSpaceLimited(ctx, v)
+	}
+	vals, ok := v.([]any)
+	if !ok {
+		// A single value.
+		vals = []any{v}
+	}
+	rats := make([]Rat[uint32], len(vals))
+	for i, vv := range vals {
+		r, ok := vv.(Rat[uint32])
+		if !ok {
+			// E.g. undef or a signed rational written by a non-conforming writer.
+			return c.convertRatsToSpaceLimited(ctx, v)
+		}
+		rats[i] = r
+	}
+	return rats
+}
+
 func (vc) convertStringToInt(ctx valueConverterContext, v any) any {
 	s, ok := typeAssert[string](ctx, v)
 	if !ok {
@@ -293,6 +748,25 @@ func (vc) convertStringToInt(ctx valueConverterContext, v any) any {
 	return i
 }
 
+// convertWhiteBalance normalizes WhiteBalance to the numeric value defined by the spec (0 = Auto, 1 = Manual).
+// Some cameras write a vendor string instead, e.g. "AUTO1".
+func (vc) convertWhiteBalance(ctx valueConverterContext, v any) any {
+	s, ok := v.(string)
+	if !ok {
+		return v
+	}
+	s = strings.ToUpper(printableString(s))
+	switch {
+	case strings.HasPrefix(s, "AUTO"):
+		return uint16(0)
+	case strings.HasPrefix(s, "MANUAL"):
+		return uint16(1)
+	default:
+		ctx.warnf("unexpected value %q", s)
+		return s
+	}
+}
+
 func (c vc) convertUserComment(ctx valueConverterContext, v any) any {
 	// UserComment tag is identified based on an ID code in a fixed 8-byte area at the start of the tag data area.
 	b, ok := typeAssert[[]byte](ctx, v)
@@ -329,6 +803,37 @@ func (vc) ratNum(v any) any {
 	}
 }
 
+// convertGPSTimeStamp converts GPSTimeStamp to the time of day in UTC as a time.Duration if decodeStructured is set,
+// keeping any fractional seconds, else to a "15:04:05" string.
+func (c vc) convertGPSTimeStamp(ctx valueConverterContext, v any) any {
+	if !ctx.decodeStructured {
+		return c.convertToTimestampString(ctx, v)
+	}
+	if vv, ok := v.([]any); ok && len(vv) == 3 {
+		secs := toFloat64(vv[0])*3600 + toFloat64(vv[1])*60 + toFloat64(vv[2])
+		return time.Duration(math.Round(secs * float64(time.Second)))
+	}
+	// E.g. an ASCII string written by some cameras.
+	s, _ := c.convertToTimestampString(ctx, v).(string)
+	d, _ := parseTimeOfDay(s)
+	return d
+}
+
+// parseTimeOfDay parses a time of day on the form "15:04:05", with optional fractional seconds.
+func parseTimeOfDay(s string) (time.Duration, bool) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, false
+	}
+	h, err1 := strconv.Atoi(parts[0])
+	m, err2 := strconv.Atoi(parts[1])
+	sec, err3 := strconv.ParseFloat(parts[2], 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, false
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(math.Round(sec*float64(time.Second))), true
+}
+
 func (c vc) convertToTimestampString(ctx valueConverterContext, v any) any {
 	switch vv := v.(type) {
 	case []any:
@@ -365,6 +870,8 @@ func (c vc) convertToTimestampString(ctx valueConverterContext, v any) any {
 }
 
 func (vc) parseDegrees(s string) (float64, error) {
+	s = strings.TrimSpace(strings.Trim(s, "\x00"))
+	// Some cameras (e.g. Sony DSC-HX20V) write "0100" when there's no GPS fix.
 	if s == "" || s == "0100" {
 		return 0, nil
 	}
@@ -379,6 +886,9 @@ func (vc) parseDegrees(s string) (float64, error) {
 func (c vc) toDegrees(v any) (float64, error) {
 	switch v := v.(type) {
 	case []any:
+		if len(v) == 1 {
+			return c.toDegrees(v[0])
+		}
 		if len(v) != 3 {
 			return 0.0, fmt.Errorf("expected 3 values, got %d", len(v))
 		}
@@ -390,6 +900,9 @@ func (c vc) toDegrees(v any) (float64, error) {
 		return deg + min/60 + sec/3600, nil
 	case float64:
 		return v, nil
+	case float64Provider:
+		// A single rational.
+		return v.Float64(), nil
 	case string:
 		return c.parseDegrees(v)
 	case []byte:
@@ -441,6 +954,87 @@ func toFloat64(v any) float64 {
 	}
 }
 
+// toInt converts a single integer value to int.
+func toInt(v any) (int, bool) {
+	switch vv := v.(type) {
+	case int:
+		return vv, true
+	case int8:
+		return int(vv), true
+	case int16:
+		return int(vv), true
+	case int32:
+		return int(vv), true
+	case uint8:
+		return int(vv), true
+	case uint16:
+		return int(vv), true
+	case uint32:
+		return int(vv), true
+	default:
+		return 0, false
+	}
+}
+
+// clampInt returns n limited to the range [lo, hi].
+func clampInt(n, lo, hi int) int {
+	if n < lo {
+		return lo
+	}
+	if n > hi {
+		return hi
+	}
+	return n
+}
+
+// toUint32 converts a single unsigned integer value to uint32.
+func toUint32(v any) (uint32, bool) {
+	switch vv := v.(type) {
+	case uint32:
+		return vv, true
+	case uint64:
+		if vv > math.MaxUint32 {
+			return 0, false
+		}
+		return uint32(vv), true
+	case uint16:
+		return uint32(vv), true
+	case uint8:
+		return uint32(vv), true
+	default:
+		return 0, false
+	}
+}
+
+// toOffset converts a single unsigned integer value, e.g. an IFD pointer, to an int64 offset.
+func toOffset(v any) (int64, bool) {
+	if vv, ok := v.(uint64); ok {
+		if vv > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(vv), true
+	}
+	n, ok := toUint32(v)
+	return int64(n), ok
+}
+
+// toUint32s converts a single or a list of unsigned integer values to []uint32.
+func toUint32s(v any) []uint32 {
+	vals, ok := v.([]any)
+	if !ok {
+		vals = []any{v}
+	}
+	nums := make([]uint32, 0, len(vals))
+	for _, vv := range vals {
+		n, ok := toUint32(vv)
+		if !ok {
+			return nil
+		}
+		nums = append(nums, n)
+	}
+	return nums
+}
+
 func toString(v any) string {
 	switch vv := v.(type) {
 	case string:
@@ -452,6 +1046,104 @@ func toString(v any) string {
 	}
 }
 
+const (
+	exifDateTimeLayout = "2006:01:02 15:04:05"
+	exifDateLayout     = "2006:01:02"
+)
+
+// dateTags are the tags converted by Options.NormalizeDates.
+var dateTags = map[Source]map[string]bool{
+	EXIF: {
+		"DateTimeOriginal": true,
+		"CreateDate":       true,
+		"ModifyDate":       true,
+		"GPSDateStamp":     true,
+	},
+	IPTC: {
+		"DateCreated":         true,
+		"DateSent":            true,
+		"DigitalCreationDate": true,
+		"ReleaseDate":         true,
+		"ExpirationDate":      true,
+	},
+	XMP: {
+		"CreateDate":        true,
+		"ModifyDate":        true,
+		"MetadataDate":      true,
+		"DateCreated":       true,
+		"DateTimeOriginal":  true,
+		"DateTimeDigitized": true,
+	},
+}
+
+// normalizeDate parses the value of ti into a time.Time if ti is a known date tag.
+func normalizeDate(ti TagInfo) (time.Time, bool) {
+	name := ti.Tag
+	if ti.Source == XMP {
+		// Qualified names, e.g. "xmp:CreateDate".
+		if _, local, found := strings.Cut(name, ":"); found {
+			name = local
+		}
+	}
+	if !dateTags[ti.Source][name] {
+		return time.Time{}, false
+	}
+	s, ok := ti.Value.(string)
+	if !ok {
+		return time.Time{}, false
+	}
+	s = strings.TrimSpace(s)
+
+	if ti.Source == XMP {
+		t := parseXMPDate(s)
+		return t, !t.IsZero()
+	}
+
+	for _, layout := range []string{exifDateTimeLayout, exifDateLayout} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// uniqueNonEmptyStrings returns the trimmed, non-empty strings in v with duplicates removed.
+// If sep is set, the strings are also split on sep.
+func uniqueNonEmptyStrings(v any, sep string) []string {
+	var values []string
+	switch vv := v.(type) {
+	case []string:
+		values = vv
+	case []any:
+		for _, s := range vv {
+			values = append(values, toString(s))
+		}
+	default:
+		values = []string{toString(vv)}
+	}
+
+	var result []string
+	seen := make(map[string]bool)
+	add := func(s string) {
+		s = strings.TrimSpace(s)
+		if s == "" || seen[s] {
+			return
+		}
+		seen[s] = true
+		result = append(result, s)
+	}
+	for _, s := range values {
+		if sep == "" {
+			add(s)
+			continue
+		}
+		for _, ss := range strings.Split(s, sep) {
+			add(ss)
+		}
+	}
+	return result
+}
+
 func trimBytesNulls(b []byte) []byte {
 	var lo, hi int
 	for lo = 0; lo < len(b) && b[lo] == 0; lo++ {
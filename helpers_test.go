@@ -6,6 +6,7 @@ package imagemeta
 import (
 	"encoding"
 	"fmt"
+	"math"
 	"testing"
 
 	qt "github.com/frankban/quicktest"
@@ -14,11 +15,14 @@ import (
 func TestStringer(t *testing.T) {
 	c := qt.New(t)
 	c.Assert(exifTypeUnsignedByte1.String(), qt.Equals, "exifTypeUnsignedByte")
+	c.Assert(exifTypeIFD8.String(), qt.Equals, "exifTypeIFD8")
+	c.Assert(exifType(14).String(), qt.Equals, "exifType(14)")
 
 	var source Source
 	c.Assert(EXIF.String(), qt.Equals, "EXIF")
 	c.Assert(IPTC.String(), qt.Equals, "IPTC")
 	c.Assert(XMP.String(), qt.Equals, "XMP")
+	c.Assert(PNGChunks.String(), qt.Equals, "PNGChunks")
 	c.Assert(source.String(), qt.Equals, "Source(0)")
 
 	var imageFormatAuto ImageFormat
@@ -108,6 +112,59 @@ func TestRat(t *testing.T) {
 		c.Assert(ru.String(), qt.Equals, "4")
 	})
 
+	c.Run("Arithmetic", func(c *qt.C) {
+		a, _ := NewRat[int32](1, 6)
+		b, _ := NewRat[int32](-1, 3)
+
+		r, err := a.Add(b)
+		c.Assert(err, qt.IsNil)
+		c.Assert(r.String(), qt.Equals, "-1/6")
+
+		r, err = a.Mul(b)
+		c.Assert(err, qt.IsNil)
+		c.Assert(r.String(), qt.Equals, "-1/18")
+
+		r, err = b.Inv()
+		c.Assert(err, qt.IsNil)
+		c.Assert(r.String(), qt.Equals, "-3")
+
+		zero, _ := NewRat[int32](0, 1)
+		_, err = zero.Inv()
+		c.Assert(err, qt.ErrorMatches, "denominator must be non-zero")
+
+		// The result is reduced.
+		ua, _ := NewRat[uint32](1, 4)
+		ub, _ := NewRat[uint32](3, 4)
+		ur, err := ua.Add(ub)
+		c.Assert(err, qt.IsNil)
+		c.Assert(ur.String(), qt.Equals, "1")
+		ur, err = ua.Mul(ub)
+		c.Assert(err, qt.IsNil)
+		c.Assert(ur.String(), qt.Equals, "3/16")
+	})
+
+	c.Run("Arithmetic overflow", func(c *qt.C) {
+		maxInt32, _ := NewRat[int32](math.MaxInt32, 1)
+		one, _ := NewRat[int32](1, 1)
+		_, err := maxInt32.Add(one)
+		c.Assert(err, qt.ErrorMatches, "rational number 2147483648/1 overflows")
+		minInt32, _ := NewRat[int32](math.MinInt32+1, 1)
+		_, err = minInt32.Mul(maxInt32)
+		c.Assert(err, qt.ErrorMatches, ".*overflows")
+		small, _ := NewRat[int32](1, math.MaxInt32)
+		_, err = small.Mul(small)
+		c.Assert(err, qt.ErrorMatches, ".*overflows")
+
+		maxUint32, _ := NewRat[uint32](math.MaxUint32, 1)
+		uone, _ := NewRat[uint32](1, 1)
+		_, err = maxUint32.Add(uone)
+		c.Assert(err, qt.ErrorMatches, "rational number 4294967296/1 overflows")
+		// This fits in uint32, but not in int32.
+		r, err := maxUint32.Mul(uone)
+		c.Assert(err, qt.IsNil)
+		c.Assert(r.Num(), qt.Equals, uint32(math.MaxUint32))
+	})
+
 	c.Run("Format", func(c *qt.C) {
 		ru, _ := NewRat[uint32](1, 3)
 		s := fmt.Sprintf("%.2f", ru)
@@ -117,3 +174,17 @@ func TestRat(t *testing.T) {
 		c.Assert(s, qt.Equals, "1/3")
 	})
 }
+
+func TestBytesAndReaderPoolDropsOversizedBuffers(t *testing.T) {
+	c := qt.New(t)
+
+	for i := 0; i < 10; i++ {
+		br := getBytesAndReader(maxPooledBufferSize * 4)
+		c.Assert(len(br.b), qt.Equals, maxPooledBufferSize*4)
+		putBytesAndReader(br)
+
+		br = getBytesAndReader(32)
+		c.Assert(cap(br.b) <= maxPooledBufferSize, qt.IsTrue)
+		putBytesAndReader(br)
+	}
+}
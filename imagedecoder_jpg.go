@@ -5,14 +5,82 @@ package imagemeta
 
 import (
 	"bytes"
+	"encoding/binary"
+	"fmt"
 	"io"
+	"path"
 )
 
 type imageDecoderJPEG struct {
 	*baseStreamingDecoder
+
+	// The images listed in the MP Index IFD of an MPO file,
+	// set if MPOAllImages is enabled.
+	mpImages []mpImage
+
+	// The position after the SOS marker of the main image,
+	// set if ScanTrailingData is enabled.
+	sosPos int64
+
+	// The first error in a segment that didn't stop the scan,
+	// e.g. an invalid EXIF segment or a segment cut off by EOF.
+	// This is returned after the rest of the file has been decoded.
+	segmentErr error
+
+	// The largest JPEG preview found in the EXIF, e.g. in a Canon MakerNote.
+	preview previewImage
+}
+
+// mpImage is an entry in the MP Index IFD.
+// The offset is absolute, i.e. relative to the start of the file.
+type mpImage struct {
+	offset int64
+	size   uint32
 }
 
+// See https://www.cipa.jp/std/documents/e/DC-X007-KEY_E.pdf
+const mpTagMPEntry = 0xb002
+
+var markerMPF = []byte("MPF\x00")
+
+// maxEXIFPadding is the number of bytes some writers put before the Exif marker
+// in an APP1 segment, or as NUL padding between the marker and the TIFF header.
+const maxEXIFPadding = 8
+
 func (e *imageDecoderJPEG) decode() error {
+	if err := e.decodeSegments(); err != nil {
+		return err
+	}
+	if err := e.decodeMPImages(); err != nil {
+		return err
+	}
+	if err := e.decodeTrailingData(); err != nil {
+		return err
+	}
+	if err := e.handlePreviewImage(); err != nil {
+		return err
+	}
+	return e.segmentErr
+}
+
+// setSegmentErr records err to be returned after the rest of the file is decoded,
+// unless an error is already recorded.
+func (e *imageDecoderJPEG) setSegmentErr(err error) {
+	if e.segmentErr == nil {
+		e.segmentErr = err
+	}
+}
+
+// handlePreviewImage passes the largest preview image found in the EXIF to HandlePreviewImage, if any.
+func (e *imageDecoderJPEG) handlePreviewImage() error {
+	if e.opts.HandlePreviewImage == nil || e.preview.length == 0 {
+		return nil
+	}
+	e.seek(e.preview.offset)
+	return e.opts.HandlePreviewImage(io.LimitReader(e.r, int64(e.preview.length)))
+}
+
+func (e *imageDecoderJPEG) decodeSegments() error {
 	// JPEG SOI marker.
 	soi, err := e.read2E()
 	if err != nil {
@@ -20,16 +88,30 @@ func (e *imageDecoderJPEG) decode() error {
 	}
 
 	if soi != markerSOI {
-		return nil
+		return errInvalidFormat
 	}
 
+	// Used to bounds check the segment lengths.
+	start := e.pos()
+	size, err := e.r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	e.seek(start)
+
 	// These are the sources we support.
 	sourceSet := EXIF | IPTC | XMP
 	// Remove sources that are not requested.
 	sourceSet = sourceSet & e.opts.Sources
 
+	// The MPF segment usually comes after the EXIF segment.
+	findMPF := e.opts.MPOAllImages && sourceSet.Has(EXIF)
+
+	// The trailing data is after the image data, so we need to find the SOS marker.
+	findSOS := e.opts.ScanTrailingData && sourceSet.Has(EXIF)
+
 	for {
-		if sourceSet.IsZero() {
+		if sourceSet.IsZero() && !findMPF && !findSOS {
 			// Done.
 			return nil
 		}
@@ -38,90 +120,198 @@ func (e *imageDecoderJPEG) decode() error {
 			return nil
 		}
 
+		if e.segmentLimitReached() {
+			return nil
+		}
+
 		if marker == 0 {
 			continue
 		}
 
 		if marker == markerSOS {
 			// Start of scan. We're done.
+			if findSOS {
+				e.sosPos = e.pos()
+			}
 			return nil
 		}
 
 		// Read the 16-bit length of the segment. The value includes the 2 bytes for the
 		// length itself, so we subtract 2 to get the number of remaining bytes.
 		length := e.read2()
+		if e.isEOF {
+			// The file ends right after the marker.
+			e.setSegmentErr(newInvalidFormatError(fmt.Errorf("JPEG segment 0x%x: %w", marker, io.ErrUnexpectedEOF)))
+			return nil
+		}
 		if length < 2 {
 			return errInvalidFormat
 		}
 		length -= 2
 
-		if marker == markerApp1EXIF && sourceSet.Has(EXIF) {
-			sourceSet = sourceSet.Remove(EXIF)
-			if err := e.handleEXIF(int64(length)); err != nil {
+		// A corrupt length or a truncated file would otherwise make us read or skip past EOF.
+		// Decode what's left of the segment, if anything.
+		var truncatedErr error
+		if remaining := size - e.pos(); int64(length) > remaining {
+			e.opts.Warnf("JPEG segment 0x%x has length %d, but only %d bytes are left", marker, length, remaining)
+			truncatedErr = newInvalidFormatError(fmt.Errorf("JPEG segment 0x%x: %w", marker, io.ErrUnexpectedEOF))
+			e.setSegmentErr(truncatedErr)
+			length = uint16(remaining)
+		}
+
+		if marker == markerApp1EXIF && (sourceSet.Has(EXIF) || sourceSet.Has(XMP)) {
+			// EXIF and XMP are both stored in APP1 segments, identified by the header.
+			end := e.pos() + int64(length)
+			if err := e.handleApp1(&sourceSet, int64(length)); err != nil {
+				if truncatedErr != nil && IsInvalidFormat(err) {
+					// E.g. an XMP packet that was cut off.
+					return truncatedErr
+				}
+				return err
+			}
+			e.seek(end)
+			continue
+		}
+
+		if marker == markerApp2 && findMPF {
+			end := e.pos() + int64(length)
+			if err := e.handleMPF(int64(length)); err != nil {
 				return err
 			}
+			findMPF = e.mpImages == nil
+			e.seek(end)
 			continue
 		}
 
 		if marker == markerApp13 && sourceSet.Has(IPTC) {
-			sourceSet = sourceSet.Remove(IPTC)
+			sourceSet = e.blockDone(sourceSet, IPTC)
+			e.result.addFoundSource(IPTC)
+			end := e.pos() + int64(length)
 			if err := e.handleIPTC(int(length)); err != nil {
 				return err
 			}
+			e.seek(end)
 			continue
 		}
 
-		if marker == markerrApp1XMP && sourceSet.Has(XMP) {
-			const xmpMarkerLen = 29
-			oldPos := e.pos()
-			b, err := e.readBytesVolatileE(xmpMarkerLen)
+		e.skip(int64(length))
+	}
+}
 
-			if err != nil && err != io.ErrUnexpectedEOF {
-				return err
-			}
+func (e *imageDecoderJPEG) handleApp1(sourceSet *Source, length int64) error {
+	const xmpMarkerLen = 29
+	n := int64(xmpMarkerLen)
+	if length < n {
+		n = length
+	}
+	oldPos := e.pos()
+	b, err := e.readBytesVolatileE(int(n))
+	if err != nil {
+		return err
+	}
 
-			if err == nil && bytes.Equal(b, markerXMP) {
-				length -= xmpMarkerLen
-				sourceSet = sourceSet.Remove(XMP)
-				r := io.LimitReader(e.r, int64(length))
-				if err := decodeXMP(r, e.opts); err != nil {
-					return err
-				}
-				continue
-			} else {
-				// Not XMP, rewind.
-				e.seek(oldPos)
-			}
+	markerPos, tiffPos, isEXIF := findEXIFHeader(b)
 
+	switch {
+	case isEXIF && sourceSet.Has(EXIF):
+		*sourceSet = e.blockDone(*sourceSet, EXIF)
+		e.result.addFoundSource(EXIF)
+		e.seek(oldPos)
+		err := e.handleEXIF(length, markerPos, tiffPos)
+		if err != nil && (IsInvalidFormat(err) || isInvalidFormatErrorCandidate(err)) {
+			// Keep looking for IPTC and XMP, which are often intact.
+			err = newInvalidFormatError(err)
+			e.opts.Warnf("failed to decode EXIF: %v", err)
+			e.setSegmentErr(err)
+			return nil
 		}
+		return err
+	case bytes.Equal(b, markerXMP) && sourceSet.Has(XMP):
+		// A file may have multiple XMP packets, so we keep looking.
+		// Any duplicate properties are overwritten by the last packet.
+		e.result.addFoundSource(XMP)
+		r := io.LimitReader(e.r, length-xmpMarkerLen)
+		return decodeXMP(r, e.opts, e.result)
+	}
 
-		e.skip(int64(length))
+	return nil
+}
+
+// findEXIFHeader looks for the Exif marker and the TIFF header that follows in b,
+// the start of an APP1 segment, allowing for up to maxEXIFPadding bytes of padding
+// before the marker and NUL padding after it.
+// If no TIFF byte order mark is found, tiffPos is the position right after the marker.
+func findEXIFHeader(b []byte) (markerPos, tiffPos int, found bool) {
+	n := maxEXIFPadding + len(markerEXIF)
+	if n > len(b) {
+		n = len(b)
+	}
+	markerPos = bytes.Index(b[:n], markerEXIF)
+	if markerPos < 0 {
+		return 0, 0, false
+	}
+	start := markerPos + len(markerEXIF)
+	for tiffPos = start; tiffPos+2 <= len(b) && tiffPos <= start+maxEXIFPadding; tiffPos++ {
+		if bom := binary.BigEndian.Uint16(b[tiffPos:]); bom == byteOrderBigEndian || bom == byteOrderLittleEndian {
+			return markerPos, tiffPos, true
+		}
+		if b[tiffPos] != 0 {
+			break
+		}
 	}
+	return markerPos, start, true
 }
 
-func (e *imageDecoderJPEG) handleEXIF(length int64) error {
+// handleEXIF decodes the EXIF in the APP1 segment at the current position,
+// with the Exif marker at markerPos and the TIFF header at tiffPos, see findEXIFHeader.
+func (e *imageDecoderJPEG) handleEXIF(length int64, markerPos, tiffPos int) (err error) {
 	thumbnailOffset := e.pos()
 	r, err := e.bufferedReader(length)
 	if err != nil {
 		return err
 	}
 	defer r.Close()
-	exifr := newMetaDecoderEXIF(r, e.byteOrder, thumbnailOffset, e.opts)
+	exifr := newMetaDecoderEXIF(r, e.byteOrder, thumbnailOffset, e.opts, e.result)
+
+	defer func() {
+		if r := recover(); r != nil {
+			if r != errStop {
+				panic(r)
+			}
+			// We ran out of data in the middle of the EXIF block.
+			cause := exifr.readErr
+			if cause == nil || cause == io.EOF {
+				cause = io.ErrUnexpectedEOF
+			}
+			err = newInvalidFormatError(cause)
+		}
+	}()
 
+	exifr.skip(int64(markerPos))
 	header := exifr.read4()
 	if header != exifHeader {
 		return err
 	}
-	exifr.skip(2)
+	exifr.skip(int64(tiffPos - markerPos - 4))
 
 	if err := exifr.decode(); err != nil {
 		return err
 	}
+	if exifr.isEOF {
+		// A read past the end of the EXIF block that was let through, see streamReader.stop.
+		return newInvalidFormatError(io.ErrUnexpectedEOF)
+	}
+	if exifr.preview.length > e.preview.length {
+		e.preview = exifr.preview
+	}
 	return nil
 }
 
-func (e *imageDecoderJPEG) handleIPTC(length int) error {
+func (e *imageDecoderJPEG) handleIPTC(length int) (err error) {
 	const headerLength = 14
+	if length < headerLength {
+		return nil
+	}
 	// Skip the IPTC header.
 	e.skip(headerLength)
 	r, err := e.bufferedReader(int64(length - headerLength))
@@ -130,5 +320,213 @@ func (e *imageDecoderJPEG) handleIPTC(length int) error {
 	}
 	defer r.Close()
 	dec := newMetaDecoderIPTC(r, e.opts)
+	defer func() {
+		if r := recover(); r != nil {
+			if r != errStop {
+				panic(r)
+			}
+			// The IPTC ran out of data, keep what we got and continue with the next segment.
+			err = nil
+		}
+	}()
 	return dec.decodeBlocks()
 }
+
+// handleMPF reads the image list from the MP Index IFD in an APP2 MPF segment.
+func (e *imageDecoderJPEG) handleMPF(length int64) error {
+	if length < int64(len(markerMPF))+8 {
+		return nil
+	}
+	b, err := e.readBytesVolatileE(len(markerMPF))
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(b, markerMPF) {
+		return nil
+	}
+	// The offsets in the MP entries are relative to the start of the TIFF header.
+	base := e.pos()
+	b, err = e.readBytesVolatileE(int(length) - len(markerMPF))
+	if err != nil {
+		return err
+	}
+
+	var byteOrder binary.ByteOrder
+	switch binary.BigEndian.Uint16(b) {
+	case byteOrderBigEndian:
+		byteOrder = binary.BigEndian
+	case byteOrderLittleEndian:
+		byteOrder = binary.LittleEndian
+	default:
+		return newInvalidFormatErrorf("invalid MPF byte order")
+	}
+
+	ifdOffset := int64(byteOrder.Uint32(b[4:]))
+	if ifdOffset+2 > int64(len(b)) {
+		return newInvalidFormatErrorf("invalid MPF IFD offset %d", ifdOffset)
+	}
+	numEntries := int64(byteOrder.Uint16(b[ifdOffset:]))
+	for i := int64(0); i < numEntries; i++ {
+		pos := ifdOffset + 2 + i*12
+		if pos+12 > int64(len(b)) {
+			return newInvalidFormatErrorf("invalid MPF IFD entry count %d", numEntries)
+		}
+		if byteOrder.Uint16(b[pos:]) != mpTagMPEntry {
+			continue
+		}
+		// Each MP entry is 16 bytes:
+		// attributes (4), size (4), offset (4), dependent image 1 (2), dependent image 2 (2).
+		count := int64(byteOrder.Uint32(b[pos+4:]))
+		offset := int64(byteOrder.Uint32(b[pos+8:]))
+		if offset+count > int64(len(b)) {
+			return newInvalidFormatErrorf("invalid MP entry offset %d", offset)
+		}
+		entries := b[offset : offset+count]
+		for len(entries) >= 16 {
+			img := mpImage{
+				size:   byteOrder.Uint32(entries[4:]),
+				offset: int64(byteOrder.Uint32(entries[8:])),
+			}
+			// The first image has offset 0, all others are relative to the TIFF header.
+			if img.offset != 0 {
+				img.offset += base
+			}
+			e.mpImages = append(e.mpImages, img)
+			entries = entries[16:]
+		}
+		break
+	}
+
+	return nil
+}
+
+// decodeMPImages decodes the EXIF data in the secondary images of an MPO file.
+// The tags are put in the Image{n} namespace, e.g. Image2/IFD0.
+func (e *imageDecoderJPEG) decodeMPImages() error {
+	handleTag := e.opts.HandleTag
+	for i, img := range e.mpImages {
+		if i == 0 || img.offset == 0 {
+			// The primary image is already decoded.
+			continue
+		}
+		prefix := fmt.Sprintf("Image%d", i+1)
+		opts := e.opts
+		opts.Sources = EXIF
+		opts.MPOAllImages = false
+		// The data after this image is the next MPO image, decoded in this loop.
+		opts.ScanTrailingData = false
+		// Only the preview of the primary image is passed on.
+		opts.HandlePreviewImage = nil
+		opts.HandleTag = func(ti TagInfo) error {
+			ti.Namespace = path.Join(prefix, ti.Namespace)
+			return handleTag(ti)
+		}
+		e.seek(img.offset)
+		dec := &imageDecoderJPEG{
+			baseStreamingDecoder: &baseStreamingDecoder{
+				streamReader: e.streamReader,
+				opts:         opts,
+			},
+		}
+		if err := dec.decode(); err != nil {
+			if err == errInvalidFormat {
+				e.opts.Warnf("MPO image %d at offset %d is not a JPEG", i+1, img.offset)
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeTrailingData decodes EXIF stored after the end of the main image,
+// either in an appended JPEG or in a bare APP1 segment, as written by e.g. some Samsung phones.
+// The tags are put in the Trailing namespace, e.g. Trailing/IFD0.
+func (e *imageDecoderJPEG) decodeTrailingData() error {
+	if e.sosPos == 0 {
+		return nil
+	}
+	e.seek(e.sosPos)
+	eoi, err := e.findEOI()
+	if err != nil || eoi < 0 {
+		return err
+	}
+	for _, img := range e.mpImages {
+		if img.offset == eoi && e.opts.MPOAllImages {
+			// Already decoded as an MPO image.
+			return nil
+		}
+	}
+
+	handleTag := e.opts.HandleTag
+	opts := e.opts
+	opts.Sources = EXIF
+	opts.MPOAllImages = false
+	opts.ScanTrailingData = false
+	opts.HandlePreviewImage = nil
+	opts.HandleTag = func(ti TagInfo) error {
+		ti.Namespace = path.Join("Trailing", ti.Namespace)
+		return handleTag(ti)
+	}
+	dec := &imageDecoderJPEG{
+		baseStreamingDecoder: &baseStreamingDecoder{
+			streamReader: e.streamReader,
+			opts:         opts,
+		},
+	}
+
+	e.seek(eoi)
+	marker, err := e.read2E()
+	if err != nil {
+		// Nothing after the EOI marker.
+		return nil
+	}
+	switch marker {
+	case markerSOI:
+		e.seek(eoi)
+		if err := dec.decode(); err != nil {
+			if err == errInvalidFormat {
+				e.opts.Warnf("invalid JPEG in trailing data at offset %d", eoi)
+				return nil
+			}
+			return err
+		}
+	case markerApp1EXIF:
+		length, err := e.read2E()
+		if err != nil || length < 2 {
+			return nil
+		}
+		sourceSet := EXIF
+		if err := dec.handleApp1(&sourceSet, int64(length-2)); err != nil {
+			return err
+		}
+		return dec.segmentErr
+	}
+
+	return nil
+}
+
+// findEOI returns the position after the first EOI marker from the current position,
+// or -1 if not found.
+func (e *imageDecoderJPEG) findEOI() (int64, error) {
+	const chunkSize = 32 << 10
+	pos := e.pos()
+	buf := make([]byte, chunkSize)
+	var prev byte
+	for {
+		n, err := e.r.Read(buf)
+		for i := 0; i < n; i++ {
+			if prev == 0xff && buf[i] == 0xd9 {
+				return pos + int64(i) + 1, nil
+			}
+			prev = buf[i]
+		}
+		pos += int64(n)
+		if err == io.EOF {
+			return -1, nil
+		}
+		if err != nil {
+			return -1, err
+		}
+	}
+}
@@ -7,8 +7,14 @@ import (
 	"bytes"
 	"compress/zlib"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
 )
 
 type imageDecoderPNG struct {
@@ -17,17 +23,33 @@ type imageDecoderPNG struct {
 
 // See https://exiftool.org/TagNames/PNG.html
 var (
+	pngSignature          = []byte("\x89PNG\r\n\x1a\n")
 	pngTagIDExif          = []byte("eXIf")
 	pngCompressedText     = []byte("zTXt") // See https://exiftool.org/forum/index.php?topic=7988.msg40759#msg40759
+	pngInternationalText  = []byte("iTXt")
+	pngText               = []byte("tEXt")
+	pngICCProfile         = []byte("iCCP")
+	pngCICP               = []byte("cICP")
+	pngKeywordXMP         = []byte("XML:com.adobe.xmp")
+	pngRawProfileType     = []byte("Raw profile type ")
 	pngRawProfileTypeIPTC = []byte("Raw profile type iptc")
 	pngRawProfileTypeEXIF = []byte("Raw profile type exif")
 )
 
+// pngNamespace is the namespace used for the PNGChunks tags.
+const pngNamespace = "PNG"
+
+// pngMaxDecompressedSize is the maximum size of the decompressed data in a zTXt, iTXt or iCCP chunk.
+// This guards against decompression bombs.
+const pngMaxDecompressedSize = 16 << 20
+
 func (e *imageDecoderPNG) decode() error {
-	// Skip header.
-	e.skip(8)
+	if !bytes.Equal(e.readBytesVolatile(len(pngSignature)), pngSignature) {
+		return errInvalidFormat
+	}
 
 	sources := e.opts.Sources
+	size := e.size()
 
 	skipTag := func(chunkLength uint32) {
 		e.skip(int64(chunkLength))
@@ -40,15 +62,20 @@ func (e *imageDecoderPNG) decode() error {
 		}
 		chunkLength := e.read4()
 		tagID := e.readBytesVolatile(4)
+		if remaining := size - e.pos(); int64(chunkLength) > remaining {
+			// Don't trust the length when reading the chunk into memory.
+			return newInvalidFormatError(fmt.Errorf("%w: PNG chunk %q of length %d with %d bytes left", io.ErrUnexpectedEOF, tagID, chunkLength, remaining))
+		}
 		if sources.Has(EXIF) && bytes.Equal(tagID, pngTagIDExif) {
-			sources = sources.Remove(EXIF)
+			sources = e.blockDone(sources, EXIF)
+			e.result.addFoundSource(EXIF)
 			if err := func() error {
 				r, err := e.bufferedReader(int64(chunkLength))
 				if err != nil {
 					return err
 				}
 				defer r.Close()
-				exifr := newMetaDecoderEXIF(r, e.byteOrder, 0, e.opts)
+				exifr := newMetaDecoderEXIF(r, e.byteOrder, 0, e.opts, e.result)
 				return exifr.decode()
 			}(); err != nil {
 				return err
@@ -62,7 +89,8 @@ func (e *imageDecoderPNG) decode() error {
 			// See https://exiftool.org/forum/index.php?topic=7988.msg40759#msg40759
 			if bytes.Equal(profileName, pngRawProfileTypeIPTC) {
 				if sources.Has(IPTC) {
-					sources = sources.Remove(IPTC)
+					sources = e.blockDone(sources, IPTC)
+					e.result.addFoundSource(IPTC)
 
 					dataLen := int(chunkLength) - int(profileNameLength)
 					if dataLen < 0 {
@@ -99,12 +127,160 @@ func (e *imageDecoderPNG) decode() error {
 				e.skip(int64(chunkLength) - profileNameLength)
 			}
 			e.skip(4) // skip CRC
+		} else if (sources.Has(XMP) || sources.Has(PNGChunks)) && bytes.Equal(tagID, pngInternationalText) {
+			// A file may have multiple XMP packets, so we keep looking.
+			keyword, text, err := decodeITXt(e.readBytesVolatile(int(chunkLength)))
+			if err != nil {
+				return newInvalidFormatError(fmt.Errorf("decoding iTXt: %w", err))
+			}
+			if bytes.Equal(keyword, pngKeywordXMP) {
+				if sources.Has(XMP) {
+					e.result.addFoundSource(XMP)
+					if err := decodeXMP(bytes.NewReader(text), e.opts, e.result); err != nil {
+						return err
+					}
+				}
+			} else if sources.Has(PNGChunks) {
+				if err := e.handleText(string(keyword), string(text)); err != nil {
+					return err
+				}
+			}
+			e.skip(4) // skip CRC
+		} else if (sources.Has(XMP) || sources.Has(PNGChunks)) && bytes.Equal(tagID, pngText) {
+			keyword, text, _ := bytes.Cut(e.readBytesVolatile(int(chunkLength)), []byte{0})
+			if bytes.Equal(keyword, pngKeywordXMP) {
+				if sources.Has(XMP) {
+					e.result.addFoundSource(XMP)
+					if err := decodeXMP(bytes.NewReader(text), e.opts, e.result); err != nil {
+						return err
+					}
+				}
+			} else if sources.Has(PNGChunks) && !bytes.HasPrefix(keyword, pngRawProfileType) {
+				// tEXt is Latin-1.
+				text, err := charmap.ISO8859_1.NewDecoder().Bytes(text)
+				if err != nil {
+					return newInvalidFormatError(fmt.Errorf("decoding tEXt: %w", err))
+				}
+				if err := e.handleText(string(keyword), string(text)); err != nil {
+					return err
+				}
+			}
+			e.skip(4) // skip CRC
+		} else if (sources.Has(PNGChunks) || e.opts.HandleICCProfile != nil) && bytes.Equal(tagID, pngICCProfile) {
+			if err := e.handleICCP(sources, e.readBytesVolatile(int(chunkLength))); err != nil {
+				return err
+			}
+			e.skip(4) // skip CRC
+		} else if sources.Has(PNGChunks) && bytes.Equal(tagID, pngCICP) && chunkLength == 4 {
+			b := e.readBytesVolatile(4)
+			for i, name := range pngCICPTagNames {
+				if err := e.handleTag(name, b[i]); err != nil {
+					return err
+				}
+			}
+			e.skip(4) // skip CRC
 		} else {
 			skipTag(chunkLength)
 		}
 	}
 }
 
+// The tag names for the 4 values in the cICP chunk.
+// See https://www.w3.org/TR/png/#cICP-chunk
+var pngCICPTagNames = []string{"ColorPrimaries", "TransferCharacteristics", "MatrixCoefficients", "VideoFullRangeFlag"}
+
+// handleICCP passes the profile name in the iCCP chunk in data on as a PNGChunks tag
+// and the decompressed ICC profile to HandleICCProfile, if set.
+// See https://www.w3.org/TR/png/#11iCCP
+func (e *imageDecoderPNG) handleICCP(sources Source, data []byte) error {
+	profileName, compressed, ok := bytes.Cut(data, []byte{0})
+	if !ok || len(compressed) == 0 {
+		return newInvalidFormatErrorf("decoding iCCP: missing profile name")
+	}
+	if sources.Has(PNGChunks) {
+		// The profile name is Latin-1.
+		name, err := charmap.ISO8859_1.NewDecoder().Bytes(profileName)
+		if err != nil {
+			return newInvalidFormatError(fmt.Errorf("decoding iCCP: %w", err))
+		}
+		if err := e.handleTag("ProfileName", string(name)); err != nil {
+			return err
+		}
+	}
+	if e.opts.HandleICCProfile == nil {
+		return nil
+	}
+	profile, err := decompressZTXt(compressed)
+	if err != nil {
+		return newInvalidFormatError(fmt.Errorf("decompressing iCCP: %w", err))
+	}
+	return e.opts.HandleICCProfile(bytes.NewReader(profile))
+}
+
+// handleText passes a PNG text chunk keyword/text pair on as a PNGChunks tag.
+func (e *imageDecoderPNG) handleText(keyword, text string) error {
+	return e.handleTag(pngTextTagName(keyword), text)
+}
+
+// handleTag passes a PNGChunks tag on to HandleTag.
+func (e *imageDecoderPNG) handleTag(name string, value any) error {
+	e.result.addFoundSource(PNGChunks)
+	tagInfo := TagInfo{
+		Source:    PNGChunks,
+		Tag:       name,
+		Namespace: pngNamespace,
+		Value:     value,
+	}
+	if !e.opts.ShouldHandleTag(tagInfo) {
+		return nil
+	}
+	return e.opts.HandleTag(tagInfo)
+}
+
+// pngTextTagName creates a tag name from a PNG text keyword, e.g. "Creation Time" => "CreationTime".
+// This matches what exiftool does.
+func pngTextTagName(keyword string) string {
+	var sb strings.Builder
+	for _, r := range keyword {
+		if r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r)) || r == '_' || r == '-' {
+			sb.WriteRune(r)
+		}
+	}
+	name := sb.String()
+	if name == "" {
+		return name
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// decodeITXt decodes the iTXt chunk in data and returns the keyword and the (decompressed) text.
+// See https://www.w3.org/TR/png/#11iTXt
+func decodeITXt(data []byte) (keyword, text []byte, err error) {
+	keyword, data, ok := bytes.Cut(data, []byte{0})
+	if !ok || len(data) < 2 {
+		return nil, nil, errors.New("missing keyword")
+	}
+	compressed := data[0] == 1
+	compressionMethod := data[1]
+	data = data[2:]
+
+	// Skip the language tag and the translated keyword.
+	for i := 0; i < 2; i++ {
+		if _, data, ok = bytes.Cut(data, []byte{0}); !ok {
+			return nil, nil, errors.New("missing null separator")
+		}
+	}
+
+	if compressed {
+		data, err = decompressZTXt(append([]byte{compressionMethod}, data...))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return keyword, data, nil
+}
+
 func decompressZTXt(data []byte) ([]byte, error) {
 	// The first byte indicates the compression method, for which only deflate is currently defined (method zero).
 	compressionMethod := data[0]
@@ -117,6 +293,12 @@ func decompressZTXt(data []byte) ([]byte, error) {
 		return nil, err
 	}
 	defer z.Close()
-	p, err := io.ReadAll(z)
-	return p, err
+	p, err := io.ReadAll(io.LimitReader(z, pngMaxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(p) > pngMaxDecompressedSize {
+		return nil, fmt.Errorf("decompressed size exceeds %d bytes", pngMaxDecompressedSize)
+	}
+	return p, nil
 }
@@ -5,6 +5,7 @@ package imagemeta
 
 import (
 	"encoding/binary"
+	"io"
 )
 
 type imageDecoderTIF struct {
@@ -14,6 +15,10 @@ type imageDecoderTIF struct {
 func (e *imageDecoderTIF) decode() error {
 	const (
 		meaningOfLife = 42
+
+		// BigTIFF, with 8 byte offsets.
+		// See https://www.awaresystems.be/imaging/tiff/bigtiff.html
+		meaningOfLifeBig = 43
 	)
 
 	byteOrderTag := e.read2()
@@ -26,19 +31,74 @@ func (e *imageDecoderTIF) decode() error {
 		return errInvalidFormat
 	}
 
-	if id := e.read2(); id != meaningOfLife {
+	var (
+		ifdOffset  int64
+		headerSize int64
+	)
+	version := e.read2()
+	switch version {
+	case meaningOfLife:
+		ifdOffset = int64(e.read4())
+		headerSize = 8
+	case meaningOfLifeBig:
+		// The size of the offsets, always 8, followed by 2 reserved bytes.
+		if offsetSize := e.read2(); offsetSize != 8 {
+			return errInvalidFormat
+		}
+		e.skip(2)
+		ifdOffset = int64(e.read8())
+		headerSize = 16
+	default:
 		return errInvalidFormat
 	}
 
-	ifdOffset := e.read4()
-
-	if ifdOffset < 8 {
+	if ifdOffset < headerSize {
 		return errInvalidFormat
 	}
 
-	e.skip(int64(ifdOffset - 8))
+	e.skip(ifdOffset - headerSize)
+
+	dec := newMetaDecoderEXIFFromStreamReader(e.streamReader, 0, e.opts, e.result)
+	dec.bigTIFF = version == meaningOfLifeBig
+	if e.opts.Sources.Has(EXIF) {
+		e.result.addFoundSource(EXIF)
+	}
+
+	if err := dec.decodeTags("IFD0"); err != nil {
+		return err
+	}
+
+	if e.opts.Sources.Has(IPTC) || e.opts.Sources.Has(XMP) {
+		if err := dec.decodeMetadataIFDs(ifdOffset); err != nil {
+			return err
+		}
+	}
+
+	if e.opts.HandlePreviewImage != nil && dec.preview.length > 0 {
+		e.seek(dec.preview.offset)
+		return e.opts.HandlePreviewImage(io.LimitReader(e.r, int64(dec.preview.length)))
+	}
+
+	return nil
+}
 
-	dec := newMetaDecoderEXIFFromStreamReader(e.streamReader, 0, e.opts)
+// ifdWalk holds the arguments to IFDWalker.WalkIFD.
+type ifdWalk struct {
+	byteOrder binary.ByteOrder
+	offset    int64
+	namespace string
+}
+
+// imageDecoderIFD decodes a bare IFD, see IFDWalker.
+type imageDecoderIFD struct {
+	*baseStreamingDecoder
+	walk *ifdWalk
+}
 
-	return dec.decodeTags("IFD0")
+func (e *imageDecoderIFD) decode() error {
+	e.byteOrder = e.walk.byteOrder
+	e.seek(e.walk.offset)
+	e.result.addFoundSource(EXIF)
+	dec := newMetaDecoderEXIFFromStreamReader(e.streamReader, 0, e.opts, e.result)
+	return dec.decodeTags(e.walk.namespace)
 }
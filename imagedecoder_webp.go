@@ -3,6 +3,8 @@
 
 package imagemeta
 
+import "bytes"
+
 var (
 	fccRIFF = fourCC{'R', 'I', 'F', 'F'}
 	fccWEBP = fourCC{'W', 'E', 'B', 'P'}
@@ -22,8 +24,6 @@ func (e *decoderWebP) decode() error {
 		return nil
 	}
 
-	var buf [10]byte
-
 	var chunkID fourCC
 	// Read the RIFF header.
 	e.readBytes(chunkID[:])
@@ -49,7 +49,13 @@ func (e *decoderWebP) decode() error {
 			return nil
 		}
 
+		if e.segmentLimitReached() {
+			return nil
+		}
+
 		chunkLen := e.read4()
+		// Chunks are padded to an even size.
+		chunkEnd := e.pos() + int64(chunkLen) + int64(chunkLen&1)
 
 		switch {
 		case chunkID == fccVP8X:
@@ -57,56 +63,51 @@ func (e *decoderWebP) decode() error {
 				return errInvalidFormat
 			}
 
-			const (
-				xmpMetadataBit  = 1 << 2
-				exifMetadataBit = 1 << 3
-			)
-
-			e.readBytes(buf[:])
-
-			hasEXIF := buf[0]&exifMetadataBit != 0
-			hasXMP := buf[0]&xmpMetadataBit != 0
-
-			if !hasEXIF {
-				sourceSet = sourceSet.Remove(EXIF)
-			}
-			if !hasXMP {
-				sourceSet = sourceSet.Remove(XMP)
-			}
-
-			if sourceSet.IsZero() {
-				return nil
-			}
+			// The VP8X chunk has feature flags for EXIF and XMP,
+			// but some writers don't set them, or put the EXIF and XMP chunks before it,
+			// so we ignore the flags and keep scanning for the chunks.
 		case chunkID == fccEXIF && sourceSet.Has(EXIF):
-			sourceSet = sourceSet.Remove(EXIF)
+			sourceSet = e.blockDone(sourceSet, EXIF)
+			e.result.addFoundSource(EXIF)
+			exifLen := int64(chunkLen)
+			if exifLen >= int64(len(markerEXIF)) {
+				// The chunk should start with the TIFF header,
+				// but some writers prepend the JPEG APP1 EXIF header.
+				pos := e.pos()
+				if bytes.Equal(e.readBytesVolatile(len(markerEXIF)), markerEXIF) {
+					exifLen -= int64(len(markerEXIF))
+				} else {
+					e.seek(pos)
+				}
+			}
 			thumbnailOffset := e.pos()
 			if err := func() error {
-				r, err := e.bufferedReader(int64(chunkLen))
+				r, err := e.bufferedReader(exifLen)
 				if err != nil {
 					return err
 				}
 				defer r.Close()
-				dec := newMetaDecoderEXIF(r, e.byteOrder, thumbnailOffset, e.opts)
+				dec := newMetaDecoderEXIF(r, e.byteOrder, thumbnailOffset, e.opts, e.result)
 				return dec.decode()
 			}(); err != nil {
 				return err
 			}
 
 		case chunkID == fccXMP && sourceSet.Has(XMP):
-			sourceSet = sourceSet.Remove(XMP)
+			// A file may have multiple XMP packets, so we keep looking.
+			e.result.addFoundSource(XMP)
 			if err := func() error {
 				r, err := e.bufferedReader(int64(chunkLen))
 				if err != nil {
 					return err
 				}
 				defer r.Close()
-				return decodeXMP(r, e.opts)
+				return decodeXMP(r, e.opts, e.result)
 			}(); err != nil {
 				return err
 			}
-
-		default:
-			e.skip(int64(chunkLen))
 		}
+
+		e.seek(chunkEnd)
 	}
 }
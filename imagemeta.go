@@ -9,8 +9,11 @@ import (
 	"io"
 	"math"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 // UnknownPrefix is used as prefix for unknown tags.
@@ -23,12 +26,18 @@ const (
 	IPTC
 	// XMP is the XMP tag source.
 	XMP
+	// PNGChunks is the source of the tags stored in the PNG chunks themselves,
+	// e.g. the tEXt and iTXt keywords, the iCCP profile name and the cICP values.
+	PNGChunks
 )
 
 var (
 	// ErrStopWalking is a sentinel error to signal that the walk should stop.
 	ErrStopWalking = fmt.Errorf("stop walking")
 
+	// ErrMaxTotalBytesExceeded is returned when more than Options.MaxTotalBytes is read from the source.
+	ErrMaxTotalBytesExceeded = fmt.Errorf("max total bytes exceeded")
+
 	// Internal error to signal that we should stop any further processing.
 	errStop = fmt.Errorf("stop")
 )
@@ -46,8 +55,16 @@ const (
 	WebP
 )
 
-// Decode reads EXIF and IPTC metadata from r and returns a Meta struct.
-func Decode(opts Options) (err error) {
+// Decode reads EXIF, IPTC and XMP metadata from opts.R and passes the tags to opts.HandleTag.
+// Use DecodeWithResult to also get the additional information found while decoding.
+func Decode(opts Options) error {
+	_, err := DecodeWithResult(opts)
+	return err
+}
+
+// DecodeWithResult is like Decode, but also returns a DecodeResult with any
+// additional information found while decoding, e.g. the sources found.
+func DecodeWithResult(opts Options) (result DecodeResult, err error) {
 	var base *baseStreamingDecoder
 
 	defer func() {
@@ -97,55 +114,50 @@ func Decode(opts Options) (err error) {
 	}()
 
 	if opts.R == nil {
-		return fmt.Errorf("no reader provided")
+		return result, fmt.Errorf("no reader provided")
 	}
 	if opts.ImageFormat == ImageFormatAuto {
-		return fmt.Errorf("no image format provided; format detection not implemented yet")
+		return result, fmt.Errorf("no image format provided; format detection not implemented yet")
 	}
-	if opts.ShouldHandleTag == nil {
-		opts.ShouldHandleTag = func(ti TagInfo) bool {
-			if ti.Source != EXIF {
-				return true
+	if opts.CollectWarnings {
+		warnf := opts.Warnf
+		opts.Warnf = func(format string, args ...any) {
+			result.Warnings = append(result.Warnings, fmt.Sprintf(format, args...))
+			if warnf != nil {
+				warnf(format, args...)
 			}
-			// Skip all tags in the thumbnails IFD (IFD1).
-			return strings.HasPrefix(ti.Namespace, "IFD0")
 		}
 	}
 
-	if opts.HandleTag == nil {
-		opts.HandleTag = func(TagInfo) error { return nil }
-	}
-
-	if opts.Sources == 0 {
-		opts.Sources = EXIF | IPTC | XMP
+	opts = opts.withDefaults()
+
+	if opts.AllowDuplicateBlocks {
+		// Merge the EXIF blocks, e.g. IFD0 in one and the thumbnail IFD in another,
+		// without passing the same tag on twice. The first tag wins.
+		seen := make(map[string]bool)
+		handleTag := opts.HandleTag
+		opts.HandleTag = func(ti TagInfo) error {
+			if ti.Source == EXIF {
+				key := ti.Namespace + "/" + ti.Tag
+				if seen[key] {
+					return nil
+				}
+				seen[key] = true
+			}
+			return handleTag(ti)
+		}
 	}
 
-	if opts.Warnf == nil {
-		opts.Warnf = func(string, ...any) {}
+	info, found := formatInfo(opts.ImageFormat)
+	if !found {
+		return result, fmt.Errorf("unsupported image format")
 	}
 
-	var sourceSet Source
-
 	// Remove sources not supported by the format.
-	switch opts.ImageFormat {
-	case JPEG:
-		sourceSet = EXIF | XMP | IPTC
-	case TIFF:
-		sourceSet = EXIF | XMP | IPTC
-	case WebP:
-		sourceSet = EXIF | XMP
-	case PNG:
-		sourceSet = EXIF | XMP | IPTC
-	default:
-		return fmt.Errorf("unsupported image format")
-
-	}
-	// Remove sources that are not requested.
-	sourceSet = sourceSet & opts.Sources
-	opts.Sources = sourceSet
+	opts.Sources = opts.Sources & info.Sources
 
 	if opts.Sources.IsZero() {
-		return nil
+		return
 	}
 
 	br := &streamReader{
@@ -153,9 +165,14 @@ func Decode(opts Options) (err error) {
 		byteOrder: binary.BigEndian,
 	}
 
+	if opts.MaxTotalBytes > 0 {
+		br.r = &limitedReadSeeker{ReadSeeker: opts.R, max: opts.MaxTotalBytes}
+	}
+
 	base = &baseStreamingDecoder{
 		streamReader: br,
 		opts:         opts,
+		result:       &result,
 	}
 
 	var dec decoder
@@ -164,7 +181,11 @@ func Decode(opts Options) (err error) {
 	case JPEG:
 		dec = &imageDecoderJPEG{baseStreamingDecoder: base}
 	case TIFF:
-		dec = &imageDecoderTIF{baseStreamingDecoder: base}
+		if opts.walkIFD != nil {
+			dec = &imageDecoderIFD{baseStreamingDecoder: base, walk: opts.walkIFD}
+		} else {
+			dec = &imageDecoderTIF{baseStreamingDecoder: base}
+		}
 	case WebP:
 		base.byteOrder = binary.LittleEndian
 		dec = &decoderWebP{baseStreamingDecoder: base}
@@ -202,9 +223,393 @@ func Decode(opts Options) (err error) {
 		err = dec.decode()
 	}
 
+	if err == nil && result.motionPhotoTrailer > 0 {
+		// The video is stored at the end of the file.
+		if size, err := opts.R.Seek(0, io.SeekEnd); err == nil && result.motionPhotoTrailer <= size {
+			result.MotionPhoto.VideoOffset = size - result.motionPhotoTrailer
+			result.MotionPhoto.VideoLength = result.motionPhotoTrailer
+		}
+	}
+
 	return
 }
 
+// withDefaults returns a copy of opts with defaults set for any unset
+// ShouldHandleTag, HandleTag, Sources and Warnf, and HandleTag wrapped
+// to apply NormalizeDates and ValueConverters.
+func (opts Options) withDefaults() Options {
+	if opts.ShouldHandleTag == nil {
+		opts.ShouldHandleTag = func(ti TagInfo) bool {
+			if ti.Source != EXIF {
+				return true
+			}
+			// Skip all tags in the thumbnails IFD (IFD1).
+			return strings.HasPrefix(ti.Namespace, "IFD0")
+		}
+	}
+
+	if opts.HandleTag == nil {
+		opts.HandleTag = func(TagInfo) error { return nil }
+	}
+
+	if opts.Sources == 0 {
+		opts.Sources = EXIF | IPTC | XMP | PNGChunks
+	}
+
+	if opts.Warnf == nil {
+		opts.Warnf = func(string, ...any) {}
+	}
+
+	if len(opts.ValueConverters) > 0 {
+		handleTag := opts.HandleTag
+		opts.HandleTag = func(ti TagInfo) error {
+			if convert, found := opts.ValueConverters[ti.Tag]; found {
+				ti.Value = convert(ti)
+			}
+			return handleTag(ti)
+		}
+	}
+
+	if opts.NormalizeDates {
+		// This runs before any custom value converters.
+		handleTag := opts.HandleTag
+		opts.HandleTag = func(ti TagInfo) error {
+			if t, ok := normalizeDate(ti); ok {
+				ti.Value = t
+			}
+			return handleTag(ti)
+		}
+	}
+
+	return opts
+}
+
+// FormatInfo describes a supported image format.
+type FormatInfo struct {
+	// The image format, e.g. JPEG.
+	Format ImageFormat
+
+	// The name of the format, e.g. "JPEG".
+	Name string
+
+	// The metadata sources that can be decoded from this format.
+	Sources Source
+
+	// The common file extensions, with a leading dot, e.g. ".jpg".
+	Extensions []string
+}
+
+var supportedFormats = []FormatInfo{
+	{Format: JPEG, Name: "JPEG", Sources: EXIF | IPTC | XMP, Extensions: []string{".jpg", ".jpeg"}},
+	{Format: TIFF, Name: "TIFF", Sources: EXIF | IPTC | XMP, Extensions: []string{".tif", ".tiff"}},
+	{Format: PNG, Name: "PNG", Sources: EXIF | IPTC | XMP | PNGChunks, Extensions: []string{".png"}},
+	{Format: WebP, Name: "WebP", Sources: EXIF | XMP, Extensions: []string{".webp"}},
+}
+
+// SupportedFormats returns the image formats supported by Decode.
+func SupportedFormats() []FormatInfo {
+	formats := make([]FormatInfo, len(supportedFormats))
+	for i, f := range supportedFormats {
+		f.Extensions = append([]string(nil), f.Extensions...)
+		formats[i] = f
+	}
+	return formats
+}
+
+func formatInfo(format ImageFormat) (FormatInfo, bool) {
+	for _, f := range supportedFormats {
+		if f.Format == format {
+			return f, true
+		}
+	}
+	return FormatInfo{}, false
+}
+
+// ProbeResult holds the metadata found by Probe.
+type ProbeResult struct {
+	// The sources found in the image.
+	Sources Source
+
+	// Whether the EXIF data has GPS information.
+	HasGPS bool
+}
+
+// Probe walks the image in opts.R and reports which metadata sources it contains
+// (limited to opts.Sources) without decoding any tag values.
+// This is much faster than Decode.
+// HandleTag, ShouldHandleTag, HandleXMP, HandleICCProfile and HandleThumbnail in opts are ignored.
+func Probe(opts Options) (ProbeResult, error) {
+	opts.probe = true
+	opts.HandleTag = nil
+	opts.HandleXMP = nil
+	opts.HandleICCProfile = nil
+	opts.HandleThumbnail = nil
+	opts.ShouldHandleTag = func(TagInfo) bool { return false }
+	result, err := DecodeWithResult(opts)
+	return ProbeResult{Sources: result.FoundSources, HasGPS: result.hasGPS}, err
+}
+
+// DecodeTIFFReader decodes the TIFF structure in r, which must start with the TIFF header.
+// This is useful when the TIFF structure is embedded in a container that the caller parses,
+// e.g. a RAW format. Any R and ImageFormat in opts are replaced.
+func DecodeTIFFReader(r io.ReadSeeker, opts Options) (DecodeResult, error) {
+	opts.R = r
+	opts.ImageFormat = TIFF
+	return DecodeWithResult(opts)
+}
+
+// IFDWalker walks a bare TIFF IFD structure, e.g. one embedded in a container format
+// that Decode doesn't support.
+type IFDWalker struct {
+	// The options to use when decoding the tags, e.g. ShouldHandleTag, Warnf and ValueConverters.
+	// R, ImageFormat, Sources and HandleTag are ignored.
+	// If ShouldHandleTag is not set, all tags are handled.
+	Options Options
+}
+
+// WalkIFD decodes the IFD at offset in r and passes its tags to handle,
+// including the tags in any sub-IFDs (e.g. ExifIFDP) it points to.
+// The offsets, including offset itself, are relative to the start of r,
+// which is usually the start of the TIFF header.
+// The namespace is the namespace of the IFD's tags, e.g. "IFD0",
+// sub-IFDs are put below it. Use "GPSInfoIFD" as the last path element to decode GPS tags.
+func (w IFDWalker) WalkIFD(r io.ReadSeeker, byteOrder binary.ByteOrder, offset int64, namespace string, handle HandleTagFunc) error {
+	opts := w.Options
+	opts.R = r
+	opts.ImageFormat = TIFF
+	opts.Sources = EXIF
+	opts.HandleTag = handle
+	if opts.ShouldHandleTag == nil {
+		opts.ShouldHandleTag = func(TagInfo) bool { return true }
+	}
+	opts.walkIFD = &ifdWalk{byteOrder: byteOrder, offset: offset, namespace: namespace}
+	err := Decode(opts)
+	return err
+}
+
+// DecodeXMPFile decodes a standalone XMP file in r, e.g. an .xmp sidecar written by Lightroom
+// next to a RAW file, and passes the tags to opts.HandleTag.
+// Any R and ImageFormat in opts are ignored.
+func DecodeXMPFile(r io.Reader, opts Options) error {
+	opts = opts.withDefaults()
+	if !opts.Sources.Has(XMP) {
+		return nil
+	}
+	err := decodeXMP(r, opts, nil)
+	if err == ErrStopWalking {
+		return nil
+	}
+	return err
+}
+
+// DecodeAt is like DecodeWithResult, but reads from the first size bytes of r instead of opts.R, which is replaced.
+// Each call reads through its own position-tracking reader, so it's safe to run
+// several decodes concurrently over the same r, e.g. a shared *bytes.Reader or a memory mapped file.
+func DecodeAt(r io.ReaderAt, size int64, opts Options) (DecodeResult, error) {
+	opts.R = io.NewSectionReader(r, 0, size)
+	return DecodeWithResult(opts)
+}
+
+// Orientation is the EXIF Orientation, which tells how the image needs to be
+// rotated and/or flipped to be displayed correctly.
+type Orientation int
+
+const (
+	// OrientationUnknown means that the Orientation tag is missing or has an invalid value.
+	OrientationUnknown Orientation = iota
+	OrientationNormal
+	OrientationFlipHorizontal
+	OrientationRotate180
+	OrientationFlipVertical
+	OrientationTranspose
+	OrientationRotate90CW
+	OrientationTransverse
+	OrientationRotate270CW
+)
+
+// DecodeOrientation decodes the EXIF Orientation tag in IFD0 of the image in r.
+// It stops reading as soon as the tag is found, which makes it much faster than Decode.
+// OrientationUnknown is returned if the tag is not found.
+func DecodeOrientation(r io.ReadSeeker, format ImageFormat) (Orientation, error) {
+	orientation := OrientationUnknown
+	err := Decode(Options{
+		R:           r,
+		ImageFormat: format,
+		Sources:     EXIF,
+		ShouldHandleTag: func(ti TagInfo) bool {
+			return ti.Tag == "Orientation" && ti.Namespace == "IFD0"
+		},
+		HandleTag: func(ti TagInfo) error {
+			if v, ok := toInt(ti.Value); ok && v >= int(OrientationNormal) && v <= int(OrientationRotate270CW) {
+				orientation = Orientation(v)
+			}
+			return ErrStopWalking
+		},
+	})
+	return orientation, err
+}
+
+// DecodeTags is a convenience function that decodes opts.R and collects the tags into a Tags struct.
+// Any HandleTag function in opts is replaced.
+func DecodeTags(opts Options) (Tags, DecodeResult, error) {
+	var tags Tags
+	opts.HandleTag = func(ti TagInfo) error {
+		tags.Add(ti)
+		return nil
+	}
+	result, err := DecodeWithResult(opts)
+	return tags, result, err
+}
+
+// DecodeMap is a convenience function that decodes opts.R and returns all the tags in one map, see Tags.All.
+// Any HandleTag function in opts is replaced.
+func DecodeMap(opts Options) (map[string]TagInfo, error) {
+	tags, _, err := DecodeTags(opts)
+	return tags.All(), err
+}
+
+// DecodeResult is the result of a DecodeWithResult operation.
+// It holds information found while decoding in addition to the tags passed to HandleTag.
+type DecodeResult struct {
+	// The dimensions of the EXIF thumbnail (IFD1), if any.
+	// This is read regardless of ShouldHandleTag.
+	ThumbnailConfig ImageConfig
+
+	// The sources found and decoded in the image.
+	// Combine this with Options.Sources to tell if e.g. XMP was requested, but not found.
+	FoundSources Source
+
+	// The embedded video in a Motion Photo, as described in the XMP.
+	MotionPhoto MotionPhoto
+
+	// The warnings, set if Options.CollectWarnings is set.
+	Warnings []string
+
+	// The XMP packet, set if Options.KeepRawXMP is set.
+	// If the image has multiple XMP packets, this is the last one.
+	// Note that the extended XMP in JPEG, split over multiple APP1 segments, is not
+	// reassembled nor included here.
+	RawXMP []byte
+
+	// Whether the EXIF data has a pointer to the GPS IFD, set when probing.
+	hasGPS bool
+
+	// The length of the Motion Photo video at the end of the file.
+	motionPhotoTrailer int64
+}
+
+// addFoundSource marks source as found. r may be nil.
+func (r *DecodeResult) addFoundSource(source Source) {
+	if r == nil {
+		return
+	}
+	r.FoundSources = r.FoundSources | source
+}
+
+// SubjectArea is the location and area of the main subject in the image, see the EXIF SubjectArea tag.
+type SubjectArea struct {
+	// One of SubjectAreaPoint, SubjectAreaCircle or SubjectAreaRectangle.
+	Kind string
+
+	// The center of the subject area in pixels.
+	X, Y int
+
+	// The width and height of the area. For circles, both are set to the diameter.
+	W, H int
+}
+
+const (
+	SubjectAreaPoint     = "point"
+	SubjectAreaCircle    = "circle"
+	SubjectAreaRectangle = "rectangle"
+)
+
+// Matrix is a matrix stored in row-major order, e.g. a DNG ColorMatrix1.
+type Matrix struct {
+	Rows, Cols int
+	Values     []float64
+}
+
+// Opcode is an image processing step in a DNG OpcodeList1, OpcodeList2 or OpcodeList3 tag.
+// See the DNG specification, chapter 7.
+type Opcode struct {
+	// The opcode ID, e.g. OpcodeWarpRectilinear.
+	ID uint32
+
+	// The DNG version the opcode was introduced in, e.g. "1.3.0.0".
+	Version string
+
+	// Bit 0 is set if the opcode is optional,
+	// bit 1 if it can be skipped when rendering a preview.
+	Flags uint32
+
+	// The decoded parameters, *WarpRectilinearParams or *FixVignetteRadialParams
+	// for those opcodes, the raw []byte for the others.
+	Params any
+}
+
+// The DNG opcode IDs.
+const (
+	OpcodeWarpRectilinear      = 1
+	OpcodeWarpFisheye          = 2
+	OpcodeFixVignetteRadial    = 3
+	OpcodeFixBadPixelsConstant = 4
+	OpcodeFixBadPixelsList     = 5
+	OpcodeTrimBounds           = 6
+	OpcodeMapTable             = 7
+	OpcodeMapPolynomial        = 8
+	OpcodeGainMap              = 9
+	OpcodeDeltaPerRow          = 10
+	OpcodeDeltaPerColumn       = 11
+	OpcodeScalePerRow          = 12
+	OpcodeScalePerColumn       = 13
+	OpcodeWarpRectilinear2     = 14
+)
+
+// WarpRectilinearParams holds the parameters of the WarpRectilinear opcode,
+// which corrects lens distortion.
+type WarpRectilinearParams struct {
+	// The coefficients for each plane, or one set for all planes.
+	Planes []WarpRectilinearPlane
+
+	// The optical center, relative to the image (0.5, 0.5 is the center).
+	CenterX, CenterY float64
+}
+
+// WarpRectilinearPlane holds the WarpRectilinear coefficients for one plane.
+type WarpRectilinearPlane struct {
+	Radial     [4]float64 // kr0 to kr3.
+	Tangential [2]float64 // kt0 and kt1.
+}
+
+// FixVignetteRadialParams holds the parameters of the FixVignetteRadial opcode.
+type FixVignetteRadialParams struct {
+	// The coefficients k0 to k4 of the radial gain polynomial.
+	K [5]float64
+
+	// The optical center, relative to the image (0.5, 0.5 is the center).
+	CenterX, CenterY float64
+}
+
+// LensInfo describes the lens used to take the image, see Tags.Lens.
+type LensInfo struct {
+	Make  string
+	Model string
+
+	// The minimum and maximum focal length in millimeters and the minimum
+	// f-number at those, space delimited, e.g. "16 50 2.8 2.8".
+	// Unknown values are set to "undef".
+	Spec string
+
+	Serial string
+}
+
+// ImageConfig holds the dimensions of an image.
+type ImageConfig struct {
+	Width  int
+	Height int
+}
+
 // HandleTagFunc is the function that is called for each tag.
 type HandleTagFunc func(info TagInfo) error
 
@@ -241,6 +646,148 @@ type Options struct {
 	// Warnf will be called for each warning.
 	Warnf func(string, ...any)
 
+	// If set, the warnings are also collected in DecodeResult.Warnings.
+	CollectWarnings bool
+
+	// If set, the EXIF MakerNote will be decoded into vendor specific tags (e.g. "Apple.RunTime")
+	// if the format is known. Unknown formats are passed on as the raw MakerNote tag.
+	// The tags are put in a namespace below the IFD containing the MakerNote, e.g. "IFD0/ExifIFDP/Apple".
+	DecodeMakerNotes bool
+
+	// If set, the Namespace of XMP tags will be set to the conventional short prefix (e.g. "crs")
+	// instead of the namespace URI (e.g. "http://ns.adobe.com/camera-raw-settings/1.0/").
+	// Namespaces not known by the decoder will keep the URI.
+	XMPNamespacePrefixes bool
+
+	// If set, XMP tags will be named using the namespace prefix, e.g. "exif:DateTimeOriginal",
+	// so properties with the same name in different namespaces don't overwrite each other in Tags.
+	// Known namespaces use the conventional prefix, others the prefix declared in the XMP packet.
+	XMPQualifiedNames bool
+
+	// If set, the known numeric properties in the XMP Camera Raw Settings (crs) namespace,
+	// e.g. Exposure2012, are converted to int or float64, and the boolean properties,
+	// e.g. AlreadyApplied, to bool. Other XMP values are strings.
+	XMPCameraRawTypes bool
+
+	// If set, the XMP Dublin Core (dc) creator and rights elements are passed to HandleTag
+	// as "creator" ([]string) and "rights" (the x-default string), as used by Tags.Creator and Tags.Copyright.
+	// The dc namespace is skipped by default.
+	DecodeXMPDublinCore bool
+
+	// If set, the XMP packet is stored in DecodeResult.RawXMP, in addition to being
+	// decoded as set up by HandleXMP and HandleTag.
+	KeepRawXMP bool
+
+	// If set, the decoder will call this function with a reader over the largest embedded JPEG preview image, if any.
+	// This is currently only supported for TIFF based formats (e.g. DNG), and EXIF must be in Sources.
+	// If DecodeMakerNotes is set, the previews referenced by a known MakerNote (e.g. Canon PreviewImageInfo)
+	// are also considered, which also works for JPEG.
+	HandlePreviewImage func(r io.Reader) error
+
+	// If set, the decoder will call this function with a reader over the EXIF thumbnail (IFD1), if any.
+	// This is usually a JPEG, but may be an uncompressed image stored in strips (e.g. in TIFF files),
+	// in which case the reader returns the strips in order; see the IFD1 tags for its format.
+	// This is currently only supported for the EXIF block in JPEG, PNG and WebP, and EXIF must be in Sources.
+	HandleThumbnail func(r io.Reader) error
+
+	// If set, the decoder will call this function with a reader over the embedded ICC profile, if any.
+	// This is currently only supported for PNG (the iCCP chunk).
+	HandleICCProfile func(r io.Reader) error
+
+	// By default, only the first EXIF and IPTC block found in the file is decoded,
+	// any duplicates (e.g. two APP1 EXIF segments in a JPEG) are ignored.
+	// If set, all blocks are decoded, and the tags are passed to HandleTag in the order found.
+	// The EXIF blocks are merged: a tag with the same namespace and name as a tag in an earlier block is skipped.
+	// Note that all XMP packets are always decoded.
+	AllowDuplicateBlocks bool
+
+	// If set, some tags will be decoded into structured values instead of their string form,
+	// e.g. SubjectArea will be a SubjectArea struct instead of "1234 567 100 80",
+	// rational lists such as PrimaryChromaticities will be a []Rat[uint32],
+	// GPSTimeStamp will be a time.Duration (the time of day in UTC),
+	// and the DNG OpcodeList1, OpcodeList2 and OpcodeList3 will be a []Opcode.
+	DecodeStructured bool
+
+	// If set, some enumerated values will be decoded into their labels instead of the numeric value,
+	// e.g. ColorSpace will be "Adobe RGB" instead of 2. The labels are the same as exiftool uses.
+	DecodeEnumLabels bool
+
+	// If set, the GeoTIFF tags are decoded into structured values:
+	// PixelScale, ModelTiePoint and ModelTransform will be a []float64,
+	// and GeoTiffDirectory will be a map[string]any from GeoKey name (e.g. "GTModelType") to value,
+	// with the values stored in GeoTiffDoubleParams and GeoTiffAsciiParams resolved.
+	DecodeGeoTIFF bool
+
+	// If set, EXIF is also decoded from the secondary images in a JPEG MPO (multi-picture) file.
+	// These tags are passed to HandleTag with the namespace prefixed with Image{n}, e.g. "Image2/IFD0".
+	// Note that ShouldHandleTag is called with the namespace without this prefix.
+	// The default is to only decode the primary image.
+	MPOAllImages bool
+
+	// If set, the data after the end of the main JPEG image is scanned for EXIF,
+	// either in an appended JPEG or in a bare APP1 segment, as written by e.g. some Samsung phones.
+	// These tags are passed to HandleTag with the namespace prefixed with "Trailing", e.g. "Trailing/IFD0".
+	// Note that this reads through the compressed image data, so consider setting MaxTotalBytes
+	// for untrusted input.
+	ScanTrailingData bool
+
+	// If set, decoding fails with ErrMaxTotalBytesExceeded when more than this number of bytes
+	// has been read from R in total, including any bytes read more than once.
+	// This is useful to bound the work done on untrusted input.
+	MaxTotalBytes int64
+
+	// The maximum number of JPEG segments or WebP chunks to scan.
+	// When reached, a warning is logged and the scanning stops, keeping the tags found so far.
+	// This guards against crafted files with lots of tiny segments.
+	// If 0, a default of 65536 is used.
+	MaxSegments int
+
+	// If set, the MakerNote tag is passed to HandleTag with the name "MakerNote" and the raw []byte
+	// (which encoding/json marshals as base64) instead of a printable string.
+	// This is also done when DecodeMakerNotes is set and the format is known.
+	// Note that, as for other tags, MakerNotes larger than 64 KB are skipped.
+	KeepMakerNoteRaw bool
+
+	// If set, the original MakerNote stored by Adobe's DNG converter in the DNGPrivateData tag
+	// is decoded into vendor specific tags if the format is known, as with DecodeMakerNotes.
+	// The tags are put in a namespace below the IFD, e.g. "IFD0/DNGPrivateData/Canon".
+	// The DNGPrivateData tag itself is then not passed to HandleTag.
+	DecodeDNGPrivate bool
+
+	// If set, the date tags, e.g. the EXIF DateTimeOriginal, the IPTC DateCreated and the XMP CreateDate,
+	// are passed to HandleTag as time.Time values instead of strings in the format used by each source.
+	// EXIF and IPTC dates have no time zone and are returned in UTC; use Tags.GetDateTime
+	// to get the EXIF date in the correct time zone.
+	// Values that can not be parsed are passed on as is.
+	NormalizeDates bool
+
+	// The EXIF sub-IFDs to skip, e.g. []string{"GPSInfoIFD", "InteroperabilityIFD"}.
+	// The valid names are "ExifIFDP", "GPSInfoIFD" and "InteroperabilityIFD".
+	// This is faster than filtering out the tags in ShouldHandleTag, as the IFD is never read.
+	SkipIFDs []string
+
+	// If set, only the EXIF tags in the IFD with this namespace, e.g. "IFD0/GPSInfoIFD", are decoded,
+	// and of the other IFDs only those on the path to it are read.
+	// This is faster than filtering on the namespace in ShouldHandleTag, as the sibling IFDs are never read.
+	// Note that ShouldHandleTag is still called for the tags in this IFD.
+	OnlyIFD string
+
+	// If set, EXIF tags that belong in either IFD0 or the Exif IFD are reported in that namespace
+	// (e.g. "IFD0/ExifIFDP" for ExposureTime), as exiftool does, even if the file has them in the other IFD.
+	// Tags in other IFDs, e.g. the thumbnail IFD (IFD1), are not moved.
+	CanonicalNamespaces bool
+
+	// Custom value converters keyed by tag name, e.g. "UserComment".
+	// The converter is called with the tag after any built-in conversion,
+	// and the returned value is passed to HandleTag.
+	ValueConverters map[string]func(TagInfo) any
+
+	// Set by Probe to only detect the metadata sources present.
+	probe bool
+
+	// Set by IFDWalker to decode a bare IFD.
+	walkIFD *ifdWalk
+
 	// Timeout is the maximum time the decoder will spend on reading metadata.
 	// Mostly useful for testing.
 	// If set to 0, the decoder will not time out.
@@ -253,6 +800,12 @@ type TagInfo struct {
 	Source Source
 	// The tag name.
 	Tag string
+	// The numeric tag ID, e.g. 0x0112 for the EXIF Orientation tag.
+	// For EXIF, this is the ID within the IFD in Namespace, so GPS and
+	// Interoperability tags may share IDs with tags in other IFDs.
+	// For IPTC, this is the dataset number.
+	// This is 0 for XMP and other tags without a numeric ID.
+	ID uint16
 	// The tag namespace.
 	// For EXIF, this is the path to the IFD, e.g. "IFD0/GPSInfoIFD"
 	// For XMP, this is the namespace, e.g. "http://ns.adobe.com/camera-raw-settings/1.0/"
@@ -283,11 +836,39 @@ func (t Source) IsZero() bool {
 	return t == 0
 }
 
+// sources holds all the tag sources.
+var sources = []Source{EXIF, IPTC, XMP, PNGChunks}
+
+// ParseSources parses a comma separated list of source names (e.g. "EXIF,XMP") into a Source.
+// The names are case insensitive.
+func ParseSources(s string) (Source, error) {
+	var result Source
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		var found bool
+		for _, source := range sources {
+			if strings.EqualFold(name, source.String()) {
+				result |= source
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown source %q", name)
+		}
+	}
+	return result, nil
+}
+
 // Tags is a collection of tags grouped per source.
 type Tags struct {
-	exif map[string]TagInfo
-	iptc map[string]TagInfo
-	xmp  map[string]TagInfo
+	exif      map[string]TagInfo
+	iptc      map[string]TagInfo
+	xmp       map[string]TagInfo
+	pngChunks map[string]TagInfo
 }
 
 // Add adds a tag to the correct source.
@@ -301,6 +882,22 @@ func (t *Tags) Has(tag TagInfo) bool {
 	return found
 }
 
+// Merge adds the tags in other to t, source by source,
+// e.g. to combine the tags from an image with the tags from its XMP sidecar.
+// If overwrite is set, a tag in other replaces a tag in t with the same source and name,
+// else the tag in t is kept.
+func (t *Tags) Merge(other Tags, overwrite bool) {
+	for _, source := range sources {
+		m := t.getSourceMap(source)
+		for name, tag := range other.getSourceMap(source) {
+			if _, found := m[name]; found && !overwrite {
+				continue
+			}
+			m[name] = tag
+		}
+	}
+}
+
 // EXIF returns the EXIF tags.
 func (t *Tags) EXIF() map[string]TagInfo {
 	if t.exif == nil {
@@ -325,6 +922,14 @@ func (t *Tags) XMP() map[string]TagInfo {
 	return t.xmp
 }
 
+// PNGChunks returns the tags stored in the PNG chunks themselves, e.g. the tEXt keywords.
+func (t *Tags) PNGChunks() map[string]TagInfo {
+	if t.pngChunks == nil {
+		t.pngChunks = make(map[string]TagInfo)
+	}
+	return t.pngChunks
+}
+
 // All returns all tags in a map.
 func (t Tags) All() map[string]TagInfo {
 	all := make(map[string]TagInfo)
@@ -337,13 +942,36 @@ func (t Tags) All() map[string]TagInfo {
 	for k, v := range t.XMP() {
 		all[k] = v
 	}
+	for k, v := range t.PNGChunks() {
+		all[k] = v
+	}
 	return all
 }
 
-// GetDateTime tries DateTimeOriginal and then DateTime,
+// String returns a human readable listing of all tags, one per line, e.g. "[EXIF] Make: Canon".
+// The tags are grouped by source (EXIF, IPTC, XMP, PNGChunks) and sorted by name within each group.
+// This is mostly useful for debugging.
+func (t Tags) String() string {
+	var sb strings.Builder
+	for _, source := range sources {
+		m := t.getSourceMap(source)
+		names := make([]string, 0, len(m))
+		for name := range m {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(&sb, "[%s] %s: %v\n", source, name, m[name].Value)
+		}
+	}
+	return sb.String()
+}
+
+// GetDateTime tries DateTimeOriginal, CreateDate (DateTimeDigitized) and then ModifyDate (DateTime),
 // in the EXIF tags, and returns the parsed time.Time value if found.
+// The fractional seconds in the matching SubSecTime tag, if any, are added to the result.
 func (t Tags) GetDateTime() (time.Time, error) {
-	dateStr := t.dateTime()
+	dateStr, subSecTag := t.dateTime()
 	if dateStr == "" {
 		return time.Time{}, nil
 	}
@@ -353,9 +981,14 @@ func (t Tags) GetDateTime() (time.Time, error) {
 		loc = v
 	}
 
-	const layout = "2006:01:02 15:04:05"
-
-	return time.ParseInLocation(layout, dateStr, loc)
+	d, err := time.ParseInLocation(exifDateTimeLayout, dateStr, loc)
+	if err != nil {
+		return d, err
+	}
+	if ti, ok := t.EXIF()[subSecTag]; ok {
+		d = d.Add(parseSubSec(ti.Value))
+	}
+	return d, nil
 }
 
 // GetLatLong returns the latitude and longitude from the EXIF GPS tags.
@@ -402,6 +1035,380 @@ func (t Tags) GetLatLong() (lat float64, long float64, err error) {
 	return
 }
 
+// GetDestLatLong returns the destination coordinates from the GPSDestLatitude and GPSDestLongitude
+// EXIF tags, e.g. as recorded by navigation cameras.
+// As in GetLatLong, the coordinates are negative if south or west.
+// The ok flag is false if any of the coordinates are missing or invalid.
+func (t Tags) GetDestLatLong() (lat, long float64, ok bool) {
+	exif := t.EXIF()
+
+	coordinate := func(name, negativeRef string) (float64, bool) {
+		ti, found := exif[name]
+		if !found {
+			return 0, false
+		}
+		v, isFloat := ti.Value.(float64)
+		if !isFloat || math.IsNaN(v) {
+			return 0, false
+		}
+		if ref, found := exif[name+"Ref"]; found && strings.TrimSpace(toString(ref.Value)) == negativeRef {
+			v = -v
+		}
+		return v, true
+	}
+
+	lat, ok = coordinate("GPSDestLatitude", "S")
+	if !ok {
+		return 0, 0, false
+	}
+	long, ok = coordinate("GPSDestLongitude", "W")
+	if !ok {
+		return 0, 0, false
+	}
+	return lat, long, true
+}
+
+// GetGPSMapDatum returns the geodetic datum used for the GPS coordinates (e.g. "WGS-84"),
+// and whether it is WGS-84, which is what GetLatLong assumes.
+// If the GPSMapDatum tag is missing, WGS-84 is assumed.
+func (t Tags) GetGPSMapDatum() (datum string, isWGS84Datum bool) {
+	tag, found := t.EXIF()["GPSMapDatum"]
+	if !found {
+		return "", true
+	}
+	datum = strings.TrimSpace(toString(tag.Value))
+	return datum, datum == "" || isWGS84(datum)
+}
+
+// GetGPSDateTime returns the UTC date and time from the EXIF GPSDateStamp and GPSTimeStamp tags.
+// Fractional seconds are kept if GPSTimeStamp was decoded with DecodeStructured
+// or written with a precision of hundredths of a second.
+// The ok flag is false if either tag is missing or invalid.
+func (t Tags) GetGPSDateTime() (time.Time, bool) {
+	exif := t.EXIF()
+	dateTag, found1 := exif["GPSDateStamp"]
+	timeTag, found2 := exif["GPSTimeStamp"]
+	if !found1 || !found2 {
+		return time.Time{}, false
+	}
+
+	date, ok := dateTag.Value.(time.Time)
+	if !ok {
+		// Not decoded with NormalizeDates.
+		var err error
+		date, err = time.Parse(exifDateLayout, strings.TrimSpace(toString(dateTag.Value)))
+		if err != nil {
+			return time.Time{}, false
+		}
+	}
+
+	timeOfDay, ok := timeTag.Value.(time.Duration)
+	if !ok {
+		// Not decoded with DecodeStructured.
+		timeOfDay, ok = parseTimeOfDay(toString(timeTag.Value))
+		if !ok {
+			return time.Time{}, false
+		}
+	}
+
+	return time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC).Add(timeOfDay), true
+}
+
+// GetGPSAccuracy returns the horizontal positioning error in meters and the
+// dilution of precision (DOP) from the EXIF GPS tags.
+// ok is false if none of these tags are set.
+func (t Tags) GetGPSAccuracy() (hpositioningErrorMeters float64, dop float64, ok bool) {
+	exif := t.EXIF()
+
+	if ti, found := exif["GPSHPositioningError"]; found {
+		hpositioningErrorMeters = toFloat64(ti.Value)
+		ok = true
+	}
+	if ti, found := exif["GPSDOP"]; found {
+		dop = toFloat64(ti.Value)
+		ok = true
+	}
+
+	return
+}
+
+// GPSFixQuality returns whether the GPS fix was differential corrected (GPSDifferential)
+// and whether the measurement was active, as opposed to void (GPSStatus).
+// This works with and without DecodeEnumLabels.
+// A missing GPSDifferential means no correction, a missing GPSStatus means active.
+// ok is false if none of these tags are set.
+func (t Tags) GPSFixQuality() (differential bool, active bool, ok bool) {
+	exif := t.EXIF()
+	active = true
+
+	if ti, found := exif["GPSDifferential"]; found {
+		if n, isNum := toInt(ti.Value); isNum {
+			differential = n == 1
+		} else {
+			differential = toString(ti.Value) == exifGPSDifferentialLabels[1]
+		}
+		ok = true
+	}
+	if ti, found := exif["GPSStatus"]; found {
+		s := strings.TrimSpace(toString(ti.Value))
+		active = s != "V" && s != exifGPSStatusLabels["V"]
+		ok = true
+	}
+
+	return
+}
+
+// GetISO returns the effective ISO speed from the EXIF tags.
+// The ISO tag (PhotographicSensitivity) is used unless it's 65535, which means that the
+// value is too large for the field, or missing. The value is then taken from the
+// StandardOutputSensitivity, RecommendedExposureIndex or ISOSpeed tag as indicated by SensitivityType.
+// The ok flag is false if no ISO value was found.
+func (t Tags) GetISO() (iso int, ok bool) {
+	exif := t.EXIF()
+
+	getInt := func(name string) (int, bool) {
+		ti, found := exif[name]
+		if !found {
+			return 0, false
+		}
+		v := ti.Value
+		if vv, isSlice := v.([]any); isSlice && len(vv) > 0 {
+			// The first value is the ISO speed, see the EXIF spec.
+			v = vv[0]
+		}
+		n, isInt := toInt(v)
+		return n, isInt && n > 0
+	}
+
+	const isoOverflow = 65535
+
+	iso, ok = getInt("ISO")
+	if ok && iso < isoOverflow {
+		return iso, true
+	}
+
+	// The candidates in the order to try, with the one indicated by SensitivityType first.
+	candidates := []string{"StandardOutputSensitivity", "RecommendedExposureIndex", "ISOSpeed"}
+	if sensitivityType, found := getInt("SensitivityType"); found {
+		switch sensitivityType {
+		case 2, 6:
+			// REI, REI and ISO speed.
+			candidates = []string{"RecommendedExposureIndex", "ISOSpeed", "StandardOutputSensitivity"}
+		case 3:
+			// ISO speed.
+			candidates = []string{"ISOSpeed", "StandardOutputSensitivity", "RecommendedExposureIndex"}
+		}
+	}
+	for _, name := range candidates {
+		if v, found := getInt(name); found {
+			return v, true
+		}
+	}
+
+	return iso, ok
+}
+
+// FocalLength35mm returns the 35 mm equivalent focal length in millimeters.
+// The EXIF FocalLengthIn35mmFormat tag is used if set, else the value is estimated
+// from FocalLength and the sensor size, which is computed from ExifImageWidth, ExifImageHeight
+// and the FocalPlaneXResolution, FocalPlaneYResolution and FocalPlaneResolutionUnit tags.
+// The ok flag is false if neither is possible.
+func (t Tags) FocalLength35mm() (mm int, ok bool) {
+	exif := t.EXIF()
+
+	if ti, found := exif["FocalLengthIn35mmFormat"]; found {
+		if mm, ok := toInt(ti.Value); ok && mm > 0 {
+			return mm, true
+		}
+	}
+
+	getFloat := func(name string) float64 {
+		ti, found := exif[name]
+		if !found {
+			return 0
+		}
+		if n, ok := toInt(ti.Value); ok {
+			return float64(n)
+		}
+		return toFloat64(ti.Value)
+	}
+
+	focalLength := getFloat("FocalLength")
+	width, xres := getFloat("ExifImageWidth"), getFloat("FocalPlaneXResolution")
+	if focalLength <= 0 || width <= 0 || xres <= 0 {
+		return 0, false
+	}
+
+	// Millimeters per unit, inches being the default.
+	unit := 25.4
+	if ti, found := exif["FocalPlaneResolutionUnit"]; found {
+		switch u, _ := toInt(ti.Value); u {
+		case 3:
+			unit = 10
+		case 4:
+			unit = 1
+		case 5:
+			unit = 0.001
+		}
+	}
+
+	// Compare the sensor diagonal with the diagonal of a 36x24 mm frame,
+	// or the widths if we don't know the height.
+	sensorWidth := width / xres * unit
+	scale := 36 / sensorWidth
+	if height, yres := getFloat("ExifImageHeight"), getFloat("FocalPlaneYResolution"); height > 0 && yres > 0 {
+		sensorHeight := height / yres * unit
+		scale = math.Sqrt(36*36+24*24) / math.Sqrt(sensorWidth*sensorWidth+sensorHeight*sensorHeight)
+	}
+
+	return int(math.Round(focalLength * scale)), true
+}
+
+// Lens returns the lens used to take the image, from the EXIF LensMake, LensModel,
+// LensInfo and LensSerialNumber tags.
+// If LensModel is not set, the model and serial number are read from the vendor
+// MakerNote tags, if decoded (see Options.DecodeMakerNotes).
+func (t Tags) Lens() LensInfo {
+	exif := t.EXIF()
+	get := func(names ...string) string {
+		for _, name := range names {
+			if ti, found := exif[name]; found {
+				// Some MakerNotes pad the string with a NUL followed by garbage,
+				// which ends up as replacement characters.
+				s := toString(ti.Value)
+				if i := strings.IndexRune(s, utf8.RuneError); i >= 0 {
+					s = s[:i]
+				}
+				if s = strings.TrimSpace(s); s != "" {
+					return s
+				}
+			}
+		}
+		return ""
+	}
+
+	lens := LensInfo{
+		Make:   get("LensMake"),
+		Model:  get("LensModel"),
+		Spec:   get("LensInfo"),
+		Serial: get("LensSerialNumber"),
+	}
+	if lens.Model == "" {
+		lens.Model = get("Canon.LensModel", "Panasonic.LensType", "Leica.LensType")
+		if lens.Serial == "" {
+			lens.Serial = get("Panasonic.LensSerialNumber", "Leica.LensSerialNumber")
+		}
+	}
+	return lens
+}
+
+// Rating returns the star rating of the image, from 0 to 5.
+// It tries the EXIF Rating tag, then the EXIF RatingPercent tag, where 1, 25, 50, 75 and 99
+// percent are 1 to 5 stars, as written by Windows, and then the XMP Rating.
+// A negative XMP Rating (rejected) is returned as 0.
+// The ok flag is false if none of these are set.
+func (t Tags) Rating() (stars int, ok bool) {
+	exif := t.EXIF()
+	if ti, found := exif["Rating"]; found {
+		if n, ok := toInt(ti.Value); ok {
+			return clampInt(n, 0, 5), true
+		}
+	}
+	if ti, found := exif["RatingPercent"]; found {
+		if n, ok := toInt(ti.Value); ok {
+			n = clampInt(n, 0, 100)
+			if n == 0 {
+				return 0, true
+			}
+			return clampInt(int(math.Round(float64(n)/25))+1, 1, 5), true
+		}
+	}
+	if ti, found := t.xmpTag("xmp", "Rating"); found {
+		if f, err := strconv.ParseFloat(strings.TrimSpace(toString(ti.Value)), 64); err == nil {
+			return clampInt(int(math.Round(f)), 0, 5), true
+		}
+	}
+	return 0, false
+}
+
+// Software returns the software used to create or edit the image.
+// It tries the XMP CreatorTool, then the EXIF Software and ProcessingSoftware tags,
+// and returns an empty string if none of these are set.
+func (t Tags) Software() string {
+	if ti, found := t.xmpTag("xmp", "CreatorTool"); found {
+		if s := strings.TrimSpace(toString(ti.Value)); s != "" {
+			return s
+		}
+	}
+	exif := t.EXIF()
+	for _, name := range []string{"Software", "ProcessingSoftware"} {
+		if ti, found := exif[name]; found {
+			if s := strings.TrimSpace(toString(ti.Value)); s != "" {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// Copyright returns the copyright notice of the image.
+// It tries the XMP rights (see Options.DecodeXMPDublinCore), then the IPTC CopyrightNotice and the EXIF Copyright tags,
+// and returns an empty string if none of these are set.
+// This follows the Metadata Working Group guidelines, where XMP is preferred over IPTC and EXIF.
+func (t Tags) Copyright() string {
+	if ti, found := t.xmpTag("dc", "rights"); found {
+		if s := strings.TrimSpace(toString(ti.Value)); s != "" {
+			return s
+		}
+	}
+	if ti, found := t.IPTC()["CopyrightNotice"]; found {
+		if s := strings.TrimSpace(toString(ti.Value)); s != "" {
+			return s
+		}
+	}
+	if ti, found := t.EXIF()["Copyright"]; found {
+		if s := strings.TrimSpace(toString(ti.Value)); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// Creator returns the names of the image creators, e.g. the photographer.
+// It tries the XMP creator (see Options.DecodeXMPDublinCore), then the IPTC By-line and the EXIF Artist tags,
+// and returns the values of the first of these that is set, with duplicates removed.
+// The sources are not merged, as they're often the same names slightly out of sync.
+// The EXIF Artist tag may hold several names separated by "; ".
+func (t Tags) Creator() []string {
+	if ti, found := t.xmpTag("dc", "creator"); found {
+		if names := uniqueNonEmptyStrings(ti.Value, ""); len(names) > 0 {
+			return names
+		}
+	}
+	if ti, found := t.IPTC()["By-line"]; found {
+		if names := uniqueNonEmptyStrings(ti.Value, ""); len(names) > 0 {
+			return names
+		}
+	}
+	if ti, found := t.EXIF()["Artist"]; found {
+		if names := uniqueNonEmptyStrings(ti.Value, "; "); len(names) > 0 {
+			return names
+		}
+	}
+	return nil
+}
+
+// xmpTag looks up the XMP tag with the given name, either unqualified or
+// qualified with prefix, e.g. "dc:rights", as decoded with Options.XMPQualifiedNames.
+func (t Tags) xmpTag(prefix, name string) (TagInfo, bool) {
+	xmp := t.XMP()
+	if ti, found := xmp[name]; found {
+		return ti, true
+	}
+	ti, found := xmp[prefix+":"+name]
+	return ti, found
+}
+
 func (t *Tags) getSourceMap(source Source) map[string]TagInfo {
 	switch source {
 	case EXIF:
@@ -410,26 +1417,88 @@ func (t *Tags) getSourceMap(source Source) map[string]TagInfo {
 		return t.IPTC()
 	case XMP:
 		return t.XMP()
+	case PNGChunks:
+		return t.PNGChunks()
 	default:
 		return nil
 	}
 }
 
-func (t Tags) dateTime() string {
+// dateTime returns the first date found and the name of its SubSecTime tag.
+func (t Tags) dateTime() (string, string) {
 	exif := t.EXIF()
-	if ti, ok := exif["DateTimeOriginal"]; ok {
-		return ti.Value.(string)
+	for _, names := range [][2]string{
+		{"DateTimeOriginal", "SubSecTimeOriginal"},
+		{"CreateDate", "SubSecTimeDigitized"},
+		{"ModifyDate", "SubSecTime"},
+	} {
+		if ti, ok := exif[names[0]]; ok {
+			if d, ok := ti.Value.(time.Time); ok {
+				// Decoded with NormalizeDates.
+				return d.Format(exifDateTimeLayout), names[1]
+			}
+			return toString(ti.Value), names[1]
+		}
 	}
-	if ti, ok := exif["DateTime"]; ok {
-		return ti.Value.(string)
+	return "", ""
+}
+
+// parseSubSec parses the digits in a SubSecTime tag as a decimal fraction of a second,
+// e.g. "123" is 123 milliseconds. Any digits beyond nanosecond precision are ignored.
+// The SubSecTime tags are decoded as ints, so any leading zeros are lost, e.g. "07" is read as 7 (700 milliseconds).
+func parseSubSec(v any) time.Duration {
+	var s string
+	switch vv := v.(type) {
+	case int:
+		if vv <= 0 {
+			return 0
+		}
+		s = strconv.Itoa(vv)
+	case string:
+		s = vv
+	default:
+		return 0
 	}
-	return ""
+	var d time.Duration
+	scale := time.Second
+	for _, r := range strings.TrimSpace(s) {
+		if r < '0' || r > '9' {
+			break
+		}
+		scale /= 10
+		d += time.Duration(r-'0') * scale
+	}
+	return d
 }
 
 // Borrowed from github.com/rwcarlsen/goexif
 // TODO(bep: look for timezone offset, GPS time, etc.
 func (t Tags) location() *time.Location {
 	exif := t.EXIF()
+
+	for _, name := range []string{"OffsetTimeOriginal", "OffsetTime"} {
+		if ti, found := exif[name]; found {
+			if loc := parseTimeOffset(toString(ti.Value)); loc != nil {
+				return loc
+			}
+		}
+	}
+
+	if ti, found := exif["TimeZoneOffset"]; found {
+		// One or two values; the first is the offset in hours from GMT for DateTimeOriginal.
+		v := ti.Value
+		if vv, ok := v.([]any); ok && len(vv) > 0 {
+			v = vv[0]
+		}
+		if vv, ok := v.(uint16); ok {
+			// This is a signed short, but we currently read both as uint16.
+			v = int16(vv)
+		}
+		if hours, ok := toInt(v); ok && hours >= -14 && hours <= 14 {
+			return time.FixedZone("", hours*60*60)
+		}
+	}
+
 	timeInfo, found := exif["Canon.TimeInfo"]
 	if !found {
 		return nil
@@ -442,10 +1511,49 @@ func (t Tags) location() *time.Location {
 	return time.FixedZone("", int(vals[1]*60))
 }
 
+// parseTimeOffset parses an EXIF time offset on the form "+02:00".
+func parseTimeOffset(s string) *time.Location {
+	tt, err := time.Parse("-07:00", strings.TrimSpace(s))
+	if err != nil {
+		return nil
+	}
+	_, offset := tt.Zone()
+	return time.FixedZone("", offset)
+}
+
 type baseStreamingDecoder struct {
 	*streamReader
-	opts Options
-	err  error
+	opts   Options
+	result *DecodeResult
+	err    error
+
+	// The number of JPEG segments or WebP chunks scanned.
+	numSegments int
+}
+
+const defaultMaxSegments = 1 << 16
+
+// segmentLimitReached counts a scanned segment or chunk and reports whether Options.MaxSegments is exceeded.
+func (d *baseStreamingDecoder) segmentLimitReached() bool {
+	d.numSegments++
+	max := d.opts.MaxSegments
+	if max <= 0 {
+		max = defaultMaxSegments
+	}
+	if d.numSegments > max {
+		d.opts.Warnf("stopped scanning after %d segments", max)
+		return true
+	}
+	return false
+}
+
+// blockDone is called when a metadata block for source has been decoded and returns the sources left to look for.
+// Unless AllowDuplicateBlocks is set, any later block for the same source is ignored.
+func (d *baseStreamingDecoder) blockDone(sources, source Source) Source {
+	if d.opts.AllowDuplicateBlocks {
+		return sources
+	}
+	return sources.Remove(source)
 }
 
 func (d *baseStreamingDecoder) streamErr() error {
@@ -19,11 +19,20 @@ func FuzzDecodeJPG(f *testing.F) {
 		"metadata_demo_exif_only.jpg", "metadata_demo_iim_and_xmp_only.jpg",
 		"corrupt/infinite_loop_exif.jpg",
 		"corrupt/max_uint32_exif.jpg",
+		"corrupt/huge_app1_length.jpg",
 	}
 	for _, filename := range filenames {
 		f.Add(readTestDataFileAll(f, filename))
 	}
 
+	// Lots of empty segments, and a stream of zero markers.
+	var segments [][]byte
+	for i := 0; i < 1000; i++ {
+		segments = append(segments, jpegSegment(0xfffe, nil))
+	}
+	f.Add(jpegFile(segments...))
+	f.Add(append([]byte{0xff, 0xd8}, make([]byte, 10000)...))
+
 	f.Fuzz(func(t *testing.T, imageBytes []byte) {
 		fuzzDecodeBytes(t, imageBytes, imagemeta.JPEG)
 	})
@@ -36,6 +45,13 @@ func FuzzDecodeWebP(f *testing.F) {
 		f.Add(readTestDataFileAll(f, filename))
 	}
 
+	// Lots of empty chunks.
+	var chunks [][]byte
+	for i := 0; i < 1000; i++ {
+		chunks = append(chunks, webpChunk("JUNK", nil))
+	}
+	f.Add(webpFile(chunks...))
+
 	f.Fuzz(func(t *testing.T, imageBytes []byte) {
 		fuzzDecodeBytes(t, imageBytes, imagemeta.WebP)
 	})
@@ -60,6 +76,19 @@ func FuzzDecodeTIFF(f *testing.F) {
 		f.Add(readTestDataFileAll(f, filename))
 	}
 
+	// Entries with a zero count, which have no value but still a 4 byte value field.
+	tb := newTIFFBuilder()
+	f.Add(tb.build(
+		[]tiffEntry{
+			tb.raw(0x010f, tiffTypeASCII, 0, []byte{0xff, 0xff, 0xff, 0xff}),
+			tb.raw(0x0112, tiffTypeShort, 0, nil),
+			tb.raw(0x8769, tiffTypeLong, 0, nil),
+		},
+		[]tiffEntry{
+			tb.raw(0x0201, tiffTypeLong, 0, nil),
+		},
+	))
+
 	f.Fuzz(func(t *testing.T, imageBytes []byte) {
 		fuzzDecodeBytes(t, imageBytes, imagemeta.TIFF)
 	})
@@ -4,9 +4,13 @@
 package imagemeta_test
 
 import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"image/jpeg"
 	"io"
 	"math"
 	"math/rand"
@@ -16,7 +20,10 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+	"unicode/utf16"
 
 	"github.com/bep/imagemeta"
 	"github.com/rwcarlsen/goexif/exif"
@@ -134,7 +141,7 @@ func TestDecodeTIFF(t *testing.T) {
 	tags := extractTags(t, "sunrise.tif", imagemeta.EXIF|imagemeta.IPTC|imagemeta.XMP)
 
 	c.Assert(len(tags.EXIF()), qt.Equals, 76)
-	c.Assert(len(tags.XMP()), qt.Equals, 146)
+	c.Assert(len(tags.XMP()), qt.Equals, 147)
 	c.Assert(len(tags.IPTC()), qt.Equals, 14)
 
 	c.Assert(tags.EXIF()["ShutterSpeedValue"].Value, eq, 0.005000000)
@@ -345,6 +352,67 @@ func TestDecodeEXIFOrientationOnly(t *testing.T) {
 	c.Assert(len(tags.EXIF()), qt.Equals, 1)
 }
 
+func TestDecodeZeroCount(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newTIFFBuilder()
+	tiff := tb.build(
+		[]tiffEntry{
+			tb.raw(0x010e, tiffTypeASCII, 0, []byte{0xff, 0xff, 0xff, 0xff}),
+			tb.ascii(0x010f, "Canon"),
+			tb.raw(0x0112, tiffTypeShort, 0, []byte{0xff, 0xff, 0xff, 0xff}),
+			tb.raw(0x011a, tiffTypeRational, 0, []byte{0xff, 0xff, 0xff, 0xff}),
+			tb.short(0x0128, 2),
+			tb.sub(0x8769,
+				tb.raw(0x9000, tiffTypeUndef, 0, nil),
+				tb.short(0xa001, 1),
+			),
+		},
+		[]tiffEntry{
+			tb.raw(0x0201, tiffTypeLong, 0, nil),
+			tb.long(0x0202, 1234),
+		},
+	)
+
+	tags, warnings := decodeBytes(c, jpegFile(jpegEXIFSegment(tiff)), imagemeta.JPEG, imagemeta.Options{
+		ShouldHandleTag: func(ti imagemeta.TagInfo) bool { return true },
+	})
+	c.Assert(warnings, qt.HasLen, 0)
+	exif := tags.EXIF()
+	c.Assert(exif["Make"].Value, qt.Equals, "Canon")
+	c.Assert(exif["ResolutionUnit"].Value, eq, uint16(2))
+	c.Assert(exif["ColorSpace"].Value, eq, uint16(1))
+	c.Assert(exif["ThumbnailLength"].Value, eq, uint32(1234))
+	for _, name := range []string{"ImageDescription", "Orientation", "XResolution", "ExifVersion", "ThumbnailOffset"} {
+		_, found := exif[name]
+		c.Assert(found, qt.IsFalse, qt.Commentf(name))
+	}
+}
+
+func TestDecodeOrientation(t *testing.T) {
+	c := qt.New(t)
+
+	for _, imageFormat := range []imagemeta.ImageFormat{imagemeta.JPEG, imagemeta.PNG, imagemeta.WebP, imagemeta.TIFF} {
+		img, close := getSunrise(c, imageFormat)
+		orientation, err := imagemeta.DecodeOrientation(img, imageFormat)
+		close()
+		c.Assert(err, qt.IsNil)
+		c.Assert(orientation, qt.Equals, imagemeta.OrientationNormal, qt.Commentf("%v", imageFormat))
+	}
+
+	tb := newTIFFBuilder()
+	decode := func(b []byte) imagemeta.Orientation {
+		orientation, err := imagemeta.DecodeOrientation(bytes.NewReader(b), imagemeta.JPEG)
+		c.Assert(err, qt.IsNil)
+		return orientation
+	}
+
+	c.Assert(decode(jpegFile(jpegEXIFSegment(tb.build([]tiffEntry{tb.short(0x0112, 6)})))), qt.Equals, imagemeta.OrientationRotate90CW)
+	c.Assert(decode(jpegFile(jpegEXIFSegment(tb.build([]tiffEntry{tb.short(0x0112, 42)})))), qt.Equals, imagemeta.OrientationUnknown)
+	c.Assert(decode(jpegFile(jpegEXIFSegment(tb.build([]tiffEntry{tb.ascii(0x010f, "Canon")})))), qt.Equals, imagemeta.OrientationUnknown)
+	c.Assert(decode(jpegFile()), qt.Equals, imagemeta.OrientationUnknown)
+}
+
 func TestDecodeIPTCOrientationOnly(t *testing.T) {
 	c := qt.New(t)
 
@@ -388,9 +456,37 @@ func TestDecodeXMPJPG(t *testing.T) {
 func TestDecodeErrors(t *testing.T) {
 	c := qt.New(t)
 
-	c.Assert(imagemeta.Decode(imagemeta.Options{}), qt.ErrorMatches, "no reader provided")
-	c.Assert(imagemeta.Decode(imagemeta.Options{R: strings.NewReader("foo")}), qt.ErrorMatches, "no image format provided.*")
-	c.Assert(imagemeta.Decode(imagemeta.Options{R: strings.NewReader("foo"), ImageFormat: imagemeta.ImageFormat(1234)}), qt.ErrorMatches, "unsupported image format")
+	decode := func(opts imagemeta.Options) error {
+		err := imagemeta.Decode(opts)
+		return err
+	}
+
+	c.Assert(decode(imagemeta.Options{}), qt.ErrorMatches, "no reader provided")
+	c.Assert(decode(imagemeta.Options{R: strings.NewReader("foo")}), qt.ErrorMatches, "no image format provided.*")
+	c.Assert(decode(imagemeta.Options{R: strings.NewReader("foo"), ImageFormat: imagemeta.ImageFormat(1234)}), qt.ErrorMatches, "unsupported image format")
+}
+
+func TestDecodeWrongFormat(t *testing.T) {
+	c := qt.New(t)
+
+	readFile := func(filename string) []byte {
+		b, err := os.ReadFile(filepath.Join("testdata", "images", filename))
+		c.Assert(err, qt.IsNil)
+		return b
+	}
+
+	for _, test := range []struct {
+		filename    string
+		imageFormat imagemeta.ImageFormat
+	}{
+		{"sunrise.png", imagemeta.JPEG},
+		{"sunrise.jpg", imagemeta.WebP},
+		{"sunrise.jpg", imagemeta.TIFF},
+		{"sunrise.jpg", imagemeta.PNG},
+	} {
+		err := imagemeta.Decode(imagemeta.Options{R: bytes.NewReader(readFile(test.filename)), ImageFormat: test.imageFormat})
+		c.Assert(imagemeta.IsInvalidFormat(err), qt.IsTrue, qt.Commentf("%s as %s: %v", test.filename, test.imageFormat, err))
+	}
 }
 
 func TestGoldenEXIFHugoIssue12669(t *testing.T) {
@@ -450,6 +546,289 @@ func TestLatLong(t *testing.T) {
 	c.Assert(long, eq, float64(11.002777))
 }
 
+func TestDecodeMotionPhoto(t *testing.T) {
+	c := qt.New(t)
+
+	mp4 := append(appendUint32(binary.BigEndian, nil, 24), "ftypisom"...)
+	mp4 = append(mp4, make([]byte, 100)...)
+
+	const (
+		containerXMP = `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description xmlns:GCamera="http://ns.google.com/photos/1.0/camera/" xmlns:Container="http://ns.google.com/photos/1.0/container/" xmlns:Item="http://ns.google.com/photos/1.0/container/item/" GCamera:MotionPhoto="1" GCamera:MotionPhotoVersion="1">
+<Container:Directory><rdf:Seq>
+<rdf:li rdf:parseType="Resource"><Container:Item Item:Mime="image/jpeg" Item:Semantic="Primary" Item:Length="0" Item:Padding="0"/></rdf:li>
+<rdf:li rdf:parseType="Resource"><Container:Item Item:Mime="video/mp4" Item:Semantic="MotionPhoto" Item:Length="%d"/></rdf:li>
+</rdf:Seq></Container:Directory>
+</rdf:Description></rdf:RDF></x:xmpmeta>`
+		microVideoXMP = `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description xmlns:GCamera="http://ns.google.com/photos/1.0/camera/" GCamera:MicroVideo="1" GCamera:MicroVideoVersion="1" GCamera:MicroVideoOffset="%d"/>
+</rdf:RDF></x:xmpmeta>`
+	)
+
+	// There's no Motion Photo in testdata, so assemble one the way the phones
+	// write it: the XMP segment after the APP1 of a real JPEG, followed by the video.
+	jpg := readTestDataFileAll(t, "metadata_demo_exif_only.jpg")
+	c.Assert(jpg[3], qt.Equals, byte(0xe1))
+	app1End := 4 + int(binary.BigEndian.Uint16(jpg[4:]))
+	wantEXIF, _ := decodeBytes(c, jpg, imagemeta.JPEG, imagemeta.Options{Sources: imagemeta.EXIF})
+
+	for _, xmpPacket := range []string{containerXMP, microVideoXMP} {
+		b := append(append([]byte{}, jpg[:app1End]...), jpegXMPSegment(fmt.Sprintf(xmpPacket, len(mp4)))...)
+		b = append(b, jpg[app1End:]...)
+		b = append(b, mp4...)
+
+		var tags imagemeta.Tags
+		result, err := imagemeta.DecodeWithResult(imagemeta.Options{
+			R:           bytes.NewReader(b),
+			ImageFormat: imagemeta.JPEG,
+			Sources:     imagemeta.EXIF | imagemeta.XMP,
+			Warnf:       panicWarnf,
+			HandleTag: func(ti imagemeta.TagInfo) error {
+				tags.Add(ti)
+				return nil
+			},
+		})
+		c.Assert(err, qt.IsNil)
+		c.Assert(tags.EXIF(), eq, wantEXIF.EXIF())
+		c.Assert(result.MotionPhoto.HasVideo, qt.IsTrue)
+		c.Assert(result.MotionPhoto.VideoLength, qt.Equals, int64(len(mp4)))
+		offset := result.MotionPhoto.VideoOffset
+		c.Assert(offset, qt.Equals, int64(len(b)-len(mp4)))
+		c.Assert(string(b[offset+4:offset+8]), qt.Equals, "ftyp")
+	}
+
+	result, err := imagemeta.DecodeWithResult(imagemeta.Options{R: bytes.NewReader(readTestDataFileAll(c, "sunrise.jpg")), ImageFormat: imagemeta.JPEG})
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.MotionPhoto, qt.Equals, imagemeta.MotionPhoto{})
+}
+
+func TestDecodeNormalizeDates(t *testing.T) {
+	c := qt.New(t)
+
+	b := readTestDataFileAll(c, "sunrise.jpg")
+
+	tags, _ := decodeBytes(c, b, imagemeta.JPEG, imagemeta.Options{})
+	c.Assert(tags.EXIF()["DateTimeOriginal"].Value, qt.Equals, "2017:10:27 08:38:52")
+	want, err := tags.GetDateTime()
+	c.Assert(err, qt.IsNil)
+
+	tags, _ = decodeBytes(c, b, imagemeta.JPEG, imagemeta.Options{NormalizeDates: true})
+	c.Assert(tags.EXIF()["DateTimeOriginal"].Value, qt.Equals, time.Date(2017, 10, 27, 8, 38, 52, 0, time.UTC))
+	c.Assert(tags.EXIF()["GPSDateStamp"].Value, qt.Equals, time.Date(2017, 10, 27, 0, 0, 0, 0, time.UTC))
+	c.Assert(tags.IPTC()["DateCreated"].Value, qt.Equals, time.Date(2017, 10, 27, 0, 0, 0, 0, time.UTC))
+	modifyDate, ok := tags.XMP()["ModifyDate"].Value.(time.Time)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(modifyDate.Format(time.RFC3339), qt.Equals, "2023-08-09T16:44:44+02:00")
+	// Not a date.
+	c.Assert(tags.IPTC()["TimeCreated"].Value, qt.Equals, "08:38:52")
+
+	got, err := tags.GetDateTime()
+	c.Assert(err, qt.IsNil)
+	c.Assert(got.Equal(want), qt.IsTrue)
+}
+
+func TestDecodeIFDAndLong8Types(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newTIFFBuilder()
+	exifIFD := tb.sub(0x8769,
+		tb.ascii(0x9003, "2024:01:02 10:00:00"),
+		tb.sub(0xa005, tb.ascii(0x0001, "R98")),
+	)
+	exifIFD.typ = tiffTypeIFD
+	exifIFD.ifd[1].typ = tiffTypeIFD
+	long8 := tb.raw(0xfe01, tiffTypeLong8, 1, appendUint32(binary.BigEndian, appendUint32(binary.BigEndian, nil, 1), 2))
+	slong8 := tb.raw(0xfe02, tiffTypeSLong8, 1, appendUint32(binary.BigEndian, appendUint32(binary.BigEndian, nil, 0xffffffff), 0xfffffffe))
+
+	tiff := tb.build([]tiffEntry{exifIFD, long8, slong8})
+	tags, warnings := decodeBytes(c, jpegFile(jpegEXIFSegment(tiff)), imagemeta.JPEG, imagemeta.Options{
+		ShouldHandleTag: func(ti imagemeta.TagInfo) bool { return true },
+	})
+	c.Assert(warnings, qt.HasLen, 0)
+	exif := tags.EXIF()
+	c.Assert(exif["DateTimeOriginal"].Value, qt.Equals, "2024:01:02 10:00:00")
+	c.Assert(exif["DateTimeOriginal"].Namespace, qt.Equals, "IFD0/ExifIFDP")
+	c.Assert(exif["InteropIndex"].Namespace, qt.Equals, "IFD0/ExifIFDP/InteroperabilityIFD")
+	c.Assert(exif["UnknownTag_0xfe01"].Value, qt.Equals, uint64(1<<32|2))
+	c.Assert(exif["UnknownTag_0xfe02"].Value, qt.Equals, int64(-2))
+}
+
+func TestDecodeBigTIFF(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newBigTIFFBuilder()
+	tiff := tb.build([]tiffEntry{
+		tb.ascii(0x010f, "Canon"),
+		tb.ascii(0x0110, "Canon EOS R5 Mark II"),
+		tb.short(0x0112, 6),
+		tb.rational(0x011a, 300, 1),
+		tb.sub(0x8769,
+			tb.ascii(0x9003, "2024:01:02 10:00:00"),
+			tb.short(0xa001, 1),
+		),
+	})
+
+	tags, warnings := decodeBytes(c, tiff, imagemeta.TIFF, imagemeta.Options{
+		ShouldHandleTag: func(ti imagemeta.TagInfo) bool { return true },
+	})
+	c.Assert(warnings, qt.HasLen, 0)
+	exif := tags.EXIF()
+	c.Assert(exif["Make"].Value, qt.Equals, "Canon")
+	c.Assert(exif["Make"].Namespace, qt.Equals, "IFD0")
+	c.Assert(exif["Model"].Value, qt.Equals, "Canon EOS R5 Mark II")
+	c.Assert(exif["Orientation"].Value, eq, uint16(6))
+	xres, _ := imagemeta.NewRat[uint32](300, 1)
+	c.Assert(exif["XResolution"].Value, eq, xres)
+	c.Assert(exif["DateTimeOriginal"].Value, qt.Equals, "2024:01:02 10:00:00")
+	c.Assert(exif["DateTimeOriginal"].Namespace, qt.Equals, "IFD0/ExifIFDP")
+	c.Assert(exif["ColorSpace"].Value, eq, uint16(1))
+
+	// A LONG8 ThumbnailOffset.
+	tiff = tb.build([]tiffEntry{
+		tb.raw(0x0201, tiffTypeLong8, 1, appendUint64(binary.LittleEndian, nil, 100)),
+	})
+	tags, warnings = decodeBytes(c, tiff, imagemeta.TIFF, imagemeta.Options{
+		ShouldHandleTag: func(ti imagemeta.TagInfo) bool { return true },
+	})
+	c.Assert(warnings, qt.HasLen, 0)
+	c.Assert(tags.EXIF()["ThumbnailOffset"].Value, eq, uint64(100))
+
+	// The offset size must be 8.
+	invalid := append([]byte(nil), tiff...)
+	binary.LittleEndian.PutUint16(invalid[4:], 4)
+	err := imagemeta.Decode(imagemeta.Options{R: bytes.NewReader(invalid), ImageFormat: imagemeta.TIFF})
+	c.Assert(imagemeta.IsInvalidFormat(err), qt.IsTrue)
+}
+
+func TestDecodeThumbnailOffsetShort(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newTIFFBuilder()
+	tiff := tb.build([]tiffEntry{tb.short(0x0201, 100), tb.short(0x0202, 10)})
+	tags, warnings := decodeBytes(c, tiff, imagemeta.TIFF, imagemeta.Options{
+		ShouldHandleTag: func(ti imagemeta.TagInfo) bool { return true },
+	})
+	c.Assert(warnings, qt.HasLen, 0)
+	c.Assert(tags.EXIF()["ThumbnailOffset"].Value, eq, uint32(100))
+}
+
+func TestDecodeUndefinedType(t *testing.T) {
+	c := qt.New(t)
+
+	printIM := []byte("PrintIM\x000250\x00\x00\x03\x00\x02\x00\x01\x00\x00\x00\xff\x0a")
+
+	tb := newTIFFBuilder()
+	tiff := tb.build([]tiffEntry{
+		// Binary in undef.
+		tb.bytes(0xc4a5, tiffTypeUndef, printIM),
+		tb.sub(0x8769,
+			// ASCII in undef.
+			tb.bytes(0x9000, tiffTypeUndef, []byte("0232")),
+		),
+		tb.sub(0x8825,
+			// ASCII in undef with a character code prefix.
+			tb.bytes(0x001b, tiffTypeUndef, []byte("ASCII\x00\x00\x00GPS\x00")),
+			tb.bytes(0x001c, tiffTypeUndef, []byte("Oslo")),
+		),
+	})
+
+	tags, warnings := decodeBytes(c, jpegFile(jpegEXIFSegment(tiff)), imagemeta.JPEG, imagemeta.Options{
+		ShouldHandleTag: func(ti imagemeta.TagInfo) bool { return true },
+	})
+	c.Assert(warnings, qt.HasLen, 0)
+	exif := tags.EXIF()
+	c.Assert(exif["PrintIM"].Value, qt.DeepEquals, printIM)
+	c.Assert(exif["ExifVersion"].Value, qt.Equals, "0232")
+	c.Assert(exif["GPSProcessingMethod"].Value, qt.Equals, "GPS")
+	c.Assert(exif["GPSAreaInformation"].Value, qt.Equals, "Oslo")
+}
+
+func TestDecodeWhiteBalance(t *testing.T) {
+	c := qt.New(t)
+
+	// exiftool reports a WhiteBalance string ("AUTO1", "AUTO") for these files,
+	// but that value comes from the Nikon MakerNote. The EXIF tag is numeric.
+	for _, filename := range []string{
+		"smoke/hugo-issue-10738/nikon_nef_fraction_2.jpg",
+		"outofbounds-issue-34.jpg",
+	} {
+		tags := extractTags(t, filename, imagemeta.EXIF)
+		c.Assert(tags.EXIF()["WhiteBalance"].Value, eq, uint16(0), qt.Commentf(filename))
+	}
+
+	// No file in testdata stores the EXIF WhiteBalance as a string.
+	tb := newTIFFBuilder()
+	decode := func(entry tiffEntry) (any, []string) {
+		tags, warnings := decodeBytes(c, jpegFile(jpegEXIFSegment(tb.build([]tiffEntry{tb.sub(0x8769, entry)}))), imagemeta.JPEG, imagemeta.Options{})
+		return tags.EXIF()["WhiteBalance"].Value, warnings
+	}
+
+	for _, test := range []struct {
+		entry tiffEntry
+		want  any
+	}{
+		{tb.short(0xa403, 1), uint16(1)},
+		{tb.ascii(0xa403, "AUTO1       "), uint16(0)},
+		{tb.ascii(0xa403, "Manual"), uint16(1)},
+	} {
+		v, warnings := decode(test.entry)
+		c.Assert(warnings, qt.HasLen, 0)
+		c.Assert(v, eq, test.want)
+	}
+
+	v, warnings := decode(tb.ascii(0xa403, "SUNNY"))
+	c.Assert(v, qt.Equals, "SUNNY")
+	c.Assert(warnings, qt.DeepEquals, []string{`WhiteBalance: unexpected value "SUNNY"`})
+}
+
+func TestGetDestLatLong(t *testing.T) {
+	c := qt.New(t)
+
+	tags := extractTags(t, "sunrise.jpg", imagemeta.EXIF)
+	_, _, ok := tags.GetDestLatLong()
+	c.Assert(ok, qt.IsFalse)
+
+	tb := newTIFFBuilder()
+	gps := func(entries ...tiffEntry) imagemeta.Tags {
+		tags, warnings := decodeBytes(c, jpegFile(jpegEXIFSegment(tb.build([]tiffEntry{tb.sub(0x8825, entries...)}))), imagemeta.JPEG, imagemeta.Options{})
+		c.Assert(warnings, qt.HasLen, 0)
+		return tags
+	}
+
+	tags = gps(
+		// The origin, which should not be used.
+		tb.ascii(0x0001, "N"),
+		tb.rational(0x0002, 10, 1, 0, 1, 0, 1),
+		tb.ascii(0x0003, "E"),
+		tb.rational(0x0004, 20, 1, 0, 1, 0, 1),
+		// The destination.
+		tb.ascii(0x0013, "S"),
+		tb.rational(0x0014, 33, 1, 51, 1, 36, 1),
+		tb.ascii(0x0015, "W"),
+		tb.rational(0x0016, 70, 1, 30, 1, 0, 1),
+	)
+	lat, long, ok := tags.GetDestLatLong()
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(lat, eq, -33.86)
+	c.Assert(long, eq, -70.5)
+
+	tags = gps(
+		tb.ascii(0x0013, "N"),
+		tb.rational(0x0014, 33, 1, 51, 1, 36, 1),
+		tb.ascii(0x0015, "E"),
+		tb.rational(0x0016, 70, 1, 30, 1, 0, 1),
+	)
+	lat, long, ok = tags.GetDestLatLong()
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(lat, eq, 33.86)
+	c.Assert(long, eq, 70.5)
+
+	// No longitude.
+	tags = gps(tb.ascii(0x0013, "N"), tb.rational(0x0014, 33, 1, 51, 1, 36, 1))
+	_, _, ok = tags.GetDestLatLong()
+	c.Assert(ok, qt.IsFalse)
+}
+
 func TestGetDateTime(t *testing.T) {
 	c := qt.New(t)
 
@@ -474,12 +853,15 @@ func TestTagSource(t *testing.T) {
 }
 
 type goldenFileInfo struct {
-	ExifTool  map[string]any
-	File      map[string]any
-	EXIF      map[string]any
-	IPTC      map[string]any
-	XMP       map[string]any
-	Composite map[string]any
+	ExifTool   map[string]any
+	File       map[string]any
+	EXIF       map[string]any
+	IPTC       map[string]any
+	XMP        map[string]any
+	PNG        map[string]any
+	Photoshop  map[string]any
+	MakerNotes map[string]any
+	Composite  map[string]any
 }
 
 func getSunrise(c *qt.C, imageFormat imagemeta.ImageFormat) (io.ReadSeeker, func()) {
@@ -533,6 +915,10 @@ func assertGoldenInfoTagCount(t testing.TB, filename string, sources imagemeta.S
 	}
 	if sources.Has(imagemeta.IPTC) {
 		for k, v := range tags.IPTC() {
+			if v.Namespace == "Photoshop" {
+				// We only decode a few of the Photoshop resources, these are tested separately.
+				continue
+			}
 			tagsLeft[k] = v
 		}
 		for k, v := range goldenInfo.IPTC {
@@ -657,13 +1043,12 @@ func compareWithExiftoolOutput(t testing.TB, filename string, sources imagemeta.
 					return strings.Replace(v, ", use -b option to extract", "", 1)
 				}
 				switch s {
+				case "GPSVersionID":
+					// Exiftool's numeric output uses spaces, we use dots.
+					return strings.ReplaceAll(v, " ", ".")
 				case "ShutterSpeedValue", "SubSecTimeDigitized", "SubSecTimeOriginal", "GPSSatellites":
 					f, _ := strconv.ParseFloat(v, 64)
 					return f
-				case "WhiteBalance":
-					if strings.TrimSpace(v) == "AUTO1" {
-						return float64(0)
-					}
 				case "CodedCharacterSet":
 					if v == "\x1b%G" || v == "UTF8" {
 						return "UTF-8"
@@ -681,6 +1066,9 @@ func compareWithExiftoolOutput(t testing.TB, filename string, sources imagemeta.
 					switch s {
 					case "ApplicationRecordVersion", "EnvelopeRecordVersion", "FileFormat", "FileVersion", "MaxSubfileSize", "ObjectSizeAnnounced", "SizeMode":
 						return v
+					case "XResolution", "YResolution", "DisplayedUnitsX", "DisplayedUnitsY":
+						// Photoshop ResolutionInfo.
+						return v
 					default:
 						return fmt.Sprintf("%v", v)
 					}
@@ -703,7 +1091,11 @@ func compareWithExiftoolOutput(t testing.TB, filename string, sources imagemeta.
 		case imagemeta.EXIF:
 			exifToolValue, found = tagsGolden.EXIF[v.Tag]
 		case imagemeta.IPTC:
-			exifToolValue, found = tagsGolden.IPTC[v.Tag]
+			if v.Namespace == "Photoshop" {
+				exifToolValue, found = tagsGolden.Photoshop[v.Tag]
+			} else {
+				exifToolValue, found = tagsGolden.IPTC[v.Tag]
+			}
 		case imagemeta.XMP:
 			exifToolValue, found = tagsGolden.XMP[v.Tag]
 		}
@@ -769,11 +1161,37 @@ func extractTagsWithFilter(t testing.TB, filename string, sources imagemeta.Sour
 
 	imageFormat := extToFormat(filepath.Ext(filename))
 
-	knownWarnings := []*regexp.Regexp{}
+	// Warnings expected for a given file, keyed by the path suffix.
+	knownWarnings := map[string][]*regexp.Regexp{
+		// This file has an IFD that runs past the end of the EXIF segment.
+		"metadata-extractor/crash01.jpg": {regexp.MustCompile(`^failed to decode EXIF: truncated: unexpected EOF$`)},
+	}
+	// Files with a truncated block, reported after the rest of the file is decoded, keyed by the path suffix.
+	knownTruncated := map[string]bool{
+		"metadata-extractor/crash01.jpg": true,
+	}
+
+	var expectedWarnings []*regexp.Regexp
+	for suffix, res := range knownWarnings {
+		if strings.HasSuffix(filepath.ToSlash(filename), suffix) {
+			expectedWarnings = append(expectedWarnings, res...)
+		}
+	}
+	var expectTruncated bool
+	for suffix := range knownTruncated {
+		if strings.HasSuffix(filepath.ToSlash(filename), suffix) {
+			expectTruncated = true
+		}
+	}
+	if goldenWarning(filename) == "JPEG format error" {
+		// Most of these are the first few KB of a JPEG, e.g. the goexif "-sep-" files.
+		expectTruncated = true
+		expectedWarnings = append(expectedWarnings, regexp.MustCompile(`^JPEG segment 0x[0-9a-f]+ has length \d+, but only \d+ bytes are left$`))
+	}
 
 	warnf := func(format string, args ...any) {
 		s := fmt.Sprintf(format, args...)
-		for _, re := range knownWarnings {
+		for _, re := range expectedWarnings {
 			if re.MatchString(s) {
 				return
 			}
@@ -782,6 +1200,9 @@ func extractTagsWithFilter(t testing.TB, filename string, sources imagemeta.Sour
 	}
 
 	err = imagemeta.Decode(imagemeta.Options{R: f, ImageFormat: imageFormat, ShouldHandleTag: shouldHandle, HandleTag: handleTag, Warnf: warnf, Sources: sources})
+	if expectTruncated && imagemeta.IsTruncated(err) {
+		err = nil
+	}
 	if err != nil {
 		t.Fatal(fmt.Errorf("failed to decode %q: %w", filename, err))
 	}
@@ -802,6 +1223,24 @@ func extractTagsWithFilter(t testing.TB, filename string, sources imagemeta.Sour
 	return tags
 }
 
+// goldenWarning returns the warning exiftool reported for filename, if any.
+func goldenWarning(filename string) string {
+	rel, err := filepath.Rel(filepath.Join("testdata", "images"), filename)
+	if err != nil {
+		return ""
+	}
+	b, err := os.ReadFile(filepath.Join("gen", "testdata_exiftool", "images", rel+".json"))
+	if err != nil {
+		return ""
+	}
+	var v []goldenFileInfo
+	if err := json.Unmarshal(b, &v); err != nil || len(v) == 0 {
+		return ""
+	}
+	w, _ := v[0].ExifTool["Warning"].(string)
+	return w
+}
+
 func readGoldenInfo(t testing.TB, filename string) goldenFileInfo {
 	exiftoolsJSONFilename := filepath.Join("gen", "testdata_exiftool", "images", filename+".json")
 	var exifToolValue []goldenFileInfo
@@ -976,6 +1415,12 @@ func BenchmarkDecode(b *testing.B) {
 		return err
 	})
 
+	// When only IPTC is requested, the APP1 (EXIF and XMP) segments are skipped without reading them.
+	runBenchmark(b, "jpg/exif+iptc", imageFormat, func(r io.ReadSeeker) error {
+		err := imagemeta.Decode(imagemeta.Options{R: r, ImageFormat: imageFormat, HandleTag: handleTag, Warnf: panicWarnf, Sources: sourceSetEXIF | sourceSetIPTC})
+		return err
+	})
+
 	runBenchmark(b, "jpg/iptc/category", imageFormat, func(r io.ReadSeeker) error {
 		shouldHandle := func(ti imagemeta.TagInfo) bool {
 			return ti.Tag == "Category"
@@ -1029,6 +1474,103 @@ func BenchmarkDecode(b *testing.B) {
 	})
 }
 
+func BenchmarkDecodeOrientation(b *testing.B) {
+	for _, imageFormat := range []imagemeta.ImageFormat{imagemeta.JPEG, imagemeta.PNG, imagemeta.WebP, imagemeta.TIFF} {
+		img, close := getSunrise(qt.New(b), imageFormat)
+		b.Cleanup(close)
+
+		b.Run(fmt.Sprintf("%v/orientation", imageFormat), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := imagemeta.DecodeOrientation(img, imageFormat); err != nil {
+					b.Fatal(err)
+				}
+				img.Seek(0, 0)
+			}
+		})
+
+		b.Run(fmt.Sprintf("%v/decode", imageFormat), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := imagemeta.Decode(imagemeta.Options{R: img, ImageFormat: imageFormat, Sources: imagemeta.EXIF}); err != nil {
+					b.Fatal(err)
+				}
+				img.Seek(0, 0)
+			}
+		})
+	}
+}
+
+func BenchmarkDecodeSkipIFDs(b *testing.B) {
+	for _, imageFormat := range []imagemeta.ImageFormat{imagemeta.JPEG, imagemeta.TIFF} {
+		img, close := getSunrise(qt.New(b), imageFormat)
+		b.Cleanup(close)
+
+		for _, skipIFDs := range [][]string{nil, {"GPSInfoIFD", "InteroperabilityIFD"}} {
+			name := "all"
+			if skipIFDs != nil {
+				name = "skipgps"
+			}
+			b.Run(fmt.Sprintf("%v/%s", imageFormat, name), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if err := imagemeta.Decode(imagemeta.Options{R: img, ImageFormat: imageFormat, Sources: imagemeta.EXIF, SkipIFDs: skipIFDs}); err != nil {
+						b.Fatal(err)
+					}
+					img.Seek(0, 0)
+				}
+			})
+		}
+	}
+}
+
+func TestDecodeArrayValuesNotOverwritten(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newTIFFBuilder()
+	tiff := tb.build([]tiffEntry{
+		tb.short(0x0140, 1, 2, 3, 4, 5, 6),
+		tb.short(0x0141, 7, 8),
+		tb.short(0x0150, 9, 10, 11),
+	})
+	tags, warnings := decodeBytes(c, tiff, imagemeta.TIFF, imagemeta.Options{
+		ShouldHandleTag: func(ti imagemeta.TagInfo) bool { return true },
+	})
+	c.Assert(warnings, qt.HasLen, 0)
+	exif := tags.EXIF()
+	c.Assert(exif["ColorMap"].Value, qt.DeepEquals, []any{uint16(1), uint16(2), uint16(3), uint16(4), uint16(5), uint16(6)})
+	c.Assert(exif["HalftoneHints"].Value, qt.DeepEquals, []any{uint16(7), uint16(8)})
+	c.Assert(exif["DotRange"].Value, qt.DeepEquals, []any{uint16(9), uint16(10), uint16(11)})
+}
+
+func BenchmarkDecodeArrayTags(b *testing.B) {
+	c := qt.New(b)
+	tb := newTIFFBuilder()
+	longs := make([]uint32, 64)
+	shorts := make([]uint16, 768)
+	for i := range longs {
+		longs[i] = uint32(i * 1000)
+	}
+	for i := range shorts {
+		shorts[i] = uint16(i)
+	}
+	tiff := tb.build([]tiffEntry{
+		tb.long(0x0111, longs...),
+		tb.long(0x0117, longs...),
+		tb.short(0x0140, shorts...),
+		tb.bytes(0xfe00, tiffTypeByte, make([]byte, 256)),
+		tb.sub(0x8769, tb.short(0x9214, 10, 20, 30, 40), tb.bytes(0xa302, tiffTypeUndef, make([]byte, 64))),
+	})
+	img := bytes.NewReader(jpegFile(jpegEXIFSegment(tiff)))
+	_, warnings := decodeBytes(c, jpegFile(jpegEXIFSegment(tiff)), imagemeta.JPEG, imagemeta.Options{})
+	c.Assert(warnings, qt.HasLen, 0)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := imagemeta.Decode(imagemeta.Options{R: img, ImageFormat: imagemeta.JPEG, Sources: imagemeta.EXIF}); err != nil {
+			b.Fatal(err)
+		}
+		img.Seek(0, 0)
+	}
+}
+
 func BenchmarkDecodeCompareWithGoexif(b *testing.B) {
 	runBenchmark := func(b *testing.B, name string, imageFormat imagemeta.ImageFormat, f func(r io.ReadSeeker) error) {
 		img, close := getSunrise(qt.New(b), imageFormat)
@@ -1085,3 +1627,3315 @@ func BenchmarkDecodeCompareWithGoexif(b *testing.B) {
 func panicWarnf(format string, args ...interface{}) {
 	panic(fmt.Errorf(format, args...))
 }
+
+func TestDecodeXMPWithBOMInTIFF(t *testing.T) {
+	c := qt.New(t)
+
+	// The ApplicationNotes in sunrise.tif is a real xpacket wrapped XMP packet,
+	// and TestGoldenXMP checks it against exiftool.
+	// None of the TIFFs in testdata has a BOM in front of the packet, so add one here.
+	tags := extractTags(t, "sunrise.tif", imagemeta.XMP)
+	want := tags.XMP()
+	c.Assert(want["CreatorTool"].Value, qt.Equals, "Adobe Photoshop Lightroom Classic 12.4 (Macintosh)")
+
+	b := readTestDataFileAll(t, "sunrise.tif")
+	lo := bytes.Index(b, []byte("<?xpacket begin="))
+	hi := bytes.Index(b, []byte("<?xpacket end="))
+	c.Assert(lo, qt.Not(qt.Equals), -1)
+	c.Assert(hi, qt.Not(qt.Equals), -1)
+	xmp := string(b[lo : hi+bytes.Index(b[hi:], []byte("?>"))+2])
+
+	utf16 := func(s string, order binary.ByteOrder) []byte {
+		b := appendUint16(order, nil, 0xfeff)
+		for _, r := range utf16.Encode([]rune(s)) {
+			b = appendUint16(order, b, r)
+		}
+		return b
+	}
+
+	for _, test := range []struct {
+		name   string
+		packet []byte
+	}{
+		{"UTF-8 BOM", append([]byte("\ufeff"), xmp...)},
+		{"UTF-16BE BOM", utf16(xmp, binary.BigEndian)},
+		{"UTF-16LE BOM", utf16(xmp, binary.LittleEndian)},
+	} {
+		c.Run(test.name, func(c *qt.C) {
+			tb := newTIFFBuilder()
+			tiff := tb.build([]tiffEntry{
+				tb.short(0x0112, 1),
+				tb.bytes(0x02bc, tiffTypeByte, test.packet),
+			})
+			tags, warnings := decodeBytes(c, tiff, imagemeta.TIFF, imagemeta.Options{})
+			c.Assert(warnings, qt.HasLen, 0)
+			c.Assert(tags.XMP(), eq, want)
+			c.Assert(tags.EXIF()["Orientation"].Value, qt.Equals, uint16(1))
+		})
+	}
+}
+
+func TestDecodeTIFFIPTCAndXMPInOtherIFDs(t *testing.T) {
+	c := qt.New(t)
+
+	iptcRecord := func(id uint8, value string) []byte {
+		b := []byte{0x1c, 2, id}
+		b = appendUint16(binary.BigEndian, b, uint16(len(value)))
+		return append(b, value...)
+	}
+
+	xmpPacket := func(attrs string) []byte {
+		return []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description xmlns:xmp="http://ns.adobe.com/xap/1.0/" ` + attrs + `/></rdf:RDF></x:xmpmeta>`)
+	}
+
+	tb := newTIFFBuilder()
+	tiff := tb.build(
+		[]tiffEntry{
+			tb.short(0x0112, 1),
+			tb.bytes(0x02bc, tiffTypeByte, xmpPacket(`xmp:CreatorTool="Bluefish"`)),
+			tb.sub(0x014a,
+				tb.short(0x0112, 3),
+				tb.bytes(0x02bc, tiffTypeByte, xmpPacket(`xmp:Label="Red"`)),
+				tb.bytes(0x83bb, tiffTypeUndef, iptcRecord(5, "Sunrise")),
+			),
+		},
+		// The second page.
+		[]tiffEntry{
+			tb.short(0x0112, 6),
+			tb.bytes(0x83bb, tiffTypeUndef, iptcRecord(90, "Benalmadena")),
+		},
+	)
+
+	for _, sources := range []imagemeta.Source{0, imagemeta.IPTC | imagemeta.XMP} {
+		tags, warnings := decodeBytes(c, tiff, imagemeta.TIFF, imagemeta.Options{
+			Sources:         sources,
+			ShouldHandleTag: func(ti imagemeta.TagInfo) bool { return true },
+		})
+		c.Assert(warnings, qt.HasLen, 0)
+		// All XMP packets are decoded.
+		c.Assert(tags.XMP()["CreatorTool"].Value, qt.Equals, "Bluefish")
+		c.Assert(tags.XMP()["Label"].Value, qt.Equals, "Red")
+		// Only the first IPTC block is decoded, IFD1 comes before the SubIFDs.
+		iptc := tags.IPTC()
+		c.Assert(iptc["City"].Value, qt.Equals, "Benalmadena")
+		c.Assert(iptc["ObjectName"].Value, qt.IsNil)
+		if sources == 0 {
+			// The EXIF tags outside IFD0 are not decoded.
+			c.Assert(tags.EXIF()["Orientation"].Value, qt.Equals, uint16(1))
+		} else {
+			c.Assert(tags.EXIF(), qt.HasLen, 0)
+		}
+	}
+
+	tags, _ := decodeBytes(c, tiff, imagemeta.TIFF, imagemeta.Options{AllowDuplicateBlocks: true})
+	c.Assert(tags.IPTC()["City"].Value, qt.Equals, "Benalmadena")
+	c.Assert(tags.IPTC()["ObjectName"].Value, qt.Equals, "Sunrise")
+
+	// IPTC in IFD0 wins.
+	tiff = tb.build(
+		[]tiffEntry{tb.bytes(0x83bb, tiffTypeUndef, iptcRecord(5, "IFD0"))},
+		[]tiffEntry{tb.bytes(0x83bb, tiffTypeUndef, iptcRecord(5, "IFD1"))},
+	)
+	tags, _ = decodeBytes(c, tiff, imagemeta.TIFF, imagemeta.Options{})
+	c.Assert(tags.IPTC()["ObjectName"].Value, qt.Equals, "IFD0")
+	tags, _ = decodeBytes(c, tiff, imagemeta.TIFF, imagemeta.Options{AllowDuplicateBlocks: true})
+	c.Assert(tags.IPTC()["ObjectName"].Value, qt.Equals, "IFD1")
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	c := qt.New(t)
+
+	b := readTestDataFileAll(c, "sunrise.jpg")
+	// Cut the file off inside the EXIF segment.
+	b = b[:200]
+
+	var warnings []string
+	err := imagemeta.Decode(imagemeta.Options{R: bytes.NewReader(b), ImageFormat: imagemeta.JPEG, Warnf: func(format string, args ...any) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}})
+	c.Assert(err, qt.IsNotNil)
+	c.Assert(imagemeta.IsTruncated(err), qt.IsTrue)
+	c.Assert(imagemeta.IsInvalidFormat(err), qt.IsTrue)
+	c.Assert(errors.Is(err, io.ErrUnexpectedEOF), qt.IsTrue)
+	c.Assert(warnings, qt.DeepEquals, []string{
+		"JPEG segment 0xffe1 has length 7234, but only 194 bytes are left",
+		"failed to decode EXIF: truncated: unexpected EOF",
+	})
+
+	for _, test := range []struct {
+		filename string
+		cuts     []int
+		warnings []string // Regular expressions.
+	}{
+		{"sunrise.png", []int{20, 40}, nil},
+		// Inside the IFDs and the values in the EXIF segment.
+		{"sunrise.jpg", []int{354, 355, 358, 920, 921, 924, 1314, 1317}, []string{
+			`^JPEG segment 0xffe1 has length 7234, but only \d+ bytes are left$`,
+			`^failed to decode EXIF: truncated: unexpected EOF$`,
+		}},
+		// Inside the XMP segment.
+		{"sunrise.jpg", []int{20000, 30000}, []string{`^JPEG segment 0xffe1 has length 25611, but only \d+ bytes are left$`}},
+	} {
+		b := readTestDataFileAll(c, test.filename)
+		for _, n := range test.cuts {
+			var warnings []string
+			err := imagemeta.Decode(imagemeta.Options{R: bytes.NewReader(b[:n]), ImageFormat: extToFormat(filepath.Ext(test.filename)), Warnf: func(format string, args ...any) {
+				warnings = append(warnings, fmt.Sprintf(format, args...))
+			}})
+			c.Assert(warnings, qt.HasLen, len(test.warnings), qt.Commentf("%s[:%d]: %q", test.filename, n, warnings))
+			for i, w := range warnings {
+				c.Assert(w, qt.Matches, regexp.MustCompile(test.warnings[i]))
+			}
+			c.Assert(imagemeta.IsTruncated(err), qt.IsTrue, qt.Commentf("%s[:%d]: %v", test.filename, n, err))
+			// Don't wrap the error twice.
+			c.Assert(strings.Count(err.Error(), "truncated:"), qt.Equals, 1, qt.Commentf("%v", err))
+			c.Assert(strings.Contains(err.Error(), "invalid format:"), qt.IsFalse, qt.Commentf("%v", err))
+		}
+	}
+
+	var truncatedErr error = &imagemeta.TruncatedError{Err: io.EOF}
+	c.Assert(errors.Is(truncatedErr, io.EOF), qt.IsTrue)
+	c.Assert(errors.Is(truncatedErr, io.ErrUnexpectedEOF), qt.IsFalse)
+	c.Assert(imagemeta.IsTruncated(truncatedErr), qt.IsTrue)
+
+	// A corrupt file is not a truncated file.
+	img, err := os.Open(filepath.Join("testdata", "images", "corrupt", "infinite_loop_exif.jpg"))
+	c.Assert(err, qt.IsNil)
+	defer img.Close()
+	warnings = nil
+	err = imagemeta.Decode(imagemeta.Options{R: img, ImageFormat: imagemeta.JPEG, Warnf: func(format string, args ...any) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}})
+	c.Assert(imagemeta.IsInvalidFormat(err), qt.IsTrue)
+	c.Assert(imagemeta.IsTruncated(err), qt.IsFalse)
+	c.Assert(warnings, qt.DeepEquals, []string{"failed to decode EXIF: " + err.Error()})
+}
+
+func TestDecodeJPEGCorruptEXIFWithIPTC(t *testing.T) {
+	c := qt.New(t)
+
+	// An APP13 segment with the IPTC City.
+	iptc := []byte{0x1c, 2, 90, 0, 4}
+	iptc = append(iptc, "Oslo"...)
+	resource := []byte("8BIM\x04\x04\x00\x00")
+	resource = appendUint32(binary.BigEndian, resource, uint32(len(iptc)))
+	resource = append(resource, iptc...)
+	app13 := jpegSegment(0xffed, append([]byte("Photoshop 3.0\x00"), resource...))
+
+	tb := newTIFFBuilder()
+
+	// IFD0 claims to have 50 entries, but the EXIF segment ends after the first.
+	ifdPastEnd := tb.build([]tiffEntry{tb.ascii(0x010f, "Foo")})
+	binary.BigEndian.PutUint16(ifdPastEnd[8:], 50)
+	ifdPastEnd = ifdPastEnd[:8+2+12]
+
+	// The Make value runs past the end of the EXIF segment.
+	valuePastEnd := tb.build([]tiffEntry{tb.raw(0x010f, tiffTypeASCII, 100, []byte("Hello, World"))})
+
+	// The Make value starts exactly at the end of the EXIF segment.
+	valueAtEnd := tb.build([]tiffEntry{tb.raw(0x010f, tiffTypeASCII, 12, []byte("Hello, World"))})
+	valueAtEnd = valueAtEnd[:len(valueAtEnd)-12]
+	c.Assert(int(binary.BigEndian.Uint32(valueAtEnd[18:])), qt.Equals, len(valueAtEnd))
+
+	// All of them are reported the same way, after the IPTC is decoded.
+	for _, tiff := range [][]byte{ifdPastEnd, valuePastEnd, valueAtEnd} {
+		b := jpegFile(jpegEXIFSegment(tiff), app13)
+		var tags imagemeta.Tags
+		var warnings []string
+		err := imagemeta.Decode(imagemeta.Options{
+			R:           bytes.NewReader(b),
+			ImageFormat: imagemeta.JPEG,
+			HandleTag: func(ti imagemeta.TagInfo) error {
+				tags.Add(ti)
+				return nil
+			},
+			Warnf: func(format string, args ...any) {
+				warnings = append(warnings, fmt.Sprintf(format, args...))
+			},
+		})
+		c.Assert(imagemeta.IsTruncated(err), qt.IsTrue, qt.Commentf("%v", err))
+		c.Assert(warnings, qt.DeepEquals, []string{"failed to decode EXIF: truncated: unexpected EOF"})
+		c.Assert(tags.IPTC()["City"].Value, qt.Equals, "Oslo")
+	}
+}
+
+func TestDecodeCollectWarnings(t *testing.T) {
+	c := qt.New(t)
+
+	// This file has an IFD that runs past the end of the EXIF segment.
+	b := readTestDataFileAll(c, "metadata-extractor/crash01.jpg")
+
+	result, err := imagemeta.DecodeWithResult(imagemeta.Options{R: bytes.NewReader(b), ImageFormat: imagemeta.JPEG})
+	c.Assert(imagemeta.IsTruncated(err), qt.IsTrue)
+	c.Assert(result.Warnings, qt.IsNil)
+
+	var warnings []string
+	result, err = imagemeta.DecodeWithResult(imagemeta.Options{
+		R:               bytes.NewReader(b),
+		ImageFormat:     imagemeta.JPEG,
+		CollectWarnings: true,
+		Warnf: func(format string, args ...any) {
+			warnings = append(warnings, fmt.Sprintf(format, args...))
+		},
+	})
+	c.Assert(imagemeta.IsTruncated(err), qt.IsTrue)
+	c.Assert(result.Warnings, qt.DeepEquals, []string{"failed to decode EXIF: truncated: unexpected EOF"})
+	c.Assert(warnings, qt.DeepEquals, result.Warnings)
+
+	// Warnf is optional.
+	result, err = imagemeta.DecodeWithResult(imagemeta.Options{R: bytes.NewReader(b), ImageFormat: imagemeta.JPEG, CollectWarnings: true})
+	c.Assert(imagemeta.IsTruncated(err), qt.IsTrue)
+	c.Assert(result.Warnings, qt.HasLen, 1)
+}
+
+func TestDecodeASCIIWithoutNUL(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newTIFFBuilder()
+
+	// No NUL terminator, the count matches the string length.
+	tiff := tb.build([]tiffEntry{tb.raw(0x010f, tiffTypeASCII, 11, []byte("Hello World")), tb.ascii(0x0110, "Model")})
+	tags, warnings := decodeBytes(c, tiff, imagemeta.TIFF, imagemeta.Options{})
+	c.Assert(warnings, qt.HasLen, 0)
+	c.Assert(tags.EXIF()["Make"].Value, qt.Equals, "Hello World")
+	c.Assert(tags.EXIF()["Model"].Value, qt.Equals, "Model")
+
+	// The count includes a NUL terminator that isn't there,
+	// and the value is the last thing in the file.
+	tiff = tb.build([]tiffEntry{tb.raw(0x010f, tiffTypeASCII, 13, []byte("Hello, World"))})
+	c.Assert(string(tiff[len(tiff)-12:]), qt.Equals, "Hello, World")
+	for _, b := range [][]byte{tiff, jpegFile(jpegEXIFSegment(tiff))} {
+		format := imagemeta.TIFF
+		if b[0] == 0xff {
+			format = imagemeta.JPEG
+		}
+		tags, warnings = decodeBytes(c, b, format, imagemeta.Options{})
+		c.Assert(warnings, qt.HasLen, 0)
+		c.Assert(tags.EXIF()["Make"].Value, qt.Equals, "Hello, World")
+	}
+
+	// More than the NUL terminator missing.
+	tiff = tb.build([]tiffEntry{tb.raw(0x010f, tiffTypeASCII, 14, []byte("Hello, World"))})
+	err := imagemeta.Decode(imagemeta.Options{R: bytes.NewReader(tiff), ImageFormat: imagemeta.TIFF, Warnf: panicWarnf})
+	c.Assert(imagemeta.IsTruncated(err), qt.IsTrue)
+}
+
+func TestDecodeMaxSegments(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newTIFFBuilder()
+	tiff := tb.build([]tiffEntry{tb.ascii(0x010f, "Canon")})
+
+	var jpegSegments, webpChunks [][]byte
+	for i := 0; i < 100; i++ {
+		jpegSegments = append(jpegSegments, jpegSegment(0xfffe, nil))
+		webpChunks = append(webpChunks, webpChunk("JUNK", nil))
+	}
+	jpeg := jpegFile(append(jpegSegments, jpegEXIFSegment(tiff))...)
+	webp := webpFile(append(webpChunks, webpChunk("EXIF", tiff))...)
+
+	for _, test := range []struct {
+		b      []byte
+		format imagemeta.ImageFormat
+	}{
+		{jpeg, imagemeta.JPEG},
+		{webp, imagemeta.WebP},
+	} {
+		tags, warnings := decodeBytes(c, test.b, test.format, imagemeta.Options{})
+		c.Assert(warnings, qt.HasLen, 0)
+		c.Assert(tags.EXIF()["Make"].Value, qt.Equals, "Canon")
+
+		tags, warnings = decodeBytes(c, test.b, test.format, imagemeta.Options{MaxSegments: 50})
+		c.Assert(warnings, qt.DeepEquals, []string{"stopped scanning after 50 segments"})
+		c.Assert(tags.EXIF()["Make"].Value, qt.IsNil)
+	}
+}
+
+func TestDecodeJPEGEXIFHeaderPadding(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newTIFFBuilder()
+	tiff := tb.build(
+		[]tiffEntry{
+			tb.ascii(0x010f, "Canon"),
+			tb.ascii(0x0110, "Canon EOS R5"),
+			tb.sub(0x8769, tb.ascii(0x9003, "2024:01:02 10:00:00")),
+		},
+		[]tiffEntry{
+			tb.long(0x0100, 160),
+		},
+	)
+
+	for _, test := range []struct {
+		name    string
+		payload string
+	}{
+		{"standard", "Exif\x00\x00"},
+		{"padding before marker", "\x00\x00Exif\x00\x00"},
+		{"padding after marker", "Exif\x00\x00\x00\x00"},
+		{"padding before and after marker", "\x00Exif\x00\x00\x00"},
+	} {
+		c.Run(test.name, func(c *qt.C) {
+			b := jpegFile(jpegSegment(0xffe1, append([]byte(test.payload), tiff...)))
+			tags, warnings := decodeBytes(c, b, imagemeta.JPEG, imagemeta.Options{
+				ShouldHandleTag: func(ti imagemeta.TagInfo) bool { return true },
+			})
+			c.Assert(warnings, qt.HasLen, 0)
+			exif := tags.EXIF()
+			c.Assert(exif["Make"].Value, qt.Equals, "Canon")
+			c.Assert(exif["Model"].Value, qt.Equals, "Canon EOS R5")
+			c.Assert(exif["DateTimeOriginal"].Value, qt.Equals, "2024:01:02 10:00:00")
+			c.Assert(exif["ImageWidth"].Value, eq, uint32(160))
+		})
+	}
+
+	// Too much padding.
+	b := jpegFile(jpegSegment(0xffe1, append([]byte("\x00\x00\x00\x00\x00\x00\x00\x00\x00Exif\x00\x00"), tiff...)))
+	tags, _ := decodeBytes(c, b, imagemeta.JPEG, imagemeta.Options{})
+	c.Assert(tags.EXIF(), qt.HasLen, 0)
+}
+
+func TestDecodeJPEGSegmentLengthPastEOF(t *testing.T) {
+	c := qt.New(t)
+
+	// The APP1 segment claims to be 65535 bytes long, but the file ends right after the EXIF data.
+	b := readTestDataFileAll(c, "corrupt/huge_app1_length.jpg")
+
+	var tags imagemeta.Tags
+	result, err := imagemeta.DecodeWithResult(imagemeta.Options{
+		R:               bytes.NewReader(b),
+		ImageFormat:     imagemeta.JPEG,
+		CollectWarnings: true,
+		HandleTag: func(ti imagemeta.TagInfo) error {
+			tags.Add(ti)
+			return nil
+		},
+	})
+	c.Assert(imagemeta.IsTruncated(err), qt.IsTrue)
+	c.Assert(err.Error(), qt.Equals, "truncated: JPEG segment 0xffe1: unexpected EOF")
+	c.Assert(result.Warnings, qt.DeepEquals, []string{"JPEG segment 0xffe1 has length 65533, but only 34 bytes are left"})
+	c.Assert(tags.EXIF()["Make"].Value, qt.Equals, "Foo")
+}
+
+func TestDecodeJPEGSegmentLengthPastEOFRealFiles(t *testing.T) {
+	c := qt.New(t)
+
+	decode := func(b []byte) (imagemeta.Tags, []string, error) {
+		var tags imagemeta.Tags
+		result, err := imagemeta.DecodeWithResult(imagemeta.Options{
+			R:               bytes.NewReader(b),
+			ImageFormat:     imagemeta.JPEG,
+			Sources:         imagemeta.EXIF | imagemeta.IPTC,
+			CollectWarnings: true,
+			HandleTag: func(ti imagemeta.TagInfo) error {
+				tags.Add(ti)
+				return nil
+			},
+		})
+		return tags, result.Warnings, err
+	}
+
+	// Real files cut off in the APP13 segment, which comes after the EXIF segment in all of them.
+	// What's left of the IPTC is decoded, but the truncation is reported.
+	for _, filename := range []string{"sunrise.jpg", "smoke/hugo-issue-10738/canon_cr2_fraction.jpg", "metadata-extractor/nikonMakernoteType1.jpg"} {
+		b := readTestDataFileAll(c, filename)
+		i := bytes.Index(b, []byte("Photoshop 3.0\x00"))
+		c.Assert(i, qt.Not(qt.Equals), -1)
+
+		want, _, err := decode(b)
+		c.Assert(err, qt.IsNil)
+		cuts := []int{i + 1, i + 100, i + 1000}
+		if filename == "sunrise.jpg" {
+			cuts = append(cuts, 7300, 10000)
+		}
+		for _, n := range cuts {
+			got, warnings, err := decode(b[:n])
+			c.Assert(imagemeta.IsTruncated(err), qt.IsTrue, qt.Commentf("%s[:%d]: %v", filename, n, err))
+			c.Assert(err.Error(), qt.Equals, "truncated: JPEG segment 0xffed: unexpected EOF")
+			c.Assert(warnings, qt.HasLen, 1)
+			c.Assert(warnings[0], qt.Matches, `JPEG segment 0xffed has length \d+, but only \d+ bytes are left`)
+			c.Assert(got.EXIF(), eq, want.EXIF(), qt.Commentf(filename))
+			c.Assert(len(got.IPTC()) <= len(want.IPTC()), qt.IsTrue)
+		}
+	}
+}
+
+func TestGetGPSAccuracy(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newTIFFBuilder()
+	tiff := tb.build([]tiffEntry{
+		tb.sub(0x8825,
+			tb.rational(0x000b, 27, 10),
+			tb.rational(0x001f, 5, 2),
+		),
+	})
+
+	tags, _ := decodeBytes(c, jpegFile(jpegEXIFSegment(tiff)), imagemeta.JPEG, imagemeta.Options{})
+	hpe, dop, ok := tags.GetGPSAccuracy()
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(hpe, eq, 2.5)
+	c.Assert(dop, eq, 2.7)
+
+	tags = extractTags(t, "sunrise.jpg", imagemeta.EXIF)
+	_, _, ok = tags.GetGPSAccuracy()
+	c.Assert(ok, qt.IsFalse)
+}
+
+func TestGPSFixQuality(t *testing.T) {
+	c := qt.New(t)
+
+	decode := func(decodeEnumLabels bool, entries ...tiffEntry) imagemeta.Tags {
+		tb := newTIFFBuilder()
+		tiff := tb.build([]tiffEntry{tb.sub(0x8825, entries...)})
+		tags, warnings := decodeBytes(c, jpegFile(jpegEXIFSegment(tiff)), imagemeta.JPEG, imagemeta.Options{DecodeEnumLabels: decodeEnumLabels})
+		c.Assert(warnings, qt.HasLen, 0)
+		return tags
+	}
+
+	tb := newTIFFBuilder()
+	for _, decodeEnumLabels := range []bool{false, true} {
+		tags := decode(decodeEnumLabels, tb.ascii(0x0009, "A"), tb.short(0x001e, 1))
+		differential, active, ok := tags.GPSFixQuality()
+		c.Assert(ok, qt.IsTrue)
+		c.Assert(differential, qt.IsTrue)
+		c.Assert(active, qt.IsTrue)
+		if decodeEnumLabels {
+			c.Assert(tags.EXIF()["GPSStatus"].Value, qt.Equals, "Measurement Active")
+			c.Assert(tags.EXIF()["GPSDifferential"].Value, qt.Equals, "Differential Corrected")
+		} else {
+			c.Assert(tags.EXIF()["GPSStatus"].Value, qt.Equals, "A")
+			c.Assert(tags.EXIF()["GPSDifferential"].Value, eq, uint16(1))
+		}
+
+		tags = decode(decodeEnumLabels, tb.ascii(0x0009, "V"), tb.short(0x001e, 0))
+		differential, active, ok = tags.GPSFixQuality()
+		c.Assert(ok, qt.IsTrue)
+		c.Assert(differential, qt.IsFalse)
+		c.Assert(active, qt.IsFalse)
+	}
+
+	// GPSStatus only.
+	differential, active, ok := decode(false, tb.ascii(0x0009, "V")).GPSFixQuality()
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(differential, qt.IsFalse)
+	c.Assert(active, qt.IsFalse)
+
+	tags := decode(true, tb.ascii(0x0009, "X"))
+	c.Assert(tags.EXIF()["GPSStatus"].Value, qt.Equals, "Unknown (X)")
+
+	tags = extractTags(t, "sunrise.jpg", imagemeta.EXIF)
+	differential, active, ok = tags.GPSFixQuality()
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(differential, qt.IsFalse)
+	c.Assert(active, qt.IsTrue)
+
+	_, _, ok = imagemeta.Tags{}.GPSFixQuality()
+	c.Assert(ok, qt.IsFalse)
+}
+
+func TestDecodeMakerNoteApple(t *testing.T) {
+	c := qt.New(t)
+
+	b, err := os.ReadFile(filepath.Join("testdata", "images", "goexif", "has-lens-info.jpg"))
+	c.Assert(err, qt.IsNil)
+
+	tags, warnings := decodeBytes(c, b, imagemeta.JPEG, imagemeta.Options{Sources: imagemeta.EXIF})
+	c.Assert(warnings, qt.HasLen, 0)
+	c.Assert(tags.EXIF()["Apple.AETarget"].Value, qt.IsNil)
+
+	tags, warnings = decodeBytes(c, b, imagemeta.JPEG, imagemeta.Options{Sources: imagemeta.EXIF, DecodeMakerNotes: true})
+	c.Assert(warnings, qt.HasLen, 0)
+	exif := tags.EXIF()
+	c.Assert(exif["MakerNote"].Value, qt.IsNil)
+	c.Assert(exif["Apple.AETarget"].Namespace, qt.Equals, "IFD0/ExifIFDP/Apple")
+	c.Assert(exif["Apple.AETarget"].Value, eq, int32(169))
+	c.Assert(exif["Apple.AEAverage"].Value, eq, int32(175))
+	c.Assert(exif["Apple.AFStable"].Value, eq, int32(1))
+	c.Assert(exif["Apple.RunTime"].Value, eq, map[string]any{
+		"timescale": int64(1000000000),
+		"epoch":     int64(0),
+		"value":     int64(75459041592166),
+		"flags":     int64(1),
+	})
+}
+
+func TestDecodeDNGPrivateData(t *testing.T) {
+	c := qt.New(t)
+
+	const (
+		imageType      = "Canon EOS R5"
+		originalOffset = 1000
+	)
+
+	// The original Canon MakerNote with one ASCII tag stored after the IFD.
+	// The offsets in it are relative to the TIFF header of the original file.
+	makerNote := func(order binary.ByteOrder) []byte {
+		b := appendUint16(order, nil, 1)
+		b = appendUint16(order, b, 0x0006)
+		b = appendUint16(order, b, tiffTypeASCII)
+		b = appendUint32(order, b, uint32(len(imageType)+1))
+		b = appendUint32(order, b, originalOffset+18)
+		b = appendUint32(order, b, 0)
+		return append(b, imageType+"\x00"...)
+	}
+
+	dngPrivateData := func(order binary.ByteOrder, byteOrderMark string) []byte {
+		block := append([]byte(byteOrderMark), appendUint32(binary.BigEndian, nil, originalOffset)...)
+		block = append(block, makerNote(order)...)
+		b := []byte("Adobe\x00")
+		// A block we don't know about.
+		b = append(b, "Fooo\x00\x00\x00\x02ab"...)
+		b = append(b, "MakN"...)
+		b = appendUint32(binary.BigEndian, b, uint32(len(block)))
+		return append(b, block...)
+	}
+
+	tb := newTIFFBuilder()
+	decode := func(data []byte, opts imagemeta.Options) imagemeta.Tags {
+		tiff := tb.build([]tiffEntry{
+			tb.ascii(0x010f, "Canon"),
+			tb.bytes(0xc634, tiffTypeByte, data),
+		})
+		opts.Sources = imagemeta.EXIF
+		tags, _ := decodeBytes(c, tiff, imagemeta.TIFF, opts)
+		return tags
+	}
+
+	for _, test := range []struct {
+		order         binary.ByteOrder
+		byteOrderMark string
+	}{
+		{binary.BigEndian, "MM"},
+		{binary.LittleEndian, "II"},
+	} {
+		data := dngPrivateData(test.order, test.byteOrderMark)
+
+		tags := decode(data, imagemeta.Options{DecodeDNGPrivate: true})
+		c.Assert(tags.EXIF()["Canon.CanonImageType"].Value, qt.Equals, imageType)
+		c.Assert(tags.EXIF()["Canon.CanonImageType"].Namespace, qt.Equals, "IFD0/DNGPrivateData/Canon")
+		c.Assert(tags.EXIF()["SR2Private"].Value, qt.IsNil)
+
+		tags = decode(data, imagemeta.Options{})
+		c.Assert(tags.EXIF()["Canon.CanonImageType"].Value, qt.IsNil)
+		c.Assert(tags.EXIF()["SR2Private"].Value, qt.Not(qt.IsNil))
+	}
+
+	// Not written by Adobe, passed on as is.
+	tags := decode([]byte("Foo\x00 some private data"), imagemeta.Options{DecodeDNGPrivate: true})
+	c.Assert(tags.EXIF()["SR2Private"].Value, qt.Not(qt.IsNil))
+}
+
+func TestDecodeDNGPrivateDataRealMakerNote(t *testing.T) {
+	c := qt.New(t)
+
+	// We have no real DNG files in testdata, so store the MakerNote from a real Canon
+	// file in DNGPrivateData the same way Adobe's DNG converter does.
+	const filename = "metadata-extractor/simple.jpg"
+	b := readTestDataFileAll(c, filename)
+	tags, _ := decodeBytes(c, b, imagemeta.JPEG, imagemeta.Options{Sources: imagemeta.EXIF, KeepMakerNoteRaw: true})
+	makerNote, ok := tags.EXIF()["MakerNote"].Value.([]byte)
+	c.Assert(ok, qt.IsTrue)
+	tiffStart := bytes.Index(b, []byte("Exif\x00\x00")) + 6
+	makerNoteStart := bytes.Index(b, makerNote)
+	c.Assert(makerNoteStart > tiffStart, qt.IsTrue)
+	// Some of the values are stored after the MakerNote, so copy the rest of the APP1 segment as well.
+	segmentEnd := tiffStart - 8 + int(binary.BigEndian.Uint16(b[tiffStart-8:]))
+
+	// The EXIF block in the original file is big endian, the MakerNote little endian.
+	block := append([]byte("MM"), appendUint32(binary.BigEndian, nil, uint32(makerNoteStart-tiffStart))...)
+	block = append(block, b[makerNoteStart:segmentEnd]...)
+	data := append([]byte("Adobe\x00MakN"), appendUint32(binary.BigEndian, nil, uint32(len(block)))...)
+	data = append(data, block...)
+
+	tb := newTIFFBuilder()
+	tiff := tb.build([]tiffEntry{
+		tb.ascii(0x010f, "Canon"),
+		tb.bytes(0xc634, tiffTypeByte, data),
+	})
+	tags, warnings := decodeBytes(c, tiff, imagemeta.TIFF, imagemeta.Options{Sources: imagemeta.EXIF, DecodeDNGPrivate: true})
+	c.Assert(warnings, qt.HasLen, 0)
+
+	golden := readGoldenInfo(t, filename).MakerNotes
+	exif := tags.EXIF()
+	for _, name := range []string{"CanonImageType", "CanonFirmwareVersion", "FileNumber", "OwnerName", "CanonModelID"} {
+		ti := exif["Canon."+name]
+		c.Assert(ti.Namespace, qt.Equals, "IFD0/DNGPrivateData/Canon", qt.Commentf(name))
+		got := ti.Value
+		if v, ok := got.(uint32); ok {
+			got = float64(v)
+		}
+		c.Assert(got, eq, golden[name], qt.Commentf(name))
+	}
+}
+
+func TestDecodeDNGPrivateDataPreviewImage(t *testing.T) {
+	c := qt.New(t)
+
+	const originalOffset = 1000
+
+	// A Canon MakerNote with a PreviewImageInfo pointing into the original file,
+	// out of range in the DNG, with a length larger than the DNG preview.
+	makerNote := func() []byte {
+		b := appendUint16(binary.BigEndian, nil, 1)
+		b = appendUint16(binary.BigEndian, b, 0x00b6)
+		b = appendUint16(binary.BigEndian, b, tiffTypeLong)
+		b = appendUint32(binary.BigEndian, b, 7)
+		b = appendUint32(binary.BigEndian, b, originalOffset+18)
+		b = appendUint32(binary.BigEndian, b, 0)
+		for _, v := range []uint32{28, 2, 1 << 20, 160, 120, 0x7fff0000, 0} {
+			b = appendUint32(binary.BigEndian, b, v)
+		}
+		return b
+	}
+	block := append([]byte("MM"), appendUint32(binary.BigEndian, nil, originalOffset)...)
+	block = append(block, makerNote()...)
+	data := append([]byte("Adobe\x00MakN"), appendUint32(binary.BigEndian, nil, uint32(len(block)))...)
+	data = append(data, block...)
+
+	preview := jpegFile(jpegSegment(0xffe0, []byte("JFIF\x00")))
+
+	tb := newTIFFBuilder()
+	build := func(offset uint32) []byte {
+		return tb.build([]tiffEntry{
+			tb.long(0x00fe, 1),
+			tb.short(0x0103, 7),
+			tb.ascii(0x010f, "Canon"),
+			tb.long(0x0111, offset),
+			tb.long(0x0117, uint32(len(preview))),
+			tb.bytes(0xc634, tiffTypeByte, data),
+		})
+	}
+	tiff := build(0)
+	tiff = append(build(uint32(len(tiff))), preview...)
+
+	for _, decodeMakerNotes := range []bool{false, true} {
+		var got []byte
+		tags, warnings := decodeBytes(c, tiff, imagemeta.TIFF, imagemeta.Options{
+			Sources:          imagemeta.EXIF,
+			DecodeDNGPrivate: true,
+			DecodeMakerNotes: decodeMakerNotes,
+			HandlePreviewImage: func(r io.Reader) error {
+				var err error
+				got, err = io.ReadAll(r)
+				return err
+			},
+		})
+		c.Assert(warnings, qt.HasLen, 0)
+		c.Assert(tags.EXIF()["Canon.PreviewImageInfo"].Namespace, qt.Equals, "IFD0/DNGPrivateData/Canon")
+		c.Assert(bytes.Equal(got, preview), qt.IsTrue)
+	}
+}
+
+func TestDecodeKeepMakerNoteRaw(t *testing.T) {
+	c := qt.New(t)
+
+	b, err := os.ReadFile(filepath.Join("testdata", "images", "goexif", "has-lens-info.jpg"))
+	c.Assert(err, qt.IsNil)
+
+	tags, _ := decodeBytes(c, b, imagemeta.JPEG, imagemeta.Options{Sources: imagemeta.EXIF})
+	_, isString := tags.EXIF()["MakerNoteApple"].Value.(string)
+	c.Assert(isString, qt.IsTrue)
+
+	for _, decodeMakerNotes := range []bool{false, true} {
+		tags, warnings := decodeBytes(c, b, imagemeta.JPEG, imagemeta.Options{Sources: imagemeta.EXIF, KeepMakerNoteRaw: true, DecodeMakerNotes: decodeMakerNotes})
+		c.Assert(warnings, qt.HasLen, 0)
+		exif := tags.EXIF()
+		raw, ok := exif["MakerNote"].Value.([]byte)
+		c.Assert(ok, qt.IsTrue)
+		c.Assert(bytes.HasPrefix(raw, []byte("Apple iOS\x00")), qt.IsTrue)
+		_, found := exif["Apple.AETarget"]
+		c.Assert(found, qt.Equals, decodeMakerNotes)
+
+		// The blob is a big endian IFD after the 14 byte header.
+		ifd := raw[14:]
+		numEntries := binary.BigEndian.Uint16(ifd)
+		c.Assert(numEntries > 0, qt.IsTrue)
+		c.Assert(binary.BigEndian.Uint16(ifd[2:]), qt.Equals, uint16(0x0001)) // MakerNoteVersion
+
+		// []byte is marshaled as base64.
+		j, err := json.Marshal(exif["MakerNote"].Value)
+		c.Assert(err, qt.IsNil)
+		var roundTrip []byte
+		c.Assert(json.Unmarshal(j, &roundTrip), qt.IsNil)
+		c.Assert(roundTrip, qt.DeepEquals, raw)
+	}
+}
+
+func TestDecodeMakerNotePanasonic(t *testing.T) {
+	c := qt.New(t)
+
+	b := readTestDataFileAll(c, "metadata-extractor/withPanasonicFaces.jpg")
+
+	tags, warnings := decodeBytes(c, b, imagemeta.JPEG, imagemeta.Options{Sources: imagemeta.EXIF, DecodeMakerNotes: true})
+	c.Assert(warnings, qt.HasLen, 0)
+	exif := tags.EXIF()
+	c.Assert(exif["Panasonic.ImageQuality"].Namespace, qt.Equals, "IFD0/ExifIFDP/Panasonic")
+	c.Assert(exif["Panasonic.ImageQuality"].Value, eq, uint16(2))
+	c.Assert(exif["Panasonic.FirmwareVersion"].Value, qt.Equals, "0 1 0 0")
+	c.Assert(exif["Panasonic.InternalSerialNumber"].Value, qt.Equals, "F541005110191P")
+	c.Assert(exif["Panasonic.City"].Value, qt.Equals, "OLDENBURG (OLDB.)")
+	c.Assert(exif["Panasonic.WBRedLevel"].Value, eq, uint16(1794))
+
+	// A Leica made by Panasonic, with an entry of a nonstandard type.
+	makerNote := []byte("LEICA\x00\x00\x00")
+	makerNote = appendUint16(binary.BigEndian, makerNote, 2)
+	makerNote = appendUint16(binary.BigEndian, makerNote, 0x0001)
+	makerNote = appendUint16(binary.BigEndian, makerNote, 3)
+	makerNote = appendUint32(binary.BigEndian, makerNote, 1)
+	makerNote = appendUint32(binary.BigEndian, makerNote, 0x00030000)
+	makerNote = appendUint16(binary.BigEndian, makerNote, 0x0003)
+	makerNote = appendUint16(binary.BigEndian, makerNote, 99)
+	makerNote = appendUint32(binary.BigEndian, makerNote, 1)
+	makerNote = appendUint32(binary.BigEndian, makerNote, 0)
+	makerNote = appendUint32(binary.BigEndian, makerNote, 0)
+
+	tb := newTIFFBuilder()
+	tiff := tb.build([]tiffEntry{
+		tb.ascii(0x010f, "LEICA"),
+		tb.sub(0x8769, tb.bytes(0x927c, tiffTypeUndef, makerNote)),
+	})
+	tags, warnings = decodeBytes(c, jpegFile(jpegEXIFSegment(tiff)), imagemeta.JPEG, imagemeta.Options{Sources: imagemeta.EXIF, DecodeMakerNotes: true})
+	c.Assert(warnings, qt.HasLen, 0)
+	exif = tags.EXIF()
+	c.Assert(exif["Leica.ImageQuality"].Namespace, qt.Equals, "IFD0/ExifIFDP/Leica")
+	c.Assert(exif["Leica.ImageQuality"].Value, eq, uint16(3))
+	c.Assert(exif["Leica.WhiteBalance"].Value, qt.IsNil)
+}
+
+func TestDecodeMakerNotesGolden(t *testing.T) {
+	c := qt.New(t)
+
+	// Real camera samples for each of the MakerNote formats we support.
+	for _, test := range []struct {
+		filename string
+		vendor   string
+	}{
+		{"goexif/has-lens-info.jpg", "Apple"},
+		// A big endian EXIF block with a little endian MakerNote.
+		{"metadata-extractor/simple.jpg", "Canon"},
+		{"metadata-extractor/withPanasonicFaces.jpg", "Panasonic"},
+	} {
+		b := readTestDataFileAll(c, test.filename)
+		tags, warnings := decodeBytes(c, b, imagemeta.JPEG, imagemeta.Options{Sources: imagemeta.EXIF, DecodeMakerNotes: true})
+		c.Assert(warnings, qt.HasLen, 0, qt.Commentf(test.filename))
+		golden := readGoldenInfo(t, test.filename).MakerNotes
+		c.Assert(golden, qt.Not(qt.HasLen), 0)
+
+		// Values exiftool converts even with numeric output.
+		skip := map[string]bool{
+			"Panasonic.TimeSincePowerOn": true, // Divided by 100.
+			"Panasonic.Transform":        true, // Read as two int16s.
+		}
+
+		var compared int
+		for _, ti := range tags.EXIF() {
+			if !strings.HasPrefix(ti.Tag, test.vendor+".") || skip[ti.Tag] {
+				continue
+			}
+			expect, found := golden[strings.TrimPrefix(ti.Tag, test.vendor+".")]
+			if !found {
+				continue
+			}
+			var got any
+			switch v := ti.Value.(type) {
+			case string:
+				got = strings.TrimSpace(v)
+			case int32, uint32, uint16, uint8:
+				got, _ = strconv.ParseFloat(fmt.Sprint(v), 64)
+			default:
+				// Binary data, arrays and structs are formatted differently by exiftool.
+				continue
+			}
+			if s, ok := expect.(string); ok {
+				// Exiftool keeps trailing garbage after the string, e.g. "F541005110191P\b".
+				s = strings.TrimRight(strings.TrimSpace(s), "\b")
+				expect = strings.Replace(s, ", use -b option to extract", "", 1)
+			}
+			c.Assert(got, eq, expect, qt.Commentf("%s: %s", test.filename, ti.Tag))
+			compared++
+		}
+		c.Assert(compared > 2, qt.IsTrue, qt.Commentf(test.filename))
+	}
+}
+
+func TestDecodeMakerNoteOffsetSchema(t *testing.T) {
+	c := qt.New(t)
+
+	const (
+		imageType = "Canon EOS R5"
+		moved     = 100
+	)
+
+	// A Canon MakerNote with one ASCII tag stored after the IFD.
+	// The offsets in it are relative to the TIFF header.
+	makerNote := func(valueOffset uint32) []byte {
+		b := appendUint16(binary.BigEndian, nil, 1)
+		b = appendUint16(binary.BigEndian, b, 0x0006)
+		b = appendUint16(binary.BigEndian, b, tiffTypeASCII)
+		b = appendUint32(binary.BigEndian, b, uint32(len(imageType)+1))
+		b = appendUint32(binary.BigEndian, b, valueOffset)
+		b = appendUint32(binary.BigEndian, b, 0)
+		return append(b, imageType+"\x00"...)
+	}
+
+	tb := newTIFFBuilder()
+	build := func(valueOffset uint32, offsetSchema int32) []byte {
+		return tb.build([]tiffEntry{
+			tb.ascii(0x010f, "Canon"),
+			tb.sub(0x8769,
+				tb.bytes(0x927c, tiffTypeUndef, makerNote(valueOffset)),
+				tb.raw(0xea1d, tiffTypeSLong, 1, appendUint32(binary.BigEndian, nil, uint32(offsetSchema))),
+			),
+		})
+	}
+
+	// Find where the MakerNote ends up, then point the value to where it was before the file was edited.
+	makerNoteStart := bytes.Index(build(0, moved), makerNote(0))
+	c.Assert(makerNoteStart, qt.Not(qt.Equals), -1)
+	valueOffset := uint32(makerNoteStart + 18)
+
+	decode := func(tiff []byte) string {
+		tags, _ := decodeBytes(c, jpegFile(jpegEXIFSegment(tiff)), imagemeta.JPEG, imagemeta.Options{Sources: imagemeta.EXIF, DecodeMakerNotes: true})
+		v, _ := tags.EXIF()["Canon.CanonImageType"].Value.(string)
+		return v
+	}
+
+	c.Assert(decode(build(valueOffset, 0)), qt.Equals, imageType)
+	c.Assert(decode(build(valueOffset-moved, moved)), qt.Equals, imageType)
+	c.Assert(decode(build(valueOffset-moved, 0)), qt.Not(qt.Equals), imageType)
+}
+
+func TestLens(t *testing.T) {
+	c := qt.New(t)
+
+	tags := extractTags(t, "goexif/has-lens-info.jpg", imagemeta.EXIF)
+	c.Assert(tags.Lens(), qt.DeepEquals, imagemeta.LensInfo{
+		Make:  "Apple",
+		Model: "iPhone 4S back camera 4.28mm f/2.4",
+		Spec:  "4.28 4.28 2.4 2.4",
+	})
+
+	tags = extractTags(t, "smoke/hugo-issue-10738/fuji_raf_integer.jpg", imagemeta.EXIF)
+	c.Assert(tags.Lens(), qt.DeepEquals, imagemeta.LensInfo{
+		Make:   "FUJIFILM",
+		Model:  "XF16mmF1.4 R WR",
+		Spec:   "16 16 1.4 1.4",
+		Serial: "56A10735",
+	})
+
+	tags = extractTags(t, "metadata-extractor/simple.jpg", imagemeta.EXIF)
+	c.Assert(tags.Lens(), qt.DeepEquals, imagemeta.LensInfo{})
+
+	// No LensModel, but a Canon MakerNote with the lens model stored after the IFD.
+	const lensModel = "EF50mm f/1.8 STM"
+	makerNote := func(valueOffset uint32) []byte {
+		b := appendUint16(binary.BigEndian, nil, 1)
+		b = appendUint16(binary.BigEndian, b, 0x0095)
+		b = appendUint16(binary.BigEndian, b, tiffTypeASCII)
+		b = appendUint32(binary.BigEndian, b, uint32(len(lensModel)+4))
+		b = appendUint32(binary.BigEndian, b, valueOffset)
+		b = appendUint32(binary.BigEndian, b, 0)
+		return append(b, lensModel+"\x00\xff\xfe\x00"...)
+	}
+	tb := newTIFFBuilder()
+	build := func(valueOffset uint32) []byte {
+		return tb.build([]tiffEntry{
+			tb.ascii(0x010f, "Canon"),
+			tb.sub(0x8769,
+				tb.bytes(0x927c, tiffTypeUndef, makerNote(valueOffset)),
+				tb.ascii(0xa435, "123456"),
+			),
+		})
+	}
+	makerNoteStart := bytes.Index(build(0), makerNote(0))
+	c.Assert(makerNoteStart, qt.Not(qt.Equals), -1)
+	tiff := build(uint32(makerNoteStart + 18))
+
+	tags, _ = decodeBytes(c, jpegFile(jpegEXIFSegment(tiff)), imagemeta.JPEG, imagemeta.Options{Sources: imagemeta.EXIF, DecodeMakerNotes: true})
+	c.Assert(tags.Lens(), qt.DeepEquals, imagemeta.LensInfo{Model: lensModel, Serial: "123456"})
+
+	// Without MakerNote decoding.
+	tags, _ = decodeBytes(c, jpegFile(jpegEXIFSegment(tiff)), imagemeta.JPEG, imagemeta.Options{Sources: imagemeta.EXIF})
+	c.Assert(tags.Lens(), qt.DeepEquals, imagemeta.LensInfo{Serial: "123456"})
+}
+
+func TestRating(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newTIFFBuilder()
+	decode := func(entries ...tiffEntry) imagemeta.Tags {
+		tiff := tb.build(entries)
+		tags, warnings := decodeBytes(c, jpegFile(jpegEXIFSegment(tiff)), imagemeta.JPEG, imagemeta.Options{})
+		c.Assert(warnings, qt.HasLen, 0)
+		return tags
+	}
+	rating := func(entries ...tiffEntry) int {
+		tags := decode(entries...)
+		stars, ok := tags.Rating()
+		c.Assert(ok, qt.IsTrue)
+		return stars
+	}
+
+	tags := decode(tb.short(0x4746, 9), tb.short(0x4749, 250))
+	exif := tags.EXIF()
+	c.Assert(exif["Rating"].Value, qt.Equals, 5)
+	c.Assert(exif["RatingPercent"].Value, qt.Equals, 100)
+
+	c.Assert(rating(tb.short(0x4746, 3), tb.short(0x4749, 99)), qt.Equals, 3)
+	// Only RatingPercent.
+	c.Assert(rating(tb.short(0x4749, 0)), qt.Equals, 0)
+	c.Assert(rating(tb.short(0x4749, 1)), qt.Equals, 1)
+	c.Assert(rating(tb.short(0x4749, 25)), qt.Equals, 2)
+	c.Assert(rating(tb.short(0x4749, 50)), qt.Equals, 3)
+	c.Assert(rating(tb.short(0x4749, 75)), qt.Equals, 4)
+	c.Assert(rating(tb.short(0x4749, 99)), qt.Equals, 5)
+	c.Assert(rating(tb.short(0x4749, 250)), qt.Equals, 5)
+
+	_, ok := decode(tb.ascii(0x010f, "Canon")).Rating()
+	c.Assert(ok, qt.IsFalse)
+
+	// From XMP.
+	tags = extractTags(t, "sunrise.jpg", imagemeta.XMP)
+	stars, ok := tags.Rating()
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(stars, qt.Equals, 4)
+}
+
+func TestDecodeXMPCameraRawTypes(t *testing.T) {
+	c := qt.New(t)
+
+	b := readTestDataFileAll(c, "sunrise.jpg")
+
+	tags, _ := decodeBytes(c, b, imagemeta.JPEG, imagemeta.Options{Sources: imagemeta.XMP})
+	xmp := tags.XMP()
+	c.Assert(xmp["Exposure2012"].Value, qt.Equals, "0.00")
+	c.Assert(xmp["AlreadyApplied"].Value, qt.Equals, "True")
+
+	tags, warnings := decodeBytes(c, b, imagemeta.JPEG, imagemeta.Options{Sources: imagemeta.XMP, XMPCameraRawTypes: true})
+	c.Assert(warnings, qt.HasLen, 0)
+	xmp = tags.XMP()
+	c.Assert(xmp["Exposure2012"].Value, qt.Equals, 0.0)
+	c.Assert(xmp["SharpenRadius"].Value, qt.Equals, 1.0)
+	c.Assert(xmp["BlueSaturation"].Value, qt.Equals, 100)
+	c.Assert(xmp["GrayMixerAqua"].Value, qt.Equals, -21)
+	c.Assert(xmp["AlreadyApplied"].Value, qt.Equals, true)
+	c.Assert(xmp["HasCrop"].Value, qt.Equals, false)
+	// Not known to be numeric.
+	c.Assert(xmp["ProcessVersion"].Value, qt.Equals, "11.0")
+	// Not in the crs namespace.
+	c.Assert(xmp["Rating"].Value, qt.Equals, "4")
+}
+
+func TestDecodeKeepRawXMP(t *testing.T) {
+	c := qt.New(t)
+
+	b := readTestDataFileAll(c, "sunrise.jpg")
+
+	var tags imagemeta.Tags
+	opts := imagemeta.Options{
+		R:           bytes.NewReader(b),
+		ImageFormat: imagemeta.JPEG,
+		Sources:     imagemeta.XMP,
+		HandleTag: func(ti imagemeta.TagInfo) error {
+			tags.Add(ti)
+			return nil
+		},
+	}
+
+	result, err := imagemeta.DecodeWithResult(opts)
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.RawXMP, qt.IsNil)
+
+	opts.R = bytes.NewReader(b)
+	opts.KeepRawXMP = true
+	result, err = imagemeta.DecodeWithResult(opts)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(result.RawXMP), qt.Contains, "<x:xmpmeta")
+	c.Assert(string(result.RawXMP), qt.Contains, `xmp:Rating="4"`)
+	c.Assert(tags.XMP()["Rating"].Value, qt.Equals, "4")
+
+	// HandleXMP gets the same packet.
+	var handled []byte
+	opts.R = bytes.NewReader(b)
+	opts.HandleXMP = func(r io.Reader) error {
+		handled, err = io.ReadAll(r)
+		return err
+	}
+	result, err = imagemeta.DecodeWithResult(opts)
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.RawXMP, qt.DeepEquals, handled)
+}
+
+func TestDecodeXMPNamespacePrefixes(t *testing.T) {
+	c := qt.New(t)
+
+	b, err := os.ReadFile(filepath.Join("testdata", "images", "sunrise.jpg"))
+	c.Assert(err, qt.IsNil)
+
+	tags, _ := decodeBytes(c, b, imagemeta.JPEG, imagemeta.Options{Sources: imagemeta.XMP})
+	c.Assert(tags.XMP()["AlreadyApplied"].Namespace, qt.Equals, "http://ns.adobe.com/camera-raw-settings/1.0/")
+
+	tags, _ = decodeBytes(c, b, imagemeta.JPEG, imagemeta.Options{Sources: imagemeta.XMP, XMPNamespacePrefixes: true})
+	xmp := tags.XMP()
+	c.Assert(xmp["AlreadyApplied"].Namespace, qt.Equals, "crs")
+	c.Assert(xmp["CreatorTool"].Namespace, qt.Equals, "xmp")
+
+	xmpPacket := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description xmlns:foo="http://example.com/foo/1.0/" foo:Bar="baz"/></rdf:RDF></x:xmpmeta>`
+	tags, _ = decodeBytes(c, jpegFile(jpegXMPSegment(xmpPacket)), imagemeta.JPEG, imagemeta.Options{Sources: imagemeta.XMP, XMPNamespacePrefixes: true})
+	c.Assert(tags.XMP()["Bar"].Namespace, qt.Equals, "http://example.com/foo/1.0/")
+}
+
+func TestDecodeXMPQualifiedNames(t *testing.T) {
+	c := qt.New(t)
+
+	xmpPacket := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description
+	xmlns:exif="http://ns.adobe.com/exif/1.0/"
+	xmlns:tiff="http://ns.adobe.com/tiff/1.0/"
+	xmlns:foo="http://example.com/foo/1.0/"
+	exif:DateTimeOriginal="2024-01-02T10:00:00"
+	tiff:DateTimeOriginal="2023-01-02T10:00:00"
+	foo:DateTimeOriginal="2022-01-02T10:00:00"/></rdf:RDF></x:xmpmeta>`
+	b := jpegFile(jpegXMPSegment(xmpPacket))
+
+	tags, _ := decodeBytes(c, b, imagemeta.JPEG, imagemeta.Options{Sources: imagemeta.XMP})
+	c.Assert(tags.XMP(), qt.HasLen, 1)
+
+	tags, _ = decodeBytes(c, b, imagemeta.JPEG, imagemeta.Options{Sources: imagemeta.XMP, XMPQualifiedNames: true})
+	xmp := tags.XMP()
+	c.Assert(xmp, qt.HasLen, 3)
+	c.Assert(xmp["exif:DateTimeOriginal"].Value, qt.Equals, "2024-01-02T10:00:00")
+	c.Assert(xmp["tiff:DateTimeOriginal"].Value, qt.Equals, "2023-01-02T10:00:00")
+	c.Assert(xmp["foo:DateTimeOriginal"].Value, qt.Equals, "2022-01-02T10:00:00")
+}
+
+func TestDecodeIFDPointersNotPassedToHandleTag(t *testing.T) {
+	c := qt.New(t)
+
+	// ExifOffset, GPSInfo, InteropOffset and SubIFDs.
+	pointers := map[uint16]bool{
+		0x8769: true,
+		0x8825: true,
+		0xa005: true,
+		0x014a: true,
+	}
+
+	assertNoPointers := func(c *qt.C, tags imagemeta.Tags) {
+		c.Helper()
+		c.Assert(len(tags.EXIF()), qt.Not(qt.Equals), 0)
+		for name, ti := range tags.EXIF() {
+			c.Assert(pointers[ti.ID], qt.IsFalse, qt.Commentf("IFD pointer %q passed to HandleTag", name))
+		}
+	}
+
+	for _, filename := range []string{"sunrise.jpg", "sunrise.tif", "goexif/has-lens-info.jpg", "metadata_demo_exif_only.jpg"} {
+		c.Run(filename, func(c *qt.C) {
+			assertNoPointers(c, extractTagsWithFilter(t, filename, imagemeta.EXIF, func(ti imagemeta.TagInfo) bool { return true }))
+		})
+	}
+
+	c.Run("SubIFDs", func(c *qt.C) {
+		tb := newTIFFBuilder()
+		tiff := tb.build([]tiffEntry{
+			tb.short(0x0112, 1),
+			tb.sub(0x014a, tb.short(0x0112, 3)),
+		})
+		for _, sources := range []imagemeta.Source{imagemeta.EXIF, imagemeta.EXIF | imagemeta.IPTC | imagemeta.XMP} {
+			tags, warnings := decodeBytes(c, tiff, imagemeta.TIFF, imagemeta.Options{
+				Sources:         sources,
+				ShouldHandleTag: func(ti imagemeta.TagInfo) bool { return true },
+			})
+			c.Assert(warnings, qt.HasLen, 0)
+			c.Assert(tags.EXIF()["Orientation"].Value, qt.Equals, uint16(1))
+			assertNoPointers(c, tags)
+		}
+	})
+}
+
+func TestDecodeDNGPreviewImageStart(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newTIFFBuilder()
+	ifd0 := func(subfileType uint32, compression uint16) []byte {
+		return tb.build([]tiffEntry{
+			tb.long(0x00fe, subfileType),
+			tb.short(0x0103, compression),
+			tb.long(0x0111, 1234),
+			tb.long(0x0117, 5678),
+			tb.bytes(0xc612, tiffTypeByte, []byte{1, 4, 0, 0}),
+		})
+	}
+
+	// IFD0 with a JPEG preview.
+	tags, _ := decodeBytes(c, ifd0(1, 7), imagemeta.TIFF, imagemeta.Options{Sources: imagemeta.EXIF})
+	exif := tags.EXIF()
+	c.Assert(exif["PreviewImageStart"].Value, eq, uint32(1234))
+	c.Assert(exif["PreviewImageLength"].Value, eq, uint32(5678))
+	c.Assert(exif["StripOffsets"].Value, qt.IsNil)
+
+	// The filter should not affect the naming.
+	tags, _ = decodeBytes(c, ifd0(1, 7), imagemeta.TIFF, imagemeta.Options{
+		Sources: imagemeta.EXIF,
+		ShouldHandleTag: func(ti imagemeta.TagInfo) bool {
+			return ti.Tag != "SubfileType" && ti.Tag != "Compression"
+		},
+	})
+	c.Assert(tags.EXIF()["PreviewImageStart"].Value, eq, uint32(1234))
+	c.Assert(tags.EXIF()["SubfileType"].Value, qt.IsNil)
+
+	// The main (uncompressed) image.
+	tags, _ = decodeBytes(c, ifd0(0, 1), imagemeta.TIFF, imagemeta.Options{Sources: imagemeta.EXIF})
+	exif = tags.EXIF()
+	c.Assert(exif["StripOffsets"].Value, eq, "1234")
+	c.Assert(exif["StripByteCounts"].Value, eq, "5678")
+	c.Assert(exif["PreviewImageStart"].Value, qt.IsNil)
+}
+
+func TestDecodeHandlePreviewImage(t *testing.T) {
+	c := qt.New(t)
+
+	preview, err := os.ReadFile(filepath.Join("testdata", "images", "sunrise.jpg"))
+	c.Assert(err, qt.IsNil)
+
+	tb := newTIFFBuilder()
+	build := func(offset uint32) []byte {
+		return tb.build([]tiffEntry{
+			tb.long(0x00fe, 1),
+			tb.short(0x0103, 7),
+			tb.long(0x0111, offset),
+			tb.long(0x0117, uint32(len(preview))),
+			tb.bytes(0xc612, tiffTypeByte, []byte{1, 4, 0, 0}),
+		})
+	}
+	tiff := build(0)
+	tiff = append(build(uint32(len(tiff))), preview...)
+	tiff = append(tiff, "trailing data"...)
+
+	var got []byte
+	_, _ = decodeBytes(c, tiff, imagemeta.TIFF, imagemeta.Options{
+		Sources: imagemeta.EXIF,
+		HandlePreviewImage: func(r io.Reader) error {
+			var err error
+			got, err = io.ReadAll(r)
+			return err
+		},
+	})
+
+	c.Assert(bytes.Equal(got, preview), qt.IsTrue)
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(got))
+	c.Assert(err, qt.IsNil)
+	c.Assert(cfg.Width, qt.Equals, 1024)
+
+	// No preview.
+	got = nil
+	_, _ = decodeBytes(c, tb.build([]tiffEntry{tb.long(0x0111, 8)}), imagemeta.TIFF, imagemeta.Options{
+		HandlePreviewImage: func(r io.Reader) error {
+			got = []byte("called")
+			return nil
+		},
+	})
+	c.Assert(got, qt.IsNil)
+}
+
+func TestDecodeHandlePreviewImageCanonMakerNote(t *testing.T) {
+	c := qt.New(t)
+
+	// Small enough to fit in the APP1 segment.
+	preview := jpegFile(jpegSegment(0xffe0, []byte("JFIF\x00")))
+
+	// A Canon MakerNote with PreviewImageInfo, stored after the IFD.
+	// The preview start is relative to the TIFF header.
+	makerNote := func(valueOffset, previewStart uint32) []byte {
+		b := appendUint16(binary.BigEndian, nil, 1)
+		b = appendUint16(binary.BigEndian, b, 0x00b6)
+		b = appendUint16(binary.BigEndian, b, tiffTypeLong)
+		b = appendUint32(binary.BigEndian, b, 7)
+		b = appendUint32(binary.BigEndian, b, valueOffset)
+		b = appendUint32(binary.BigEndian, b, 0)
+		for _, v := range []uint32{28, 2, uint32(len(preview)), 160, 120, previewStart, 0} {
+			b = appendUint32(binary.BigEndian, b, v)
+		}
+		return b
+	}
+	tb := newTIFFBuilder()
+	build := func(valueOffset, previewStart uint32) []byte {
+		return tb.build([]tiffEntry{
+			tb.ascii(0x010f, "Canon"),
+			tb.sub(0x8769,
+				tb.bytes(0x927c, tiffTypeUndef, makerNote(valueOffset, previewStart)),
+			),
+		})
+	}
+	tiff := build(0, 0)
+	makerNoteStart := bytes.Index(tiff, makerNote(0, 0))
+	c.Assert(makerNoteStart, qt.Not(qt.Equals), -1)
+	tiff = append(build(uint32(makerNoteStart+18), uint32(len(tiff))), preview...)
+
+	decode := func(b []byte, imageFormat imagemeta.ImageFormat, decodeMakerNotes bool) []byte {
+		var got []byte
+		_, warnings := decodeBytes(c, b, imageFormat, imagemeta.Options{
+			Sources:          imagemeta.EXIF,
+			DecodeMakerNotes: decodeMakerNotes,
+			HandlePreviewImage: func(r io.Reader) error {
+				var err error
+				got, err = io.ReadAll(r)
+				return err
+			},
+		})
+		c.Assert(warnings, qt.HasLen, 0)
+		return got
+	}
+
+	for _, test := range []struct {
+		name        string
+		b           []byte
+		imageFormat imagemeta.ImageFormat
+	}{
+		{"JPEG", jpegFile(jpegEXIFSegment(tiff)), imagemeta.JPEG},
+		{"TIFF", tiff, imagemeta.TIFF},
+	} {
+		c.Run(test.name, func(c *qt.C) {
+			got := decode(test.b, test.imageFormat, true)
+			c.Assert(bytes.HasPrefix(got, []byte{0xff, 0xd8}), qt.IsTrue)
+			c.Assert(bytes.Equal(got, preview), qt.IsTrue)
+
+			c.Assert(decode(test.b, test.imageFormat, false), qt.IsNil)
+		})
+	}
+}
+
+func TestDecodeWebPWithoutVP8XFlags(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newTIFFBuilder()
+	tiff := tb.build([]tiffEntry{tb.ascii(0x8298, "Copyright Holder")})
+	xmpPacket := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmp:CreatorTool="Test"/></rdf:RDF></x:xmpmeta>`
+
+	for _, chunks := range [][][]byte{
+		// VP8X with cleared flags.
+		{webpVP8XChunk(0), webpChunk("VP8 ", make([]byte, 10)), webpChunk("EXIF", tiff), webpChunk("XMP ", []byte(xmpPacket))},
+		// No VP8X.
+		{webpChunk("VP8 ", make([]byte, 10)), webpChunk("EXIF", tiff), webpChunk("XMP ", []byte(xmpPacket))},
+		// EXIF and XMP before VP8X, which has cleared flags.
+		{webpChunk("EXIF", tiff), webpChunk("XMP ", []byte(xmpPacket)), webpVP8XChunk(0), webpChunk("VP8 ", make([]byte, 10))},
+		// EXIF with an odd size (padded) before VP8X.
+		{webpChunk("EXIF", append(tiff, 0)), webpVP8XChunk(0), webpChunk("XMP ", []byte(xmpPacket)), webpChunk("VP8 ", make([]byte, 10))},
+	} {
+		tags, _ := decodeBytes(c, webpFile(chunks...), imagemeta.WebP, imagemeta.Options{})
+		c.Assert(tags.EXIF()["Copyright"].Value, qt.Equals, "Copyright Holder")
+		c.Assert(tags.XMP()["CreatorTool"].Value, qt.Equals, "Test")
+	}
+}
+
+func TestDecodeWebPEXIFWithHeader(t *testing.T) {
+	c := qt.New(t)
+
+	// Rewrite sunrise.webp with the JPEG APP1 EXIF header prepended to the EXIF chunk data.
+	b := readTestDataFileAll(t, "sunrise.webp")
+	var chunks [][]byte
+	for pos := 12; pos+8 <= len(b); {
+		id := string(b[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(b[pos+4:]))
+		data := b[pos+8 : pos+8+size]
+		if id == "EXIF" {
+			data = append([]byte("Exif\x00\x00"), data...)
+		}
+		chunks = append(chunks, webpChunk(id, data))
+		pos += 8 + size + size&1
+	}
+
+	opts := imagemeta.Options{ShouldHandleTag: func(imagemeta.TagInfo) bool { return true }}
+	want, warnings := decodeBytes(c, b, imagemeta.WebP, opts)
+	c.Assert(warnings, qt.HasLen, 0)
+	got, warnings := decodeBytes(c, webpFile(chunks...), imagemeta.WebP, opts)
+	c.Assert(warnings, qt.HasLen, 0)
+
+	wantEXIF, gotEXIF := want.EXIF(), got.EXIF()
+	c.Assert(len(wantEXIF) > 10, qt.IsTrue)
+	c.Assert(gotEXIF, qt.HasLen, len(wantEXIF))
+	for k, v := range wantEXIF {
+		if k == "ThumbnailOffset" {
+			// The offset is relative to the start of the file.
+			c.Assert(gotEXIF[k].Value, eq, v.Value.(uint32)+6)
+			continue
+		}
+		c.Assert(gotEXIF[k], eq, v, qt.Commentf("%s", k))
+	}
+}
+
+func TestDecodeMultipleXMPBlocks(t *testing.T) {
+	c := qt.New(t)
+
+	xmpPacket := func(attrs string) string {
+		return `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description xmlns:xmp="http://ns.adobe.com/xap/1.0/" ` + attrs + `/></rdf:RDF></x:xmpmeta>`
+	}
+	first := xmpPacket(`xmp:CreatorTool="First" xmp:Label="Red"`)
+	second := xmpPacket(`xmp:CreatorTool="Second" xmp:Rating="5"`)
+
+	tb := newTIFFBuilder()
+	tiff := tb.build([]tiffEntry{tb.ascii(0x8298, "Copyright Holder")})
+
+	// XMP before EXIF in the same APP1 marker type.
+	jpg := jpegFile(jpegXMPSegment(first), jpegEXIFSegment(tiff), jpegXMPSegment(second))
+	tags, _ := decodeBytes(c, jpg, imagemeta.JPEG, imagemeta.Options{})
+	xmp := tags.XMP()
+	c.Assert(xmp["CreatorTool"].Value, qt.Equals, "Second")
+	c.Assert(xmp["Label"].Value, qt.Equals, "Red")
+	c.Assert(xmp["Rating"].Value, qt.Equals, "5")
+	c.Assert(tags.EXIF()["Copyright"].Value, qt.Equals, "Copyright Holder")
+
+	itxt := func(xmp string) []byte {
+		return pngChunk("iTXt", append([]byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), xmp...))
+	}
+	png := pngFile(itxt(first), pngChunk("eXIf", tiff), itxt(second))
+	tags, _ = decodeBytes(c, png, imagemeta.PNG, imagemeta.Options{})
+	xmp = tags.XMP()
+	c.Assert(xmp["CreatorTool"].Value, qt.Equals, "Second")
+	c.Assert(xmp["Label"].Value, qt.Equals, "Red")
+	c.Assert(xmp["Rating"].Value, qt.Equals, "5")
+	c.Assert(tags.EXIF()["Copyright"].Value, qt.Equals, "Copyright Holder")
+}
+
+func TestDecodePNGITXt(t *testing.T) {
+	c := qt.New(t)
+
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	w.Write([]byte("Et blåbær i solnedgang"))
+	w.Close()
+
+	png := pngFile(
+		pngChunk("iTXt", []byte("Title\x00\x00\x00no\x00Tittel\x00Solnedgang i Spania")),
+		pngChunk("iTXt", append([]byte("Description\x00\x01\x00no\x00Beskrivelse\x00"), compressed.Bytes()...)),
+		pngChunk("iTXt", []byte("Creation Time\x00\x00\x00\x00\x002024-01-02T10:00:00")),
+	)
+
+	tags, _ := decodeBytes(c, png, imagemeta.PNG, imagemeta.Options{})
+	c.Assert(tags.XMP(), qt.HasLen, 0)
+	chunks := tags.PNGChunks()
+	c.Assert(chunks["Title"].Value, qt.Equals, "Solnedgang i Spania")
+	c.Assert(chunks["Title"].Namespace, qt.Equals, "PNG")
+	c.Assert(chunks["Description"].Value, qt.Equals, "Et blåbær i solnedgang")
+	c.Assert(chunks["CreationTime"].Value, qt.Equals, "2024-01-02T10:00:00")
+
+	tags, _ = decodeBytes(c, png, imagemeta.PNG, imagemeta.Options{Sources: imagemeta.XMP})
+	c.Assert(tags.PNGChunks(), qt.HasLen, 0)
+	tags, _ = decodeBytes(c, png, imagemeta.PNG, imagemeta.Options{Sources: imagemeta.PNGChunks})
+	c.Assert(tags.PNGChunks(), qt.HasLen, 3)
+}
+
+func TestDecodePNGText(t *testing.T) {
+	c := qt.New(t)
+
+	png := pngFile(
+		pngChunk("tEXt", []byte("Author\x00Bj\xf8rn Erik Pedersen")),
+		pngChunk("tEXt", []byte("Description\x00Sunrise in Spain")),
+	)
+
+	tags, _ := decodeBytes(c, png, imagemeta.PNG, imagemeta.Options{})
+	chunks := tags.PNGChunks()
+	c.Assert(chunks["Author"].Value, qt.Equals, "Bjørn Erik Pedersen")
+	c.Assert(chunks["Author"].Namespace, qt.Equals, "PNG")
+	c.Assert(chunks["Description"].Value, qt.Equals, "Sunrise in Spain")
+
+	tags, _ = decodeBytes(c, png, imagemeta.PNG, imagemeta.Options{Sources: imagemeta.XMP})
+	c.Assert(tags.PNGChunks(), qt.HasLen, 0)
+}
+
+func TestDecodePNGColorSpace(t *testing.T) {
+	c := qt.New(t)
+
+	b := readTestDataFileAll(c, "metadata-extractor-images/png/issue614.png")
+
+	var profile []byte
+	tags, warnings := decodeBytes(c, b, imagemeta.PNG, imagemeta.Options{
+		HandleICCProfile: func(r io.Reader) error {
+			var err error
+			profile, err = io.ReadAll(r)
+			return err
+		},
+	})
+	c.Assert(warnings, qt.HasLen, 0)
+	c.Assert(tags.PNGChunks()["ProfileName"].Value, qt.Equals, "icc")
+	c.Assert(tags.PNGChunks()["ProfileName"].Namespace, qt.Equals, "PNG")
+	c.Assert(len(profile) > 128, qt.IsTrue)
+	// The ICC profile signature.
+	c.Assert(string(profile[36:40]), qt.Equals, "acsp")
+
+	// Only the ICC profile.
+	profile = nil
+	tags, _ = decodeBytes(c, b, imagemeta.PNG, imagemeta.Options{
+		Sources: imagemeta.EXIF,
+		HandleICCProfile: func(r io.Reader) error {
+			var err error
+			profile, err = io.ReadAll(r)
+			return err
+		},
+	})
+	c.Assert(tags.PNGChunks(), qt.HasLen, 0)
+	c.Assert(len(profile) > 128, qt.IsTrue)
+
+	// BT.2100 PQ, full range.
+	png := pngFile(pngChunk("cICP", []byte{9, 16, 0, 1}))
+	tags, _ = decodeBytes(c, png, imagemeta.PNG, imagemeta.Options{})
+	chunks := tags.PNGChunks()
+	c.Assert(chunks["ColorPrimaries"].Value, eq, uint8(9))
+	c.Assert(chunks["TransferCharacteristics"].Value, eq, uint8(16))
+	c.Assert(chunks["MatrixCoefficients"].Value, eq, uint8(0))
+	c.Assert(chunks["VideoFullRangeFlag"].Value, eq, uint8(1))
+	c.Assert(chunks["ColorPrimaries"].Namespace, qt.Equals, "PNG")
+
+	tags, _ = decodeBytes(c, png, imagemeta.PNG, imagemeta.Options{Sources: imagemeta.XMP})
+	c.Assert(tags.PNGChunks(), qt.HasLen, 0)
+}
+
+func TestDecodePNGLimits(t *testing.T) {
+	c := qt.New(t)
+
+	// A chunk claiming to be much larger than the file.
+	png := pngFile(pngChunk("tEXt", []byte("Author\x00Foo")))
+	binary.BigEndian.PutUint32(png[33:], 0xffffffff)
+	c.Assert(string(png[37:41]), qt.Equals, "tEXt")
+	err := imagemeta.Decode(imagemeta.Options{R: bytes.NewReader(png), ImageFormat: imagemeta.PNG})
+	c.Assert(imagemeta.IsInvalidFormat(err), qt.IsTrue, qt.Commentf("%v", err))
+	c.Assert(imagemeta.IsTruncated(err), qt.IsTrue)
+
+	// A small compressed chunk that inflates to more than the limit.
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	w.Write(make([]byte, 17<<20))
+	w.Close()
+	c.Assert(compressed.Len() < 1<<20, qt.IsTrue)
+
+	for _, chunk := range [][]byte{
+		pngChunk("iTXt", append([]byte("Description\x00\x01\x00\x00\x00"), compressed.Bytes()...)),
+		pngChunk("zTXt", append([]byte("Raw profile type iptc\x00\x00"), compressed.Bytes()...)),
+	} {
+		err := imagemeta.Decode(imagemeta.Options{R: bytes.NewReader(pngFile(chunk)), ImageFormat: imagemeta.PNG})
+		c.Assert(err, qt.ErrorMatches, ".*decompressed size exceeds.*")
+		c.Assert(imagemeta.IsInvalidFormat(err), qt.IsTrue)
+	}
+
+	err = imagemeta.Decode(imagemeta.Options{
+		R:                bytes.NewReader(pngFile(pngChunk("iCCP", append([]byte("icc\x00\x00"), compressed.Bytes()...)))),
+		ImageFormat:      imagemeta.PNG,
+		HandleICCProfile: func(r io.Reader) error { return nil },
+	})
+	c.Assert(err, qt.ErrorMatches, ".*decompressed size exceeds.*")
+}
+
+func TestGoldenPNGText(t *testing.T) {
+	for _, filename := range []string{
+		"sunrise.png",
+		"metadata-extractor-images/png/issue614.png",
+		"metadata-extractor-images/png/Issue 62.png",
+		"metadata-extractor-images/png/Issue 204 (dotnet).png",
+	} {
+		t.Run(filename, func(t *testing.T) {
+			c := qt.New(t)
+			tags := extractTags(t, filename, imagemeta.PNGChunks)
+			golden := readGoldenInfo(t, filename)
+			var count int
+			for _, ti := range tags.PNGChunks() {
+				count++
+				expect, found := golden.PNG[ti.Tag]
+				c.Assert(found, qt.IsTrue, qt.Commentf("%s not found in golden", ti.Tag))
+				c.Assert(ti.Value, qt.Equals, fmt.Sprintf("%v", expect), qt.Commentf(ti.Tag))
+			}
+			c.Assert(count > 0, qt.IsTrue)
+		})
+	}
+}
+
+func TestDecodeTags(t *testing.T) {
+	c := qt.New(t)
+
+	f, err := os.Open(filepath.Join("testdata", "images", "sunrise.jpg"))
+	c.Assert(err, qt.IsNil)
+	defer f.Close()
+
+	tags, _, err := imagemeta.DecodeTags(imagemeta.Options{
+		R:           f,
+		ImageFormat: imagemeta.JPEG,
+		ShouldHandleTag: func(ti imagemeta.TagInfo) bool {
+			return true
+		},
+		HandleTag: func(ti imagemeta.TagInfo) error {
+			return errors.New("should be replaced")
+		},
+		Warnf: panicWarnf,
+	})
+	c.Assert(err, qt.IsNil)
+
+	c.Assert(tags.EXIF()["Copyright"].Value, qt.Equals, "Bjørn Erik Pedersen")
+	c.Assert(tags.EXIF()["ApertureValue"].Value, eq, 5.6)
+	c.Assert(tags.EXIF()["ThumbnailOffset"].Value, eq, uint32(1338))
+	c.Assert(tags.XMP()["CreatorTool"].Value, qt.Equals, "Adobe Photoshop Lightroom Classic 12.4 (Macintosh)")
+	c.Assert(tags.IPTC()["City"].Value, qt.Equals, "Benalmádena")
+}
+
+func TestDecodeMap(t *testing.T) {
+	c := qt.New(t)
+
+	f, err := os.Open(filepath.Join("testdata", "images", "sunrise.jpg"))
+	c.Assert(err, qt.IsNil)
+	defer f.Close()
+
+	m, err := imagemeta.DecodeMap(imagemeta.Options{
+		R:           f,
+		ImageFormat: imagemeta.JPEG,
+		HandleTag: func(ti imagemeta.TagInfo) error {
+			return errors.New("should be replaced")
+		},
+		Warnf: panicWarnf,
+	})
+	c.Assert(err, qt.IsNil)
+
+	c.Assert(m["Copyright"].Value, qt.Equals, "Bjørn Erik Pedersen")
+	c.Assert(m["Copyright"].Source, qt.Equals, imagemeta.EXIF)
+	c.Assert(m["CreatorTool"].Value, qt.Equals, "Adobe Photoshop Lightroom Classic 12.4 (Macintosh)")
+	c.Assert(m["City"].Value, qt.Equals, "Benalmádena")
+
+	_, err = imagemeta.DecodeMap(imagemeta.Options{ImageFormat: imagemeta.JPEG})
+	c.Assert(err, qt.ErrorMatches, "no reader provided")
+}
+
+func TestGetGPSMapDatum(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newTIFFBuilder()
+	tiff := func(datum string) []byte {
+		return tb.build([]tiffEntry{
+			tb.sub(0x8825,
+				tb.ascii(0x0001, "N"),
+				tb.rational(0x0002, 35, 1, 40, 1, 0, 1),
+				tb.ascii(0x0003, "E"),
+				tb.rational(0x0004, 139, 1, 45, 1, 0, 1),
+				tb.ascii(0x0012, datum),
+			),
+		})
+	}
+
+	tags, warnings := decodeBytes(c, jpegFile(jpegEXIFSegment(tiff("TOKYO"))), imagemeta.JPEG, imagemeta.Options{})
+	c.Assert(warnings, qt.DeepEquals, []string{`GPSMapDatum: GPS coordinates use the "TOKYO" datum, not WGS-84`})
+	datum, isWGS84 := tags.GetGPSMapDatum()
+	c.Assert(datum, qt.Equals, "TOKYO")
+	c.Assert(isWGS84, qt.IsFalse)
+	lat, long, err := tags.GetLatLong()
+	c.Assert(err, qt.IsNil)
+	c.Assert(lat, eq, 35.666666666666664)
+	c.Assert(long, eq, 139.75)
+
+	tags, warnings = decodeBytes(c, jpegFile(jpegEXIFSegment(tiff("WGS-84   "))), imagemeta.JPEG, imagemeta.Options{})
+	c.Assert(warnings, qt.HasLen, 0)
+	datum, isWGS84 = tags.GetGPSMapDatum()
+	c.Assert(datum, qt.Equals, "WGS-84")
+	c.Assert(isWGS84, qt.IsTrue)
+}
+
+func TestGetDateTimeTimeZoneOffset(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newTIFFBuilder()
+	decode := func(entries ...tiffEntry) time.Time {
+		tiff := tb.build([]tiffEntry{
+			tb.sub(0x8769, append(entries, tb.ascii(0x9003, "2024:01:02 10:00:00"))...),
+		})
+		tags, _ := decodeBytes(c, jpegFile(jpegEXIFSegment(tiff)), imagemeta.JPEG, imagemeta.Options{})
+		d, err := tags.GetDateTime()
+		c.Assert(err, qt.IsNil)
+		return d
+	}
+
+	timeZoneOffset := func(vals ...uint16) tiffEntry {
+		e := tb.short(0x882a, vals...)
+		e.typ = tiffTypeSShort
+		return e
+	}
+
+	c.Assert(decode(timeZoneOffset(2)).UTC(), qt.Equals, time.Date(2024, 1, 2, 8, 0, 0, 0, time.UTC))
+	c.Assert(decode(timeZoneOffset(0xfffb, 0xfffb)).UTC(), qt.Equals, time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC))
+	// OffsetTimeOriginal takes precedence.
+	c.Assert(decode(timeZoneOffset(2), tb.ascii(0x9011, "+05:30")).UTC(), qt.Equals, time.Date(2024, 1, 2, 4, 30, 0, 0, time.UTC))
+}
+
+func TestGetDateTimeCreateDate(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newTIFFBuilder()
+	decode := func(ifd0 []tiffEntry, exifIFD ...tiffEntry) time.Time {
+		tiff := tb.build(append(ifd0, tb.sub(0x8769, exifIFD...)))
+		tags, _ := decodeBytes(c, jpegFile(jpegEXIFSegment(tiff)), imagemeta.JPEG, imagemeta.Options{})
+		d, err := tags.GetDateTime()
+		c.Assert(err, qt.IsNil)
+		return d.UTC()
+	}
+
+	offset := tb.ascii(0x9010, "+00:00")
+	createDate := tb.ascii(0x9004, "2024:01:02 10:00:00")
+	modifyDate := tb.ascii(0x0132, "2024:03:04 10:00:00")
+
+	c.Assert(decode([]tiffEntry{modifyDate}, offset), qt.Equals, time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC))
+	c.Assert(decode(nil, offset, createDate), qt.Equals, time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC))
+	// CreateDate takes precedence over ModifyDate.
+	c.Assert(decode([]tiffEntry{modifyDate}, offset, createDate), qt.Equals, time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC))
+	// DateTimeOriginal takes precedence over both.
+	c.Assert(decode([]tiffEntry{modifyDate}, offset, createDate, tb.ascii(0x9003, "2024:05:06 10:00:00")), qt.Equals, time.Date(2024, 5, 6, 10, 0, 0, 0, time.UTC))
+}
+
+func TestGetDateTimeSubSecTime(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newTIFFBuilder()
+	decode := func(ifd0 []tiffEntry, exifIFD ...tiffEntry) time.Time {
+		tiff := tb.build(append(ifd0, tb.sub(0x8769, append(exifIFD, tb.ascii(0x9010, "+00:00"))...)))
+		tags, _ := decodeBytes(c, jpegFile(jpegEXIFSegment(tiff)), imagemeta.JPEG, imagemeta.Options{})
+		d, err := tags.GetDateTime()
+		c.Assert(err, qt.IsNil)
+		return d.UTC()
+	}
+
+	dateTimeOriginal := tb.ascii(0x9003, "2024:01:02 10:00:00")
+	createDate := tb.ascii(0x9004, "2024:01:02 10:00:00")
+	modifyDate := tb.ascii(0x0132, "2024:01:02 10:00:00")
+	ms := func(n int) time.Time {
+		return time.Date(2024, 1, 2, 10, 0, 0, n*int(time.Millisecond), time.UTC)
+	}
+
+	c.Assert(decode(nil, dateTimeOriginal, tb.ascii(0x9291, "123")), qt.Equals, ms(123))
+	c.Assert(decode(nil, dateTimeOriginal, tb.ascii(0x9291, "5")), qt.Equals, ms(500))
+	c.Assert(decode(nil, createDate, tb.ascii(0x9292, "25")), qt.Equals, ms(250))
+	c.Assert(decode([]tiffEntry{modifyDate}, tb.ascii(0x9290, "999")), qt.Equals, ms(999))
+	// The SubSecTime values are ints, so the leading zeros are lost.
+	c.Assert(decode(nil, dateTimeOriginal, tb.ascii(0x9291, "07")), qt.Equals, ms(700))
+	// Only the SubSecTime tag matching the date tag used is applied.
+	c.Assert(decode(nil, dateTimeOriginal, tb.ascii(0x9290, "5"), tb.ascii(0x9292, "5")), qt.Equals, ms(0))
+
+	tiff := tb.build([]tiffEntry{tb.sub(0x8769, dateTimeOriginal, tb.ascii(0x9291, "07"))})
+	tags, _ := decodeBytes(c, jpegFile(jpegEXIFSegment(tiff)), imagemeta.JPEG, imagemeta.Options{})
+	c.Assert(tags.EXIF()["SubSecTimeOriginal"].Value, qt.Equals, 7)
+}
+
+func TestParseSources(t *testing.T) {
+	c := qt.New(t)
+
+	for _, source := range []imagemeta.Source{imagemeta.EXIF, imagemeta.IPTC, imagemeta.XMP, imagemeta.PNGChunks} {
+		got, err := imagemeta.ParseSources(source.String())
+		c.Assert(err, qt.IsNil)
+		c.Assert(got, qt.Equals, source)
+	}
+
+	got, err := imagemeta.ParseSources("exif, Iptc,XMP")
+	c.Assert(err, qt.IsNil)
+	c.Assert(got, qt.Equals, imagemeta.EXIF|imagemeta.IPTC|imagemeta.XMP)
+
+	got, err = imagemeta.ParseSources("")
+	c.Assert(err, qt.IsNil)
+	c.Assert(got.IsZero(), qt.IsTrue)
+
+	_, err = imagemeta.ParseSources("EXIF,FOO")
+	c.Assert(err, qt.ErrorMatches, `unknown source "FOO"`)
+}
+
+func TestDecodeVersionTags(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newTIFFBuilder()
+	decode := func(gpsVersionID []byte) imagemeta.Tags {
+		tiff := tb.build([]tiffEntry{
+			tb.sub(0x8769,
+				tb.bytes(0x9000, tiffTypeUndef, []byte("0232")),
+				tb.bytes(0xa000, tiffTypeUndef, []byte("0100")),
+			),
+			tb.sub(0x8825, tb.bytes(0x0000, tiffTypeByte, gpsVersionID)),
+		})
+		tags, warnings := decodeBytes(c, jpegFile(jpegEXIFSegment(tiff)), imagemeta.JPEG, imagemeta.Options{})
+		c.Assert(warnings, qt.HasLen, 0)
+		return tags
+	}
+
+	tags := decode([]byte{2, 3, 0, 0})
+	exif := tags.EXIF()
+	c.Assert(exif["GPSVersionID"].Value, qt.Equals, "2.3.0.0")
+	c.Assert(exif["ExifVersion"].Value, qt.Equals, "0232")
+	c.Assert(exif["FlashpixVersion"].Value, qt.Equals, "0100")
+
+	tags = decode([]byte{2})
+	exif = tags.EXIF()
+	c.Assert(exif["GPSVersionID"].Value, qt.Equals, "2")
+}
+
+func TestDecodeNumbersToSpaceLimited(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newTIFFBuilder()
+	decode := func(entries ...tiffEntry) map[string]imagemeta.TagInfo {
+		tags, warnings := decodeBytes(c, jpegFile(jpegEXIFSegment(tb.build(entries))), imagemeta.JPEG, imagemeta.Options{})
+		c.Assert(warnings, qt.HasLen, 0)
+		return tags.EXIF()
+	}
+
+	c.Assert(decode(tb.short(0x0102, 16))["BitsPerSample"].Value, qt.Equals, "16")
+	c.Assert(decode(tb.short(0x0102, 8, 8, 8))["BitsPerSample"].Value, qt.Equals, "8 8 8")
+	c.Assert(decode(tb.bytes(0x0102, tiffTypeByte, []byte{8, 8, 8}))["BitsPerSample"].Value, qt.Equals, "8 8 8")
+	c.Assert(decode(tb.bytes(0x0102, tiffTypeByte, []byte{12}))["BitsPerSample"].Value, qt.Equals, "12")
+}
+
+func TestDecodeGPSLatitudeMalformed(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newTIFFBuilder()
+	decode := func(e tiffEntry) (float64, float64) {
+		tiff := tb.build([]tiffEntry{
+			tb.sub(0x8825,
+				tb.ascii(0x0001, "N"),
+				e,
+				tb.ascii(0x0003, "E"),
+				e.withTag(0x0004),
+			),
+		})
+		tags, warnings := decodeBytes(c, jpegFile(jpegEXIFSegment(tiff)), imagemeta.JPEG, imagemeta.Options{})
+		c.Assert(warnings, qt.HasLen, 0)
+		lat, long, err := tags.GetLatLong()
+		c.Assert(err, qt.IsNil)
+		return lat, long
+	}
+
+	// The Sony DSC-HX20V without a GPS fix writes "0100" as the GPSLatitude.
+	// We don't have that file, so rewrite the GPSLatitude and GPSLongitude in
+	// the real sunrise.jpg to ASCII "0100".
+	b := readTestDataFileAll(t, "sunrise.jpg")
+	tiff := b[bytes.Index(b, []byte("Exif\x00\x00"))+6:]
+	for _, tag := range []uint16{0x0002, 0x0004} {
+		e := tiffEntryOffset(tiff, 0x8825, tag)
+		c.Assert(e, qt.Not(qt.Equals), -1)
+		binary.LittleEndian.PutUint16(tiff[e+2:], tiffTypeASCII)
+		binary.LittleEndian.PutUint32(tiff[e+4:], 5)
+		copy(tiff[binary.LittleEndian.Uint32(tiff[e+8:]):], "0100\x00")
+	}
+	tags, warnings := decodeBytes(c, b, imagemeta.JPEG, imagemeta.Options{})
+	c.Assert(warnings, qt.HasLen, 0)
+	c.Assert(tags.EXIF()["GPSLatitude"].Value, qt.Equals, 0.0)
+	lat, long, err := tags.GetLatLong()
+	c.Assert(err, qt.IsNil)
+	c.Assert(lat, qt.Equals, 0.0)
+	c.Assert(long, qt.Equals, 0.0)
+
+	for _, e := range []tiffEntry{
+		tb.ascii(0x0002, "0100"),
+		tb.bytes(0x0002, tiffTypeUndef, []byte("0100\x00\x00")),
+		tb.bytes(0x0002, tiffTypeASCII, []byte(" 0100\x00")),
+		tb.ascii(0x0002, ""),
+	} {
+		lat, long := decode(e)
+		c.Assert(lat, qt.Equals, 0.0)
+		c.Assert(long, qt.Equals, 0.0)
+	}
+
+	// A single rational.
+	lat, long = decode(tb.rational(0x0002, 121, 2))
+	c.Assert(lat, qt.Equals, 60.5)
+	c.Assert(long, qt.Equals, 60.5)
+
+	// A single double.
+	lat, long = decode(tb.double(0x0002, 59.9133))
+	c.Assert(lat, qt.Equals, 59.9133)
+	c.Assert(long, qt.Equals, 59.9133)
+}
+
+func TestDecodeXMPRegions(t *testing.T) {
+	c := qt.New(t)
+
+	// Lightroom style.
+	xmpPacket := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description rdf:about=""
+	xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+	xmlns:mwg-rs="http://www.metadataworkinggroup.com/schemas/regions/"
+	xmlns:stDim="http://ns.adobe.com/xap/1.0/sType/Dimensions#"
+	xmlns:stArea="http://ns.adobe.com/xmp/sType/Area#"
+	xmp:CreatorTool="Adobe Photoshop Lightroom Classic 12.4 (Macintosh)">
+	<mwg-rs:Regions rdf:parseType="Resource">
+		<mwg-rs:AppliedToDimensions stDim:w="6000" stDim:h="4000" stDim:unit="pixel"/>
+		<mwg-rs:RegionList>
+			<rdf:Bag>
+				<rdf:li>
+					<rdf:Description mwg-rs:Name="Bjørn" mwg-rs:Type="Face">
+						<mwg-rs:Area stArea:x="0.5" stArea:y="0.4" stArea:w="0.1" stArea:h="0.15" stArea:unit="normalized"/>
+					</rdf:Description>
+				</rdf:li>
+				<rdf:li mwg-rs:Name="Rover" mwg-rs:Type="Pet">
+					<mwg-rs:Area stArea:x="0.2" stArea:y="0.7" stArea:w="0.3" stArea:h="0.25" stArea:unit="normalized"/>
+				</rdf:li>
+			</rdf:Bag>
+		</mwg-rs:RegionList>
+	</mwg-rs:Regions>
+</rdf:Description></rdf:RDF></x:xmpmeta>`
+
+	tags, _ := decodeBytes(c, jpegFile(jpegXMPSegment(xmpPacket)), imagemeta.JPEG, imagemeta.Options{Sources: imagemeta.XMP})
+	xmp := tags.XMP()
+	c.Assert(xmp["CreatorTool"].Value, qt.Equals, "Adobe Photoshop Lightroom Classic 12.4 (Macintosh)")
+	c.Assert(xmp["RegionList"].Value, qt.DeepEquals, []imagemeta.Region{
+		{Name: "Bjørn", Type: "Face", X: 0.5, Y: 0.4, W: 0.1, H: 0.15},
+		{Name: "Rover", Type: "Pet", X: 0.2, Y: 0.7, W: 0.3, H: 0.25},
+	})
+
+	// digiKam style.
+	xmpPacket = `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description rdf:about=""
+	xmlns:mwg-rs="http://www.metadataworkinggroup.com/schemas/regions/"
+	xmlns:stArea="http://ns.adobe.com/xmp/sType/Area#">
+	<mwg-rs:Regions rdf:parseType="Resource">
+		<mwg-rs:RegionList>
+			<rdf:Bag>
+				<rdf:li rdf:parseType="Resource">
+					<mwg-rs:Name>Anna</mwg-rs:Name>
+					<mwg-rs:Type>Face</mwg-rs:Type>
+					<mwg-rs:Area rdf:parseType="Resource">
+						<stArea:x>0.25</stArea:x>
+						<stArea:y>0.35</stArea:y>
+						<stArea:w>0.05</stArea:w>
+						<stArea:h>0.08</stArea:h>
+						<stArea:unit>normalized</stArea:unit>
+					</mwg-rs:Area>
+				</rdf:li>
+			</rdf:Bag>
+		</mwg-rs:RegionList>
+	</mwg-rs:Regions>
+</rdf:Description></rdf:RDF></x:xmpmeta>`
+
+	tags, _ = decodeBytes(c, jpegFile(jpegXMPSegment(xmpPacket)), imagemeta.JPEG, imagemeta.Options{Sources: imagemeta.XMP, XMPNamespacePrefixes: true})
+	xmp = tags.XMP()
+	c.Assert(xmp["RegionList"].Namespace, qt.Equals, "mwg-rs")
+	c.Assert(xmp["RegionList"].Value, qt.DeepEquals, []imagemeta.Region{
+		{Name: "Anna", Type: "Face", X: 0.25, Y: 0.35, W: 0.05, H: 0.08},
+	})
+}
+
+func TestDecodeThumbnailConfig(t *testing.T) {
+	c := qt.New(t)
+
+	for _, filename := range []string{"sunrise.jpg", "sunrise.webp", "goexif/has-lens-info.jpg"} {
+		c.Run(filename, func(c *qt.C) {
+			b, err := os.ReadFile(filepath.Join("testdata", "images", filename))
+			c.Assert(err, qt.IsNil)
+
+			result, err := imagemeta.DecodeWithResult(imagemeta.Options{
+				R:           bytes.NewReader(b),
+				ImageFormat: extToFormat(filepath.Ext(filename)),
+				Sources:     imagemeta.EXIF,
+				ShouldHandleTag: func(ti imagemeta.TagInfo) bool {
+					return ti.Namespace == "IFD0"
+				},
+				HandleTag: func(ti imagemeta.TagInfo) error {
+					c.Assert(ti.Namespace, qt.Equals, "IFD0")
+					return nil
+				},
+			})
+			c.Assert(err, qt.IsNil)
+
+			// Compare with the thumbnail itself.
+			tags := extractTagsWithFilter(t, filename, imagemeta.EXIF, func(ti imagemeta.TagInfo) bool { return ti.Namespace == "IFD1" })
+			thumbnailOffset := tags.EXIF()["ThumbnailOffset"].Value.(uint32)
+			thumbnailLength := tags.EXIF()["ThumbnailLength"].Value.(uint32)
+			cfg, err := jpeg.DecodeConfig(bytes.NewReader(b[thumbnailOffset : thumbnailOffset+thumbnailLength]))
+			c.Assert(err, qt.IsNil)
+			c.Assert(cfg.Width > 0, qt.IsTrue)
+
+			c.Assert(result.ThumbnailConfig, qt.Equals, imagemeta.ImageConfig{Width: cfg.Width, Height: cfg.Height})
+			if filename == "sunrise.jpg" {
+				c.Assert(result.ThumbnailConfig, qt.Equals, imagemeta.ImageConfig{Width: 256, Height: 160})
+			}
+		})
+	}
+
+	// Dimensions in IFD1.
+	tb := newTIFFBuilder()
+	tiff := tb.build([]tiffEntry{tb.short(0x0112, 1)}, []tiffEntry{tb.short(0x0100, 160), tb.short(0x0101, 120)})
+	result, err := imagemeta.DecodeWithResult(imagemeta.Options{R: bytes.NewReader(jpegFile(jpegEXIFSegment(tiff))), ImageFormat: imagemeta.JPEG})
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.ThumbnailConfig, qt.Equals, imagemeta.ImageConfig{Width: 160, Height: 120})
+}
+
+func TestDecodeHandleThumbnail(t *testing.T) {
+	c := qt.New(t)
+
+	decode := func(b []byte, format imagemeta.ImageFormat) []byte {
+		var thumbnail []byte
+		err := imagemeta.Decode(imagemeta.Options{
+			R:           bytes.NewReader(b),
+			ImageFormat: format,
+			Sources:     imagemeta.EXIF,
+			HandleThumbnail: func(r io.Reader) error {
+				var err error
+				thumbnail, err = io.ReadAll(r)
+				return err
+			},
+			Warnf: panicWarnf,
+		})
+		c.Assert(err, qt.IsNil)
+		return thumbnail
+	}
+
+	// A JPEG thumbnail.
+	b := readTestDataFileAll(t, "sunrise.jpg")
+	thumbnail := decode(b, imagemeta.JPEG)
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(thumbnail))
+	c.Assert(err, qt.IsNil)
+	c.Assert(cfg.Width, qt.Equals, 256)
+
+	// An uncompressed 2x2 RGB thumbnail in two strips, one row each.
+	// The offsets are relative to the TIFF header in the APP1 segment.
+	tb := newTIFFBuilder()
+	strip1 := []byte{255, 0, 0, 0, 255, 0}
+	strip2 := []byte{0, 0, 255, 255, 255, 255}
+	tiff := tb.build(
+		[]tiffEntry{tb.ascii(0x010f, "Make")},
+		[]tiffEntry{
+			tb.short(0x0100, 2),
+			tb.short(0x0101, 2),
+			tb.short(0x0103, 1),
+			tb.long(0x0111, 0, 0), // StripOffsets, set below.
+			tb.long(0x0117, uint32(len(strip1)), uint32(len(strip2))),
+		},
+	)
+	// Append the strips in reverse order to check that they're read in the order listed.
+	offset2 := uint32(len(tiff))
+	tiff = append(tiff, strip2...)
+	offset1 := uint32(len(tiff))
+	tiff = append(tiff, strip1...)
+	i := bytes.LastIndex(tiff, []byte{0x01, 0x11, 0, 4, 0, 0, 0, 2})
+	c.Assert(i, qt.Not(qt.Equals), -1)
+	valueOffset := binary.BigEndian.Uint32(tiff[i+8:])
+	binary.BigEndian.PutUint32(tiff[valueOffset:], offset1)
+	binary.BigEndian.PutUint32(tiff[valueOffset+4:], offset2)
+
+	c.Assert(decode(jpegFile(jpegEXIFSegment(tiff)), imagemeta.JPEG), qt.DeepEquals, append(strip1, strip2...))
+
+	// No thumbnail.
+	c.Assert(decode(jpegFile(jpegEXIFSegment(tb.build([]tiffEntry{tb.ascii(0x010f, "Make")}))), imagemeta.JPEG), qt.IsNil)
+}
+
+func TestDecodeDuplicateBlocks(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newTIFFBuilder()
+	first := tb.build([]tiffEntry{tb.ascii(0x8298, "First"), tb.ascii(0x013b, "Artist")})
+	second := tb.build([]tiffEntry{tb.ascii(0x8298, "Second"), tb.ascii(0x010f, "Make")})
+
+	jpg := jpegFile(jpegEXIFSegment(first), jpegEXIFSegment(second))
+	png := pngFile(pngChunk("eXIf", first), pngChunk("eXIf", second))
+	webp := webpFile(webpChunk("VP8 ", make([]byte, 10)), webpChunk("EXIF", first), webpChunk("EXIF", second))
+
+	for _, test := range []struct {
+		b      []byte
+		format imagemeta.ImageFormat
+	}{
+		{jpg, imagemeta.JPEG},
+		{png, imagemeta.PNG},
+		{webp, imagemeta.WebP},
+	} {
+		c.Run(test.format.String(), func(c *qt.C) {
+			tags, _ := decodeBytes(c, test.b, test.format, imagemeta.Options{})
+			exif := tags.EXIF()
+			c.Assert(exif["Copyright"].Value, qt.Equals, "First")
+			c.Assert(exif["Artist"].Value, qt.Equals, "Artist")
+			c.Assert(exif["Make"].Value, qt.IsNil)
+
+			tags, _ = decodeBytes(c, test.b, test.format, imagemeta.Options{AllowDuplicateBlocks: true})
+			exif = tags.EXIF()
+			// The first EXIF tag wins.
+			c.Assert(exif["Copyright"].Value, qt.Equals, "First")
+			c.Assert(exif["Artist"].Value, qt.Equals, "Artist")
+			c.Assert(exif["Make"].Value, qt.Equals, "Make")
+		})
+	}
+}
+
+func TestDecodeDuplicateBlocksJPEGSplitEXIF(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newTIFFBuilder()
+	// IFD0 in both segments, the thumbnail IFD only in the second.
+	first := tb.build([]tiffEntry{tb.ascii(0x010f, "Make"), tb.short(0x0112, 1)})
+	second := tb.build(
+		[]tiffEntry{tb.ascii(0x010f, "Other"), tb.ascii(0x0110, "Model")},
+		[]tiffEntry{tb.short(0x0100, 160), tb.short(0x0101, 120)},
+	)
+
+	counts := make(map[string]int)
+	var tags imagemeta.Tags
+	err := imagemeta.Decode(imagemeta.Options{
+		R:                    bytes.NewReader(jpegFile(jpegEXIFSegment(first), jpegEXIFSegment(second))),
+		ImageFormat:          imagemeta.JPEG,
+		Sources:              imagemeta.EXIF,
+		AllowDuplicateBlocks: true,
+		ShouldHandleTag:      func(imagemeta.TagInfo) bool { return true },
+		HandleTag: func(ti imagemeta.TagInfo) error {
+			counts[ti.Namespace+"/"+ti.Tag]++
+			tags.Add(ti)
+			return nil
+		},
+		Warnf: panicWarnf,
+	})
+	c.Assert(err, qt.IsNil)
+
+	c.Assert(counts, qt.DeepEquals, map[string]int{
+		"IFD0/Make":        1,
+		"IFD0/Orientation": 1,
+		"IFD0/Model":       1,
+		"IFD1/ImageWidth":  1,
+		"IFD1/ImageHeight": 1,
+	})
+	exif := tags.EXIF()
+	c.Assert(exif["Make"].Value, qt.Equals, "Make")
+	c.Assert(exif["Model"].Value, qt.Equals, "Model")
+	c.Assert(exif["ImageWidth"].Namespace, qt.Equals, "IFD1")
+}
+
+func TestGetGPSDateTime(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newTIFFBuilder()
+	decode := func(opts imagemeta.Options, timeStamp ...uint32) imagemeta.Tags {
+		tiff := tb.build([]tiffEntry{tb.sub(0x8825, tb.rational(0x0007, timeStamp...), tb.ascii(0x001d, "2024:03:01"))})
+		tags, warnings := decodeBytes(c, jpegFile(jpegEXIFSegment(tiff)), imagemeta.JPEG, opts)
+		c.Assert(warnings, qt.HasLen, 0)
+		return tags
+	}
+
+	tags := decode(imagemeta.Options{DecodeStructured: true}, 13, 1, 3, 1, 42789, 1000)
+	c.Assert(tags.EXIF()["GPSTimeStamp"].Value, qt.Equals, 13*time.Hour+3*time.Minute+42789*time.Millisecond)
+	d, ok := tags.GetGPSDateTime()
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(d, qt.Equals, time.Date(2024, 3, 1, 13, 3, 42, 789000000, time.UTC))
+
+	tags = decode(imagemeta.Options{DecodeStructured: true, NormalizeDates: true}, 23, 1, 59, 1, 59, 1)
+	d, ok = tags.GetGPSDateTime()
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(d, qt.Equals, time.Date(2024, 3, 1, 23, 59, 59, 0, time.UTC))
+
+	// The string form.
+	tags = decode(imagemeta.Options{}, 13, 1, 3, 1, 4279, 100)
+	c.Assert(tags.EXIF()["GPSTimeStamp"].Value, qt.Equals, "13:03:42.79")
+	d, ok = tags.GetGPSDateTime()
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(d, qt.Equals, time.Date(2024, 3, 1, 13, 3, 42, 790000000, time.UTC))
+
+	var empty imagemeta.Tags
+	_, ok = empty.GetGPSDateTime()
+	c.Assert(ok, qt.IsFalse)
+}
+
+func TestDecodeSubjectArea(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newTIFFBuilder()
+	decode := func(structured bool, vals ...uint16) any {
+		tiff := tb.build([]tiffEntry{tb.sub(0x8769, tb.short(0x9214, vals...))})
+		tags, warnings := decodeBytes(c, jpegFile(jpegEXIFSegment(tiff)), imagemeta.JPEG, imagemeta.Options{DecodeStructured: structured})
+		c.Assert(warnings, qt.HasLen, 0)
+		return tags.EXIF()["SubjectArea"].Value
+	}
+
+	c.Assert(decode(false, 1000, 800, 200, 100), qt.Equals, "1000 800 200 100")
+	c.Assert(decode(true, 1000, 800), qt.Equals, imagemeta.SubjectArea{Kind: imagemeta.SubjectAreaPoint, X: 1000, Y: 800})
+	c.Assert(decode(true, 1000, 800, 50), qt.Equals, imagemeta.SubjectArea{Kind: imagemeta.SubjectAreaCircle, X: 1000, Y: 800, W: 50, H: 50})
+	c.Assert(decode(true, 1000, 800, 200, 100), qt.Equals, imagemeta.SubjectArea{Kind: imagemeta.SubjectAreaRectangle, X: 1000, Y: 800, W: 200, H: 100})
+}
+
+func TestDecodeRationalLists(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newTIFFBuilder()
+	decode := func(structured bool, entries ...tiffEntry) imagemeta.Tags {
+		tiff := tb.build(entries)
+		tags, warnings := decodeBytes(c, jpegFile(jpegEXIFSegment(tiff)), imagemeta.JPEG, imagemeta.Options{DecodeStructured: structured})
+		c.Assert(warnings, qt.HasLen, 0)
+		return tags
+	}
+
+	rat := func(num, den uint32) imagemeta.Rat[uint32] {
+		r, err := imagemeta.NewRat[uint32](num, den)
+		c.Assert(err, qt.IsNil)
+		return r
+	}
+
+	primaryChromaticities := tb.rational(0x013f, 64, 100, 33, 100, 21, 100, 71, 100, 15, 100, 6, 100)
+	whitePoint := tb.rational(0x013e, 3127, 10000, 329, 1000)
+
+	tags := decode(false, primaryChromaticities, whitePoint)
+	exif := tags.EXIF()
+	c.Assert(exif["PrimaryChromaticities"].Value, qt.Equals, "0.64 0.33 0.21 0.71 0.15 0.06")
+	c.Assert(exif["WhitePoint"].Value, qt.Equals, "0.3127 0.329")
+
+	tags = decode(true, primaryChromaticities, whitePoint)
+	exif = tags.EXIF()
+	v, ok := exif["PrimaryChromaticities"].Value.([]imagemeta.Rat[uint32])
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(v, qt.HasLen, 6)
+	c.Assert(v[0], eq, rat(64, 100))
+	c.Assert(v[5].Float64(), qt.Equals, 0.06)
+	c.Assert(exif["WhitePoint"].Value, eq, []imagemeta.Rat[uint32]{rat(3127, 10000), rat(329, 1000)})
+
+	// Undefined values can't be represented as a Rat, so the string form is kept.
+	tags = decode(true, tb.rational(0x013e, 3127, 10000, 0, 0))
+	c.Assert(tags.EXIF()["WhitePoint"].Value, qt.Equals, "0.3127 undef")
+}
+
+func TestDecodeCanonicalNamespaces(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newTIFFBuilder()
+	// ExposureTime in IFD0 and Make in the Exif IFD, both in the wrong place.
+	tiff := tb.build(
+		[]tiffEntry{
+			tb.rational(0x829a, 1, 250),
+			tb.sub(0x8769, tb.ascii(0x010f, "Make"), tb.rational(0x829d, 28, 10)),
+			tb.ascii(0x0110, "Model"),
+		},
+		[]tiffEntry{tb.rational(0x011a, 72, 1)},
+	)
+
+	decode := func(canonical bool) imagemeta.Tags {
+		tags, warnings := decodeBytes(c, jpegFile(jpegEXIFSegment(tiff)), imagemeta.JPEG, imagemeta.Options{
+			CanonicalNamespaces: canonical,
+			ShouldHandleTag:     func(imagemeta.TagInfo) bool { return true },
+		})
+		c.Assert(warnings, qt.HasLen, 0)
+		return tags
+	}
+
+	tags := decode(false)
+	exif := tags.EXIF()
+	c.Assert(exif["ExposureTime"].Namespace, qt.Equals, "IFD0")
+	c.Assert(exif["Make"].Namespace, qt.Equals, "IFD0/ExifIFDP")
+
+	tags = decode(true)
+	exif = tags.EXIF()
+	c.Assert(exif["ExposureTime"].Namespace, qt.Equals, "IFD0/ExifIFDP")
+	c.Assert(exif["Make"].Namespace, qt.Equals, "IFD0")
+	c.Assert(exif["Make"].Value, qt.Equals, "Make")
+	c.Assert(exif["Model"].Namespace, qt.Equals, "IFD0")
+	c.Assert(exif["FNumber"].Namespace, qt.Equals, "IFD0/ExifIFDP")
+	// Tags in the thumbnail IFD are left alone.
+	c.Assert(exif["XResolution"].Namespace, qt.Equals, "IFD1")
+}
+
+func TestDecodeSkipIFDs(t *testing.T) {
+	c := qt.New(t)
+
+	for _, imageFormat := range []imagemeta.ImageFormat{imagemeta.JPEG, imagemeta.TIFF} {
+		c.Run(imageFormat.String(), func(c *qt.C) {
+			decode := func(skipIFDs ...string) imagemeta.Tags {
+				img, close := getSunrise(c, imageFormat)
+				defer close()
+				var tags imagemeta.Tags
+				err := imagemeta.Decode(imagemeta.Options{
+					R:           img,
+					ImageFormat: imageFormat,
+					Sources:     imagemeta.EXIF,
+					SkipIFDs:    skipIFDs,
+					HandleTag: func(ti imagemeta.TagInfo) error {
+						tags.Add(ti)
+						return nil
+					},
+					Warnf: panicWarnf,
+				})
+				c.Assert(err, qt.IsNil)
+				return tags
+			}
+
+			hasNamespace := func(tags imagemeta.Tags, namespace string) bool {
+				for _, ti := range tags.EXIF() {
+					if ti.Namespace == namespace {
+						return true
+					}
+				}
+				return false
+			}
+
+			tags := decode()
+			c.Assert(hasNamespace(tags, "IFD0/GPSInfoIFD"), qt.IsTrue)
+			c.Assert(hasNamespace(tags, "IFD0/ExifIFDP"), qt.IsTrue)
+
+			tags = decode("GPSInfoIFD", "InteroperabilityIFD")
+			c.Assert(hasNamespace(tags, "IFD0/GPSInfoIFD"), qt.IsFalse)
+			c.Assert(tags.EXIF()["GPSLatitude"].Value, qt.IsNil)
+			c.Assert(hasNamespace(tags, "IFD0/ExifIFDP"), qt.IsTrue)
+			c.Assert(tags.EXIF()["Make"].Value, qt.Not(qt.IsNil))
+
+			tags = decode("ExifIFDP")
+			c.Assert(hasNamespace(tags, "IFD0/ExifIFDP"), qt.IsFalse)
+			c.Assert(hasNamespace(tags, "IFD0/GPSInfoIFD"), qt.IsTrue)
+		})
+	}
+}
+
+func TestDecodeOnlyIFD(t *testing.T) {
+	c := qt.New(t)
+
+	for _, imageFormat := range []imagemeta.ImageFormat{imagemeta.JPEG, imagemeta.TIFF} {
+		c.Run(imageFormat.String(), func(c *qt.C) {
+			decode := func(onlyIFD string) (imagemeta.Tags, map[string]bool) {
+				img, close := getSunrise(c, imageFormat)
+				defer close()
+				var tags imagemeta.Tags
+				namespaces := make(map[string]bool)
+				err := imagemeta.Decode(imagemeta.Options{
+					R:           img,
+					ImageFormat: imageFormat,
+					Sources:     imagemeta.EXIF,
+					OnlyIFD:     onlyIFD,
+					ShouldHandleTag: func(ti imagemeta.TagInfo) bool {
+						namespaces[ti.Namespace] = true
+						return true
+					},
+					HandleTag: func(ti imagemeta.TagInfo) error {
+						tags.Add(ti)
+						return nil
+					},
+					Warnf: panicWarnf,
+				})
+				c.Assert(err, qt.IsNil)
+				return tags, namespaces
+			}
+
+			tags, namespaces := decode("IFD0/GPSInfoIFD")
+			c.Assert(namespaces, qt.DeepEquals, map[string]bool{"IFD0/GPSInfoIFD": true})
+			exif := tags.EXIF()
+			c.Assert(exif["GPSLatitude"].Value, qt.Not(qt.IsNil))
+			for _, ti := range exif {
+				c.Assert(ti.Namespace, qt.Equals, "IFD0/GPSInfoIFD", qt.Commentf("%s", ti.Tag))
+			}
+
+			tags, namespaces = decode("IFD0/ExifIFDP")
+			c.Assert(namespaces, qt.DeepEquals, map[string]bool{"IFD0/ExifIFDP": true})
+			c.Assert(tags.EXIF()["Make"].Value, qt.IsNil)
+			c.Assert(tags.EXIF()["GPSLatitude"].Value, qt.IsNil)
+			c.Assert(tags.EXIF()["ExposureTime"].Value, qt.Not(qt.IsNil))
+
+			_, namespaces = decode("")
+			c.Assert(namespaces["IFD0"], qt.IsTrue)
+			c.Assert(namespaces["IFD0/GPSInfoIFD"], qt.IsTrue)
+		})
+	}
+}
+
+func TestDecodeDNGOpcodeList(t *testing.T) {
+	c := qt.New(t)
+
+	opcode := func(id uint32, flags uint32, params []byte) []byte {
+		b := appendUint32(binary.BigEndian, nil, id)
+		b = append(b, 1, 3, 0, 0)
+		b = appendUint32(binary.BigEndian, b, flags)
+		b = appendUint32(binary.BigEndian, b, uint32(len(params)))
+		return append(b, params...)
+	}
+	float64s := func(vals ...float64) []byte {
+		var b []byte
+		for _, v := range vals {
+			bits := math.Float64bits(v)
+			b = appendUint32(binary.BigEndian, b, uint32(bits>>32))
+			b = appendUint32(binary.BigEndian, b, uint32(bits))
+		}
+		return b
+	}
+
+	warp := appendUint32(binary.BigEndian, nil, 1)
+	warp = append(warp, float64s(1, -0.02, 0.003, 0, 0.0001, -0.0002, 0.5, 0.49)...)
+	vignette := float64s(0.1, 0.2, 0.3, 0.4, 0.5, 0.51, 0.52)
+	gainMap := []byte{1, 2, 3, 4}
+
+	list := appendUint32(binary.BigEndian, nil, 3)
+	list = append(list, opcode(imagemeta.OpcodeWarpRectilinear, 1, warp)...)
+	list = append(list, opcode(imagemeta.OpcodeFixVignetteRadial, 0, vignette)...)
+	list = append(list, opcode(imagemeta.OpcodeGainMap, 3, gainMap)...)
+
+	tb := newTIFFBuilder()
+	tiff := tb.build([]tiffEntry{tb.bytes(0xc74e, tiffTypeUndef, list)})
+
+	tags, warnings := decodeBytes(c, tiff, imagemeta.TIFF, imagemeta.Options{DecodeStructured: true})
+	c.Assert(warnings, qt.HasLen, 0)
+	opcodes, ok := tags.EXIF()["OpcodeList3"].Value.([]imagemeta.Opcode)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(opcodes, qt.HasLen, 3)
+	c.Assert(opcodes[0].ID, qt.Equals, uint32(imagemeta.OpcodeWarpRectilinear))
+	c.Assert(opcodes[0].Version, qt.Equals, "1.3.0.0")
+	c.Assert(opcodes[0].Flags, qt.Equals, uint32(1))
+	c.Assert(opcodes[0].Params, qt.DeepEquals, &imagemeta.WarpRectilinearParams{
+		Planes:  []imagemeta.WarpRectilinearPlane{{Radial: [4]float64{1, -0.02, 0.003, 0}, Tangential: [2]float64{0.0001, -0.0002}}},
+		CenterX: 0.5,
+		CenterY: 0.49,
+	})
+	c.Assert(opcodes[1].ID, qt.Equals, uint32(imagemeta.OpcodeFixVignetteRadial))
+	c.Assert(opcodes[1].Params, qt.DeepEquals, &imagemeta.FixVignetteRadialParams{K: [5]float64{0.1, 0.2, 0.3, 0.4, 0.5}, CenterX: 0.51, CenterY: 0.52})
+	c.Assert(opcodes[2].ID, qt.Equals, uint32(imagemeta.OpcodeGainMap))
+	c.Assert(opcodes[2].Flags, qt.Equals, uint32(3))
+	c.Assert(opcodes[2].Params, qt.DeepEquals, gainMap)
+
+	// Truncated list.
+	tiff = tb.build([]tiffEntry{tb.bytes(0xc74e, tiffTypeUndef, list[:len(list)-2])})
+	tags, warnings = decodeBytes(c, tiff, imagemeta.TIFF, imagemeta.Options{DecodeStructured: true})
+	c.Assert(warnings, qt.DeepEquals, []string{"OpcodeList3: opcode 2: invalid parameter size 4"})
+	c.Assert(tags.EXIF()["OpcodeList3"].Value, qt.HasLen, 2)
+
+	// Not structured.
+	tags, _ = decodeBytes(c, tiff, imagemeta.TIFF, imagemeta.Options{})
+	_, ok = tags.EXIF()["OpcodeList3"].Value.(string)
+	c.Assert(ok, qt.IsTrue)
+}
+
+func TestDecodeGeoTIFF(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newTIFFBuilder()
+	tiff := tb.build([]tiffEntry{
+		tb.double(0x830e, 0.5, 0.25, 0),
+		tb.double(0x8482, 0, 0, 0, 597000, 4650000, 0),
+		tb.short(0x87af,
+			1, 1, 0, 5, // Header with 5 keys.
+			1024, 0, 1, 1, // GTModelType: projected.
+			1026, 0x87b1, 13, 0, // GTCitation.
+			2057, 0x87b0, 1, 0, // GeogSemiMajorAxis.
+			3072, 0, 1, 32633, // ProjectedCSType: WGS 84 / UTM zone 33N.
+			5000, 0, 1, 42, // Unknown.
+		),
+		tb.double(0x87b0, 6378137),
+		tb.ascii(0x87b1, "UTM Zone 33N|"),
+	})
+
+	tags, warnings := decodeBytes(c, tiff, imagemeta.TIFF, imagemeta.Options{DecodeGeoTIFF: true})
+	c.Assert(warnings, qt.HasLen, 0)
+	exif := tags.EXIF()
+	c.Assert(exif["PixelScale"].Value, qt.DeepEquals, []float64{0.5, 0.25, 0})
+	c.Assert(exif["ModelTiePoint"].Value, qt.DeepEquals, []float64{0, 0, 0, 597000, 4650000, 0})
+	c.Assert(exif["GeoTiffDirectory"].Namespace, qt.Equals, "IFD0")
+	c.Assert(exif["GeoTiffDirectory"].Value, qt.DeepEquals, map[string]any{
+		"GTModelType":       uint16(1),
+		"GTCitation":        "UTM Zone 33N",
+		"GeogSemiMajorAxis": 6378137.0,
+		"ProjectedCSType":   uint16(32633),
+		"GeoKey5000":        uint16(42),
+	})
+
+	tags, _ = decodeBytes(c, tiff, imagemeta.TIFF, imagemeta.Options{})
+	exif = tags.EXIF()
+	c.Assert(exif["PixelScale"].Value, qt.DeepEquals, []any{0.5, 0.25, 0.0})
+	_, isMap := exif["GeoTiffDirectory"].Value.(map[string]any)
+	c.Assert(isMap, qt.IsFalse)
+
+	// There's no GeoTIFF in testdata, so the GeoTIFF above is built. Check that
+	// the option leaves the real TIFFs alone.
+	withTestDataFile(t, func(path string, info os.FileInfo, err error) error {
+		if ext := filepath.Ext(path); ext != ".tif" && ext != ".tiff" {
+			return nil
+		}
+		b := readTestDataFileAll(t, path)
+		want, _ := decodeBytes(c, b, imagemeta.TIFF, imagemeta.Options{})
+		got, warnings := decodeBytes(c, b, imagemeta.TIFF, imagemeta.Options{DecodeGeoTIFF: true})
+		c.Assert(warnings, qt.HasLen, 0, qt.Commentf(path))
+		c.Assert(got.All(), eq, want.All(), qt.Commentf(path))
+		return nil
+	})
+}
+
+func TestDecodeMPO(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newTIFFBuilder()
+	exif1 := jpegEXIFSegment(tb.build([]tiffEntry{tb.ascii(0x010f, "Primary")}))
+	exif2 := jpegEXIFSegment(tb.build([]tiffEntry{tb.ascii(0x010f, "Secondary")}))
+	secondary := jpegFile(exif2)
+
+	mpfSegment := func(offset uint32) []byte {
+		entries := make([]byte, 32)
+		binary.BigEndian.PutUint32(entries[20:], uint32(len(secondary)))
+		binary.BigEndian.PutUint32(entries[24:], offset)
+		return jpegSegment(0xffe2, append([]byte("MPF\x00"), tb.build([]tiffEntry{tb.bytes(0xb002, tiffTypeUndef, entries)})...))
+	}
+
+	// The MP entry offsets are relative to the TIFF header in the MPF segment.
+	mpfBase := 2 + len(exif1) + 4 + 4
+	primaryLen := len(jpegFile(exif1, mpfSegment(0)))
+	mpo := append(jpegFile(exif1, mpfSegment(uint32(primaryLen-mpfBase))), secondary...)
+
+	tags, warnings := decodeBytes(c, mpo, imagemeta.JPEG, imagemeta.Options{})
+	c.Assert(warnings, qt.HasLen, 0)
+	c.Assert(tags.All(), qt.HasLen, 1)
+	c.Assert(tags.EXIF()["Make"].Value, qt.Equals, "Primary")
+
+	var shouldHandleNamespaces []string
+	var got []string
+	err := imagemeta.Decode(imagemeta.Options{
+		R:            bytes.NewReader(mpo),
+		ImageFormat:  imagemeta.JPEG,
+		MPOAllImages: true,
+		ShouldHandleTag: func(ti imagemeta.TagInfo) bool {
+			shouldHandleNamespaces = append(shouldHandleNamespaces, ti.Namespace)
+			return true
+		},
+		HandleTag: func(ti imagemeta.TagInfo) error {
+			got = append(got, fmt.Sprintf("%s/%s: %v", ti.Namespace, ti.Tag, ti.Value))
+			return nil
+		},
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(shouldHandleNamespaces, qt.DeepEquals, []string{"IFD0", "IFD0"})
+	c.Assert(got, qt.DeepEquals, []string{"IFD0/Make: Primary", "Image2/IFD0/Make: Secondary"})
+
+	// There's no MPO in testdata, so assemble one from two real JPEGs the way
+	// the cameras do it: the MPF segment after the primary image's APP1,
+	// followed by the secondary image.
+	decodeEXIF := func(b []byte, mpoAllImages bool) map[string]any {
+		m := make(map[string]any)
+		err := imagemeta.Decode(imagemeta.Options{
+			R:               bytes.NewReader(b),
+			ImageFormat:     imagemeta.JPEG,
+			Sources:         imagemeta.EXIF,
+			MPOAllImages:    mpoAllImages,
+			Warnf:           panicWarnf,
+			ShouldHandleTag: func(ti imagemeta.TagInfo) bool { return true },
+			HandleTag: func(ti imagemeta.TagInfo) error {
+				m[ti.Namespace+"/"+ti.Tag] = ti.Value
+				return nil
+			},
+		})
+		c.Assert(err, qt.IsNil)
+		return m
+	}
+
+	primary := readTestDataFileAll(t, "sunrise.jpg")
+	secondary = readTestDataFileAll(t, "metadata_demo_exif_only.jpg")
+	c.Assert(primary[3], qt.Equals, byte(0xe1))
+	app1End := 4 + int(binary.BigEndian.Uint16(primary[4:]))
+	mpfBase = app1End + 4 + 4
+	primaryLen = len(primary) + len(mpfSegment(0))
+	mpo = append(append(append([]byte{}, primary[:app1End]...), mpfSegment(uint32(primaryLen-mpfBase))...), primary[app1End:]...)
+	mpo = append(mpo, secondary...)
+
+	want := decodeEXIF(primary, false)
+	for k, v := range decodeEXIF(secondary, false) {
+		want["Image2/"+k] = v
+	}
+	// The ThumbnailOffset is relative to the start of the file.
+	want["Image2/IFD1/ThumbnailOffset"] = want["Image2/IFD1/ThumbnailOffset"].(uint32) + uint32(len(mpo)-len(secondary))
+	c.Assert(decodeEXIF(mpo, true), eq, want)
+	c.Assert(want["Image2/IFD0/Make"], qt.Not(qt.IsNil))
+}
+
+func TestDecodeMPOWithScanTrailingData(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newTIFFBuilder()
+	exif1 := jpegEXIFSegment(tb.build([]tiffEntry{tb.ascii(0x010f, "Primary")}))
+	image2 := jpegFile(jpegEXIFSegment(tb.build([]tiffEntry{tb.ascii(0x010f, "Second")})))
+	image3 := jpegFile(jpegEXIFSegment(tb.build([]tiffEntry{tb.ascii(0x010f, "Third")})))
+
+	mpfSegment := func(offset2, offset3 uint32) []byte {
+		entries := make([]byte, 48)
+		binary.BigEndian.PutUint32(entries[20:], uint32(len(image2)))
+		binary.BigEndian.PutUint32(entries[24:], offset2)
+		binary.BigEndian.PutUint32(entries[36:], uint32(len(image3)))
+		binary.BigEndian.PutUint32(entries[40:], offset3)
+		return jpegSegment(0xffe2, append([]byte("MPF\x00"), tb.build([]tiffEntry{tb.bytes(0xb002, tiffTypeUndef, entries)})...))
+	}
+
+	// The MP entry offsets are relative to the TIFF header in the MPF segment.
+	mpfBase := 2 + len(exif1) + 4 + 4
+	primaryLen := len(jpegFile(exif1, mpfSegment(0, 0)))
+	offset2 := uint32(primaryLen - mpfBase)
+	offset3 := offset2 + uint32(len(image2))
+	mpo := append(jpegFile(exif1, mpfSegment(offset2, offset3)), image2...)
+	mpo = append(mpo, image3...)
+
+	var got []string
+	err := imagemeta.Decode(imagemeta.Options{
+		R:                bytes.NewReader(mpo),
+		ImageFormat:      imagemeta.JPEG,
+		MPOAllImages:     true,
+		ScanTrailingData: true,
+		Warnf:            panicWarnf,
+		HandleTag: func(ti imagemeta.TagInfo) error {
+			got = append(got, fmt.Sprintf("%s/%s: %v", ti.Namespace, ti.Tag, ti.Value))
+			return nil
+		},
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(got, qt.DeepEquals, []string{"IFD0/Make: Primary", "Image2/IFD0/Make: Second", "Image3/IFD0/Make: Third"})
+}
+
+func TestDecodeScanTrailingData(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newTIFFBuilder()
+	primary := jpegFile(jpegEXIFSegment(tb.build([]tiffEntry{tb.ascii(0x010f, "Primary")})))
+	trailingEXIF := jpegEXIFSegment(tb.build([]tiffEntry{tb.ascii(0x010f, "Trailing")}))
+
+	decode := func(b []byte, scanTrailingData bool) []string {
+		var got []string
+		err := imagemeta.Decode(imagemeta.Options{
+			R:                bytes.NewReader(b),
+			ImageFormat:      imagemeta.JPEG,
+			ScanTrailingData: scanTrailingData,
+			Warnf:            panicWarnf,
+			HandleTag: func(ti imagemeta.TagInfo) error {
+				got = append(got, fmt.Sprintf("%s/%s: %v", ti.Namespace, ti.Tag, ti.Value))
+				return nil
+			},
+		})
+		c.Assert(err, qt.IsNil)
+		return got
+	}
+
+	for _, b := range [][]byte{
+		// An appended JPEG.
+		append(append([]byte{}, primary...), jpegFile(trailingEXIF)...),
+		// A bare APP1 segment.
+		append(append([]byte{}, primary...), trailingEXIF...),
+	} {
+		c.Assert(decode(b, false), qt.DeepEquals, []string{"IFD0/Make: Primary"})
+		c.Assert(decode(b, true), qt.DeepEquals, []string{"IFD0/Make: Primary", "Trailing/IFD0/Make: Trailing"})
+	}
+
+	// No trailing data.
+	c.Assert(decode(primary, true), qt.DeepEquals, []string{"IFD0/Make: Primary"})
+	c.Assert(decode(append(append([]byte{}, primary...), "garbage"...), true), qt.DeepEquals, []string{"IFD0/Make: Primary"})
+
+	// The EXIF in the primary image is truncated, which is reported after the trailing data is decoded.
+	b := append(readTestDataFileAll(c, "metadata-extractor/crash01.jpg"), jpegFile(trailingEXIF)...)
+	var got []string
+	err := imagemeta.Decode(imagemeta.Options{
+		R:                bytes.NewReader(b),
+		ImageFormat:      imagemeta.JPEG,
+		ScanTrailingData: true,
+		HandleTag: func(ti imagemeta.TagInfo) error {
+			if strings.HasPrefix(ti.Namespace, "Trailing") {
+				got = append(got, fmt.Sprintf("%s/%s: %v", ti.Namespace, ti.Tag, ti.Value))
+			}
+			return nil
+		},
+	})
+	c.Assert(imagemeta.IsTruncated(err), qt.IsTrue)
+	c.Assert(got, qt.DeepEquals, []string{"Trailing/IFD0/Make: Trailing"})
+}
+
+func TestDecodeMaxTotalBytes(t *testing.T) {
+	c := qt.New(t)
+
+	xmp := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"><rdf:Description xmlns:dc="http://purl.org/dc/elements/1.1/" dc:format="image/jpeg"/></rdf:RDF></x:xmpmeta>`
+	xmp += strings.Repeat(" ", 60000)
+	b := jpegFile(jpegXMPSegment(xmp), jpegXMPSegment(xmp), jpegXMPSegment(xmp))
+
+	decode := func(maxTotalBytes int64) error {
+		err := imagemeta.Decode(imagemeta.Options{R: bytes.NewReader(b), ImageFormat: imagemeta.JPEG, MaxTotalBytes: maxTotalBytes})
+		return err
+	}
+
+	c.Assert(decode(0), qt.IsNil)
+	c.Assert(decode(int64(len(b))), qt.IsNil)
+	err := decode(4096)
+	c.Assert(err, qt.IsNotNil)
+	c.Assert(errors.Is(err, imagemeta.ErrMaxTotalBytesExceeded), qt.IsTrue, qt.Commentf("%v", err))
+}
+
+func TestDecodeFoundSources(t *testing.T) {
+	c := qt.New(t)
+
+	foundSources := func(filename string, sources imagemeta.Source) imagemeta.Source {
+		f, err := os.Open(filepath.Join("testdata", "images", filename))
+		c.Assert(err, qt.IsNil)
+		defer f.Close()
+		result, err := imagemeta.DecodeWithResult(imagemeta.Options{R: f, ImageFormat: imagemeta.JPEG, Sources: sources})
+		c.Assert(err, qt.IsNil)
+		return result.FoundSources
+	}
+
+	all := imagemeta.EXIF | imagemeta.IPTC | imagemeta.XMP
+
+	c.Assert(foundSources("sunrise.jpg", all).Has(imagemeta.XMP), qt.IsTrue)
+	c.Assert(foundSources("sunrise.jpg", all).Has(imagemeta.EXIF), qt.IsTrue)
+	c.Assert(foundSources("sunrise.jpg", imagemeta.EXIF).Has(imagemeta.XMP), qt.IsFalse)
+	c.Assert(foundSources("metadata_demo_exif_only.jpg", all), qt.Equals, imagemeta.EXIF)
+}
+
+func TestDecodeXMPHistory(t *testing.T) {
+	c := qt.New(t)
+
+	xmpPacket := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description rdf:about=""
+	xmlns:xmpMM="http://ns.adobe.com/xap/1.0/mm/"
+	xmlns:stEvt="http://ns.adobe.com/xap/1.0/sType/ResourceEvent#"
+	xmpMM:InstanceID="xmp.iid:3">
+	<xmpMM:History>
+		<rdf:Seq>
+			<rdf:li stEvt:action="created" stEvt:when="2024-01-02T10:00:00+01:00" stEvt:softwareAgent="Adobe Photoshop 25.0 (Macintosh)"/>
+			<rdf:li rdf:parseType="Resource">
+				<stEvt:action>saved</stEvt:action>
+				<stEvt:when>2024-01-02T10:30+01:00</stEvt:when>
+				<stEvt:softwareAgent>Adobe Photoshop 25.0 (Macintosh)</stEvt:softwareAgent>
+				<stEvt:changed>/</stEvt:changed>
+			</rdf:li>
+			<rdf:li>
+				<rdf:Description stEvt:action="converted" stEvt:when="2024-01-03T08:00:00Z" stEvt:softwareAgent="Adobe Photoshop Lightroom Classic 13.1 (Macintosh)" stEvt:changed="/metadata"/>
+			</rdf:li>
+		</rdf:Seq>
+	</xmpMM:History>
+</rdf:Description></rdf:RDF></x:xmpmeta>`
+
+	tags, _ := decodeBytes(c, jpegFile(jpegXMPSegment(xmpPacket)), imagemeta.JPEG, imagemeta.Options{Sources: imagemeta.XMP})
+	xmp := tags.XMP()
+	c.Assert(xmp["InstanceID"].Value, qt.Equals, "xmp.iid:3")
+	cet := time.FixedZone("", 60*60)
+	c.Assert(xmp["History"].Value, eq, []imagemeta.XMPHistoryEvent{
+		{Action: "created", When: time.Date(2024, 1, 2, 10, 0, 0, 0, cet), SoftwareAgent: "Adobe Photoshop 25.0 (Macintosh)"},
+		{Action: "saved", When: time.Date(2024, 1, 2, 10, 30, 0, 0, cet), SoftwareAgent: "Adobe Photoshop 25.0 (Macintosh)", Changed: "/"},
+		{Action: "converted", When: time.Date(2024, 1, 3, 8, 0, 0, 0, time.UTC), SoftwareAgent: "Adobe Photoshop Lightroom Classic 13.1 (Macintosh)", Changed: "/metadata"},
+	})
+}
+
+func TestDecodeTIFFReader(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newTIFFBuilder()
+	tiff := tb.build([]tiffEntry{tb.ascii(0x010f, "Canon"), tb.sub(0x8769, tb.ascii(0x9003, "2024:01:02 10:00:00"))})
+
+	var tags imagemeta.Tags
+	_, err := imagemeta.DecodeTIFFReader(bytes.NewReader(tiff), imagemeta.Options{
+		ImageFormat: imagemeta.JPEG,
+		HandleTag: func(ti imagemeta.TagInfo) error {
+			tags.Add(ti)
+			return nil
+		},
+	})
+	c.Assert(err, qt.IsNil)
+	exif := tags.EXIF()
+	c.Assert(exif["Make"].Value, qt.Equals, "Canon")
+	c.Assert(exif["DateTimeOriginal"].Value, qt.Equals, "2024:01:02 10:00:00")
+}
+
+func TestIFDWalker(t *testing.T) {
+	c := qt.New(t)
+
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		tb := tiffBuilder{order: order}
+		tiff := tb.build([]tiffEntry{
+			tb.ascii(0x010f, "Canon"),
+			tb.short(0x0112, 6),
+			tb.sub(0x8769, tb.short(0xa001, 1)),
+		})
+
+		var got []string
+		var walker imagemeta.IFDWalker
+		// The IFD starts after the 8 byte TIFF header.
+		err := walker.WalkIFD(bytes.NewReader(tiff), order, 8, "Custom", func(ti imagemeta.TagInfo) error {
+			got = append(got, fmt.Sprintf("%s/%s: %v", ti.Namespace, ti.Tag, ti.Value))
+			return nil
+		})
+		c.Assert(err, qt.IsNil)
+		c.Assert(got, qt.DeepEquals, []string{"Custom/Make: Canon", "Custom/Orientation: 6", "Custom/ExifIFDP/ColorSpace: 1"})
+
+		walker.Options.ShouldHandleTag = func(ti imagemeta.TagInfo) bool { return ti.Tag == "Make" }
+		got = nil
+		err = walker.WalkIFD(bytes.NewReader(tiff), order, 8, "Custom", func(ti imagemeta.TagInfo) error {
+			got = append(got, fmt.Sprintf("%s/%s: %v", ti.Namespace, ti.Tag, ti.Value))
+			return nil
+		})
+		c.Assert(err, qt.IsNil)
+		c.Assert(got, qt.DeepEquals, []string{"Custom/Make: Canon"})
+	}
+}
+
+func TestDecodeAt(t *testing.T) {
+	c := qt.New(t)
+
+	b := readTestDataFileAll(c, "sunrise.jpg")
+	r := bytes.NewReader(b)
+
+	decode := func() (imagemeta.Tags, error) {
+		var tags imagemeta.Tags
+		_, err := imagemeta.DecodeAt(r, int64(len(b)), imagemeta.Options{
+			ImageFormat: imagemeta.JPEG,
+			Sources:     imagemeta.EXIF | imagemeta.IPTC | imagemeta.XMP,
+			HandleTag: func(ti imagemeta.TagInfo) error {
+				tags.Add(ti)
+				return nil
+			},
+		})
+		return tags, err
+	}
+
+	want, err := decode()
+	c.Assert(err, qt.IsNil)
+	c.Assert(want.EXIF()["Orientation"].Value, qt.Equals, uint16(1))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				tags, err := decode()
+				c.Check(err, qt.IsNil)
+				c.Check(tags.String(), qt.Equals, want.String())
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestDecodeSignedRationalZeroDenominator(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newTIFFBuilder()
+	decode := func(entry tiffEntry) any {
+		tiff := tb.build([]tiffEntry{tb.sub(0x8769, entry)})
+		tags, warnings := decodeBytes(c, jpegFile(jpegEXIFSegment(tiff)), imagemeta.JPEG, imagemeta.Options{})
+		c.Assert(warnings, qt.HasLen, 0)
+		return tags.EXIF()["ExposureCompensation"].Value
+	}
+
+	c.Assert(decode(tb.srational(0x9204, 1, 0)), qt.Equals, "undef")
+	// The same as for unsigned rationals.
+	c.Assert(decode(tb.rational(0x9204, 1, 0)), qt.Equals, "undef")
+	c.Assert(fmt.Sprint(decode(tb.srational(0x9204, -1, 3))), qt.Equals, "-1/3")
+}
+
+func TestDecodeDNGColorMatrices(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newTIFFBuilder()
+	colorMatrix1 := []int32{6722, -635, -963, -4287, 12460, 2028, -908, 2162, 5668}
+	srationals := func(vals []int32) []int32 {
+		var v []int32
+		for _, vv := range vals {
+			v = append(v, vv, 10000)
+		}
+		return v
+	}
+	tiff := tb.build([]tiffEntry{
+		tb.srational(0xc621, srationals(colorMatrix1)...),
+		tb.srational(0xc714, srationals([]int32{7763, 1111, 769, 2901, 8378, -1279, 132, -2149, 10270})...),
+		tb.rational(0xc628, 4838, 10000, 10000, 10000, 6539, 10000),
+	})
+
+	var wantColorMatrix1 []float64
+	for _, v := range colorMatrix1 {
+		wantColorMatrix1 = append(wantColorMatrix1, float64(v)/10000)
+	}
+
+	tags, warnings := decodeBytes(c, tiff, imagemeta.TIFF, imagemeta.Options{})
+	c.Assert(warnings, qt.HasLen, 0)
+	exif := tags.EXIF()
+	c.Assert(exif["ColorMatrix1"].Value, qt.DeepEquals, wantColorMatrix1)
+	c.Assert(exif["ForwardMatrix1"].Value, qt.HasLen, 9)
+	c.Assert(exif["AsShotNeutral"].Value, qt.DeepEquals, []float64{0.4838, 1, 0.6539})
+
+	tags, warnings = decodeBytes(c, tiff, imagemeta.TIFF, imagemeta.Options{DecodeStructured: true})
+	c.Assert(warnings, qt.HasLen, 0)
+	exif = tags.EXIF()
+	c.Assert(exif["ColorMatrix1"].Value, qt.DeepEquals, imagemeta.Matrix{Rows: 3, Cols: 3, Values: wantColorMatrix1})
+	c.Assert(exif["ForwardMatrix1"].Value.(imagemeta.Matrix).Rows, qt.Equals, 3)
+	c.Assert(exif["AsShotNeutral"].Value, qt.DeepEquals, []float64{0.4838, 1, 0.6539})
+}
+
+func TestDecodeDNGUndefinedRationals(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newTIFFBuilder()
+	tiff := tb.build([]tiffEntry{
+		tb.srational(0xc621, 1, 0, 0, 1, 0, 1, 0, 1, 1, 1, 0, 1, 0, 1, 0, 1, 1, 1),
+		tb.rational(0xc628, 1, 0, 1, 1, 1, 1),
+	})
+
+	for _, structured := range []bool{false, true} {
+		tags, _ := decodeBytes(c, tiff, imagemeta.TIFF, imagemeta.Options{DecodeStructured: structured})
+		exif := tags.EXIF()
+		c.Assert(exif["ColorMatrix1"].Value, qt.Equals, "undef")
+		c.Assert(exif["AsShotNeutral"].Value, qt.Equals, "undef")
+		_, err := json.Marshal(tags.All())
+		c.Assert(err, qt.IsNil)
+	}
+}
+
+func TestFocalLength35mm(t *testing.T) {
+	c := qt.New(t)
+
+	// FocalLengthIn35mmFormat is set.
+	tags := extractTags(t, "goexif/has-lens-info.jpg", imagemeta.EXIF)
+	mm, ok := tags.FocalLength35mm()
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(mm, qt.Equals, 35)
+
+	// Computed from the sensor size (a 1/2.7" sensor).
+	tags = extractTags(t, "metadata-extractor/simple.jpg", imagemeta.EXIF)
+	c.Assert(tags.EXIF()["FocalLengthIn35mmFormat"].Value, qt.IsNil)
+	mm, ok = tags.FocalLength35mm()
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(mm, qt.Equals, 214)
+
+	tb := newTIFFBuilder()
+	decode := func(entries ...tiffEntry) imagemeta.Tags {
+		tiff := tb.build([]tiffEntry{tb.sub(0x8769, entries...)})
+		tags, warnings := decodeBytes(c, jpegFile(jpegEXIFSegment(tiff)), imagemeta.JPEG, imagemeta.Options{})
+		c.Assert(warnings, qt.HasLen, 0)
+		return tags
+	}
+
+	// Only the width is known, a 23.5 mm wide APS-C sensor with the resolution in millimeters.
+	tags = decode(
+		tb.rational(0x920a, 35, 1),
+		tb.long(0xa002, 6000),
+		tb.rational(0xa20e, 60000, 235),
+		tb.short(0xa210, 4),
+	)
+	mm, ok = tags.FocalLength35mm()
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(mm, qt.Equals, 54)
+
+	// No sensor size.
+	tags = decode(tb.rational(0x920a, 35, 1))
+	_, ok = tags.FocalLength35mm()
+	c.Assert(ok, qt.IsFalse)
+}
+
+func TestSoftware(t *testing.T) {
+	c := qt.New(t)
+
+	tags := extractTags(t, "sunrise.jpg", imagemeta.EXIF|imagemeta.XMP)
+	c.Assert(tags.Software(), qt.Equals, "Adobe Photoshop Lightroom Classic 12.4 (Macintosh)")
+
+	tb := newTIFFBuilder()
+	decode := func(entries ...tiffEntry) string {
+		tags, _ := decodeBytes(c, jpegFile(jpegEXIFSegment(tb.build(entries))), imagemeta.JPEG, imagemeta.Options{})
+		return tags.Software()
+	}
+	c.Assert(decode(), qt.Equals, "")
+	c.Assert(decode(tb.ascii(0x000b, "Processor 1.0")), qt.Equals, "Processor 1.0")
+	c.Assert(decode(tb.ascii(0x000b, "Processor 1.0"), tb.ascii(0x0131, "Editor 2.0")), qt.Equals, "Editor 2.0")
+}
+
+func TestDecodeGPSTagNamesOnlyInGPSIFD(t *testing.T) {
+	c := qt.New(t)
+
+	// The GPS tag IDs overlap with the low IFD0 and InteroperabilityIFD tag IDs.
+	tags := extractTags(t, "hugo-issue-12669.jpg", imagemeta.EXIF)
+	exif := tags.EXIF()
+	c.Assert(exif["InteropIndex"].Value, qt.Equals, "R98")
+	c.Assert(exif["InteropIndex"].Namespace, qt.Equals, "IFD0/ExifIFDP/InteroperabilityIFD")
+	c.Assert(exif["GPSLatitudeRef"].Value, qt.Equals, "N")
+	c.Assert(exif["GPSLatitudeRef"].Namespace, qt.Equals, "IFD0/GPSInfoIFD")
+	for _, ti := range exif {
+		if strings.HasPrefix(ti.Tag, "GPS") {
+			c.Assert(ti.Namespace, qt.Equals, "IFD0/GPSInfoIFD", qt.Commentf(ti.Tag))
+		}
+	}
+
+	// No sample in testdata has ProcessingSoftware in IFD0.
+	tb := newTIFFBuilder()
+	tags, _ = decodeBytes(c, jpegFile(jpegEXIFSegment(tb.build([]tiffEntry{tb.ascii(0x000b, "Processor 1.0")}))), imagemeta.JPEG, imagemeta.Options{})
+	c.Assert(tags.EXIF()["ProcessingSoftware"].Value, qt.Equals, "Processor 1.0")
+	c.Assert(tags.EXIF()["GPSDOP"].Value, qt.IsNil)
+}
+
+func TestGetISO(t *testing.T) {
+	c := qt.New(t)
+
+	tags := extractTags(t, "sunrise.jpg", imagemeta.EXIF)
+	iso, ok := tags.GetISO()
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(iso, qt.Equals, 100)
+
+	tb := newTIFFBuilder()
+	getISO := func(entries ...tiffEntry) (int, bool) {
+		tags, _ := decodeBytes(c, jpegFile(jpegEXIFSegment(tb.build([]tiffEntry{tb.sub(0x8769, entries...)}))), imagemeta.JPEG, imagemeta.Options{})
+		return tags.GetISO()
+	}
+
+	for _, test := range []struct {
+		name    string
+		entries []tiffEntry
+		iso     int
+		ok      bool
+	}{
+		{"none", nil, 0, false},
+		{"ISO", []tiffEntry{tb.short(0x8827, 3200), tb.short(0x8830, 2), tb.long(0x8832, 6400)}, 3200, true},
+		{"SOS", []tiffEntry{tb.short(0x8827, 65535), tb.short(0x8830, 1), tb.long(0x8831, 102400)}, 102400, true},
+		{"REI", []tiffEntry{tb.short(0x8827, 65535), tb.short(0x8830, 2), tb.long(0x8831, 80000), tb.long(0x8832, 102400)}, 102400, true},
+		{"ISO speed", []tiffEntry{tb.short(0x8827, 65535), tb.short(0x8830, 3), tb.long(0x8831, 80000), tb.long(0x8833, 204800)}, 204800, true},
+		{"SOS and REI", []tiffEntry{tb.short(0x8827, 65535), tb.short(0x8830, 4), tb.long(0x8831, 80000), tb.long(0x8832, 102400)}, 80000, true},
+		{"no SensitivityType", []tiffEntry{tb.short(0x8827, 65535), tb.long(0x8833, 204800)}, 204800, true},
+		{"overflow only", []tiffEntry{tb.short(0x8827, 65535)}, 65535, true},
+		{"multiple values", []tiffEntry{tb.short(0x8827, 400, 0)}, 400, true},
+	} {
+		iso, ok := getISO(test.entries...)
+		c.Assert(ok, qt.Equals, test.ok, qt.Commentf(test.name))
+		c.Assert(iso, qt.Equals, test.iso, qt.Commentf(test.name))
+	}
+}
+
+func TestCopyrightAndCreator(t *testing.T) {
+	c := qt.New(t)
+
+	tags := extractTags(t, "sunrise.jpg", imagemeta.EXIF|imagemeta.IPTC|imagemeta.XMP)
+	c.Assert(tags.Copyright(), qt.Equals, "Bjørn Erik Pedersen")
+	c.Assert(tags.Creator(), qt.DeepEquals, []string{"Bjørn Erik Pedersen"})
+
+	// The IPTC and EXIF values are out of sync in this file.
+	tags = extractTags(t, "metadata_demo_inc_exif_out_of_sync_Inc_adobe.jpg", imagemeta.EXIF|imagemeta.IPTC)
+	c.Assert(tags.Copyright(), qt.Equals, "© Copyright 2017 Carl Seibert  metadatamatters.blog (IIM)")
+	c.Assert(tags.Creator(), qt.DeepEquals, []string{"Carl Seibert (IIM)"})
+
+	tags = extractTags(t, "metadata_demo_exif_only.jpg", imagemeta.EXIF|imagemeta.IPTC|imagemeta.XMP)
+	c.Assert(tags.Copyright(), qt.Equals, "© Copyright 2017 Carl Seibert  metadatamatters.blog (Exif)")
+	c.Assert(tags.Creator(), qt.DeepEquals, []string{"Carl Seibert (Exif)"})
+
+	tb := newTIFFBuilder()
+	tags, _ = decodeBytes(c, jpegFile(jpegEXIFSegment(tb.build([]tiffEntry{tb.ascii(0x013b, "Jane Doe; John Doe; Jane Doe")}))), imagemeta.JPEG, imagemeta.Options{})
+	c.Assert(tags.Copyright(), qt.Equals, "")
+	c.Assert(tags.Creator(), qt.DeepEquals, []string{"Jane Doe", "John Doe"})
+
+	xmpPacket := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"><rdf:Description xmlns:dc="http://purl.org/dc/elements/1.1/">
+<dc:creator><rdf:Seq><rdf:li>Jane Doe</rdf:li><rdf:li>John Doe</rdf:li><rdf:li>Jane Doe</rdf:li></rdf:Seq></dc:creator>
+<dc:rights><rdf:Alt><rdf:li xml:lang="de">Alle Rechte vorbehalten</rdf:li><rdf:li xml:lang="x-default">All rights reserved</rdf:li></rdf:Alt></dc:rights>
+</rdf:Description></rdf:RDF></x:xmpmeta>`
+	tags, _ = decodeBytes(c, jpegFile(jpegXMPSegment(xmpPacket)), imagemeta.JPEG, imagemeta.Options{Sources: imagemeta.XMP})
+	c.Assert(tags.XMP(), qt.HasLen, 0)
+	c.Assert(tags.Copyright(), qt.Equals, "")
+	c.Assert(tags.Creator(), qt.IsNil)
+
+	for _, qualified := range []bool{false, true} {
+		tags, _ = decodeBytes(c, jpegFile(jpegXMPSegment(xmpPacket)), imagemeta.JPEG, imagemeta.Options{Sources: imagemeta.XMP, DecodeXMPDublinCore: true, XMPQualifiedNames: qualified})
+		c.Assert(tags.Copyright(), qt.Equals, "All rights reserved")
+		c.Assert(tags.Creator(), qt.DeepEquals, []string{"Jane Doe", "John Doe"})
+	}
+}
+
+func TestTagsAccessorsXMPQualifiedNames(t *testing.T) {
+	c := qt.New(t)
+
+	xmpPacket := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmp:CreatorTool="Editor 3.0" xmp:Rating="4"/>
+</rdf:RDF></x:xmpmeta>`
+
+	for _, qualified := range []bool{false, true} {
+		tags, _ := decodeBytes(c, jpegFile(jpegXMPSegment(xmpPacket)), imagemeta.JPEG, imagemeta.Options{Sources: imagemeta.XMP, XMPQualifiedNames: qualified})
+		_, found := tags.XMP()["xmp:Rating"]
+		c.Assert(found, qt.Equals, qualified)
+		c.Assert(tags.Software(), qt.Equals, "Editor 3.0")
+		stars, ok := tags.Rating()
+		c.Assert(ok, qt.IsTrue)
+		c.Assert(stars, qt.Equals, 4)
+	}
+}
+
+func TestDecodeTagID(t *testing.T) {
+	c := qt.New(t)
+
+	tags := extractTags(t, "sunrise.jpg", imagemeta.EXIF|imagemeta.IPTC|imagemeta.XMP)
+	c.Assert(tags.EXIF()["Orientation"].ID, qt.Equals, uint16(0x0112))
+	c.Assert(tags.EXIF()["ExposureTime"].ID, qt.Equals, uint16(0x829a))
+	c.Assert(tags.EXIF()["GPSLatitude"].ID, qt.Equals, uint16(0x0002))
+	c.Assert(tags.IPTC()["Headline"].ID, qt.Equals, uint16(105))
+	c.Assert(tags.XMP()["CreatorTool"].ID, qt.Equals, uint16(0))
+
+	// 0x000b is ProcessingSoftware in IFD0 and GPSDOP in the GPS IFD.
+	tb := newTIFFBuilder()
+	tags, _ = decodeBytes(c, jpegFile(jpegEXIFSegment(tb.build([]tiffEntry{
+		tb.ascii(0x000b, "Processor 1.0"),
+		tb.sub(0x8825, tb.rational(0x000b, 27, 10)),
+	}))), imagemeta.JPEG, imagemeta.Options{})
+	c.Assert(tags.EXIF()["ProcessingSoftware"].ID, qt.Equals, uint16(0x000b))
+	c.Assert(tags.EXIF()["GPSDOP"].ID, qt.Equals, uint16(0x000b))
+	c.Assert(tags.EXIF()["GPSDOP"].Namespace, qt.Equals, "IFD0/GPSInfoIFD")
+}
+
+func TestDecodeXMPFile(t *testing.T) {
+	c := qt.New(t)
+
+	decode := func(opts imagemeta.Options) imagemeta.Tags {
+		f, err := os.Open(filepath.Join("testdata", "sidecar", "lightroom.xmp"))
+		c.Assert(err, qt.IsNil)
+		defer f.Close()
+		var tags imagemeta.Tags
+		opts.HandleTag = func(ti imagemeta.TagInfo) error {
+			tags.Add(ti)
+			return nil
+		}
+		c.Assert(imagemeta.DecodeXMPFile(f, opts), qt.IsNil)
+		return tags
+	}
+
+	tags := decode(imagemeta.Options{})
+	xmp := tags.XMP()
+	c.Assert(xmp["Rating"].Value, qt.Equals, "4")
+	c.Assert(xmp["Label"].Value, qt.Equals, "Green")
+	c.Assert(xmp["Make"].Value, qt.Equals, "FUJIFILM")
+	c.Assert(xmp["LensModel"].Value, qt.Equals, "XF16-80mmF4 R OIS WR")
+	c.Assert(xmp["Exposure2012"].Value, qt.Equals, "+0.35")
+	c.Assert(xmp["creator"].Value, qt.IsNil)
+	tags = decode(imagemeta.Options{DecodeXMPDublinCore: true})
+	c.Assert(tags.XMP()["creator"].Value, qt.DeepEquals, []string{"Jane Photographer"})
+	c.Assert(xmp["Rating"].Namespace, qt.Equals, "http://ns.adobe.com/xap/1.0/")
+	c.Assert(tags.EXIF(), qt.HasLen, 0)
+
+	tags = decode(imagemeta.Options{NormalizeDates: true})
+	c.Assert(tags.XMP()["ModifyDate"].Value, eq, time.Date(2024, 3, 2, 14, 21, 7, 0, time.FixedZone("", 3600)))
+
+	tags = decode(imagemeta.Options{Sources: imagemeta.EXIF})
+	c.Assert(tags.XMP(), qt.HasLen, 0)
+}
+
+func TestSupportedFormats(t *testing.T) {
+	c := qt.New(t)
+
+	formats := imagemeta.SupportedFormats()
+	c.Assert(formats, qt.HasLen, 4)
+
+	byFormat := make(map[imagemeta.ImageFormat]imagemeta.FormatInfo)
+	for _, f := range formats {
+		c.Assert(f.Name, qt.Equals, f.Format.String())
+		c.Assert(f.Extensions, qt.Not(qt.HasLen), 0)
+		byFormat[f.Format] = f
+	}
+
+	webp := byFormat[imagemeta.WebP]
+	c.Assert(webp.Sources, qt.Equals, imagemeta.EXIF|imagemeta.XMP)
+	c.Assert(webp.Sources.Has(imagemeta.IPTC), qt.IsFalse)
+	c.Assert(webp.Extensions, qt.DeepEquals, []string{".webp"})
+	c.Assert(byFormat[imagemeta.JPEG].Sources, qt.Equals, imagemeta.EXIF|imagemeta.IPTC|imagemeta.XMP)
+	c.Assert(byFormat[imagemeta.PNG].Sources, qt.Equals, imagemeta.EXIF|imagemeta.IPTC|imagemeta.XMP|imagemeta.PNGChunks)
+
+	// The returned slice is a copy.
+	formats[0].Extensions[0] = ".foo"
+	c.Assert(imagemeta.SupportedFormats()[0].Extensions[0], qt.Not(qt.Equals), ".foo")
+}
+
+func TestTagsMerge(t *testing.T) {
+	c := qt.New(t)
+
+	newTags := func(tags ...imagemeta.TagInfo) imagemeta.Tags {
+		var t imagemeta.Tags
+		for _, ti := range tags {
+			t.Add(ti)
+		}
+		return t
+	}
+
+	image := newTags(
+		imagemeta.TagInfo{Source: imagemeta.EXIF, Tag: "Make", Value: "Canon"},
+		imagemeta.TagInfo{Source: imagemeta.XMP, Tag: "Rating", Value: "1"},
+	)
+	sidecar := newTags(
+		imagemeta.TagInfo{Source: imagemeta.XMP, Tag: "Rating", Value: "5"},
+		imagemeta.TagInfo{Source: imagemeta.XMP, Tag: "Label", Value: "Red"},
+		// Same name, different source.
+		imagemeta.TagInfo{Source: imagemeta.IPTC, Tag: "Make", Value: "Nikon"},
+	)
+
+	merged := newTags()
+	merged.Merge(image, false)
+	merged.Merge(sidecar, false)
+	c.Assert(merged.EXIF()["Make"].Value, qt.Equals, "Canon")
+	c.Assert(merged.IPTC()["Make"].Value, qt.Equals, "Nikon")
+	c.Assert(merged.XMP()["Rating"].Value, qt.Equals, "1")
+	c.Assert(merged.XMP()["Label"].Value, qt.Equals, "Red")
+
+	merged = newTags()
+	merged.Merge(image, true)
+	merged.Merge(sidecar, true)
+	c.Assert(merged.EXIF()["Make"].Value, qt.Equals, "Canon")
+	c.Assert(merged.XMP()["Rating"].Value, qt.Equals, "5")
+	c.Assert(merged.XMP()["Label"].Value, qt.Equals, "Red")
+
+	// The merged tags are not modified.
+	c.Assert(image.XMP(), qt.HasLen, 1)
+	c.Assert(image.XMP()["Rating"].Value, qt.Equals, "1")
+}
+
+func TestTagsString(t *testing.T) {
+	c := qt.New(t)
+
+	tags := extractTags(t, "sunrise.jpg", imagemeta.EXIF|imagemeta.IPTC|imagemeta.XMP)
+	s := tags.String()
+	c.Assert(s, qt.Contains, "[EXIF] Make: RICOH IMAGING COMPANY, LTD.\n")
+	c.Assert(s, qt.Contains, "[EXIF] Orientation: 1\n")
+	c.Assert(s, qt.Contains, "[IPTC] Headline: Sunrise in Spain\n")
+	c.Assert(s, qt.Contains, "[XMP] CreatorTool: Adobe Photoshop Lightroom Classic 12.4 (Macintosh)\n")
+
+	// Grouped by source and sorted by name.
+	c.Assert(strings.Index(s, "[EXIF] Make:") < strings.Index(s, "[EXIF] Orientation:"), qt.IsTrue)
+	c.Assert(strings.Index(s, "[EXIF] Orientation:") < strings.Index(s, "[IPTC] Headline:"), qt.IsTrue)
+	c.Assert(strings.Index(s, "[IPTC] Headline:") < strings.Index(s, "[XMP] CreatorTool:"), qt.IsTrue)
+
+	var empty imagemeta.Tags
+	c.Assert(empty.String(), qt.Equals, "")
+}
+
+func TestDecodeColorSpaceAndGamma(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newTIFFBuilder()
+	decode := func(colorSpace uint16, opts imagemeta.Options) imagemeta.Tags {
+		tiff := tb.build([]tiffEntry{tb.sub(0x8769, tb.short(0xa001, colorSpace), tb.rational(0xa500, 11, 5))})
+		tags, warnings := decodeBytes(c, jpegFile(jpegEXIFSegment(tiff)), imagemeta.JPEG, opts)
+		c.Assert(warnings, qt.HasLen, 0)
+		return tags
+	}
+
+	// Adobe RGB.
+	tags := decode(2, imagemeta.Options{})
+	c.Assert(tags.EXIF()["ColorSpace"].Value, qt.Equals, uint16(2))
+	c.Assert(tags.EXIF()["Gamma"].Value, eq, 2.2)
+
+	tags = decode(2, imagemeta.Options{DecodeEnumLabels: true})
+	c.Assert(tags.EXIF()["ColorSpace"].Value, qt.Equals, "Adobe RGB")
+	c.Assert(tags.EXIF()["Gamma"].Value, eq, 2.2)
+
+	for colorSpace, label := range map[uint16]string{1: "sRGB", 0xffff: "Uncalibrated", 3: "Unknown (3)"} {
+		tags = decode(colorSpace, imagemeta.Options{DecodeEnumLabels: true})
+		c.Assert(tags.EXIF()["ColorSpace"].Value, qt.Equals, label)
+	}
+}
+
+func TestDecodeValueConverters(t *testing.T) {
+	c := qt.New(t)
+
+	tb := newTIFFBuilder()
+	userComment := tb.bytes(0x9286, tiffTypeUndef, append([]byte("ASCII\x00\x00\x00"), "Hello, World!"...))
+	b := jpegFile(jpegEXIFSegment(tb.build([]tiffEntry{tb.ascii(0x010f, "Canon"), tb.sub(0x8769, userComment)})))
+
+	tags, _ := decodeBytes(c, b, imagemeta.JPEG, imagemeta.Options{})
+	c.Assert(tags.EXIF()["UserComment"].Value, qt.Equals, "Hello, World!")
+
+	tags, _ = decodeBytes(c, b, imagemeta.JPEG, imagemeta.Options{
+		ValueConverters: map[string]func(imagemeta.TagInfo) any{
+			"UserComment": func(ti imagemeta.TagInfo) any {
+				// The built-in converter has already decoded the value.
+				return strings.ToUpper(ti.Value.(string))
+			},
+		},
+	})
+	exif := tags.EXIF()
+	c.Assert(exif["UserComment"].Value, qt.Equals, "HELLO, WORLD!")
+	c.Assert(exif["Make"].Value, qt.Equals, "Canon")
+}
+
+func TestDecodePhotoshopResources(t *testing.T) {
+	c := qt.New(t)
+
+	var photoshop []imagemeta.TagInfo
+	tags := extractTagsWithFilter(t, "sunrise.jpg", imagemeta.IPTC, func(ti imagemeta.TagInfo) bool {
+		if ti.Namespace == "Photoshop" {
+			photoshop = append(photoshop, ti)
+		}
+		return true
+	})
+	iptc := tags.IPTC()
+	c.Assert(iptc["XResolution"].Value, qt.Equals, float64(72))
+	c.Assert(iptc["YResolution"].Value, qt.Equals, float64(72))
+	c.Assert(iptc["DisplayedUnitsX"].Value, qt.Equals, uint16(1))
+	c.Assert(iptc["DisplayedUnitsY"].Value, qt.Equals, uint16(1))
+	c.Assert(iptc["IPTCDigest"].Value, qt.Equals, "3973c18c424c7aa78b7a4e78c6c18645")
+	c.Assert(iptc["Category"].Namespace, qt.Equals, "IPTCApplication")
+	c.Assert(photoshop, qt.HasLen, 5)
+}
+
+func TestDecodePhotoshopResourcesGolden(t *testing.T) {
+	c := qt.New(t)
+
+	// The golden tests skip tags we don't decode, so make sure we find the
+	// Photoshop resources in all the real JPEG files exiftool found them in.
+	// We don't decode the resources stored in the TIFF ImageResources tag.
+	var count int
+	withTestDataFile(t, func(path string, info os.FileInfo, err error) error {
+		if strings.HasPrefix(path, "corrupt") || goldenSkip[filepath.ToSlash(path)] || filepath.Ext(path) != ".jpg" {
+			return nil
+		}
+		golden := readGoldenInfo(t, path).Photoshop
+		if len(golden) == 0 {
+			return nil
+		}
+		count++
+		tags := extractTags(t, path, imagemeta.IPTC)
+		iptc := tags.IPTC()
+		for _, name := range []string{"XResolution", "YResolution", "DisplayedUnitsX", "DisplayedUnitsY", "IPTCDigest"} {
+			expect, found := golden[name]
+			if !found {
+				continue
+			}
+			ti, found := iptc[name]
+			c.Assert(found, qt.IsTrue, qt.Commentf("%s: %s", path, name))
+			c.Assert(ti.Namespace, qt.Equals, "Photoshop")
+			got := ti.Value
+			if v, ok := got.(uint16); ok {
+				got = float64(v)
+			}
+			c.Assert(got, eq, expect, qt.Commentf("%s: %s", path, name))
+		}
+		return nil
+	})
+	c.Assert(count > 20, qt.IsTrue)
+}
+
+func TestProbe(t *testing.T) {
+	c := qt.New(t)
+
+	for _, filename := range []string{"sunrise.jpg", "sunrise.png", "sunrise.webp", "sunrise.tif", "metadata_demo_exif_only.jpg", "metadata_demo_iim_and_xmp_only.jpg", "goexif/geodegrees_as_string.jpg"} {
+		b, err := os.ReadFile(filepath.Join("testdata", "images", filename))
+		c.Assert(err, qt.IsNil)
+		imageFormat := extToFormat(filepath.Ext(filename))
+
+		probe, err := imagemeta.Probe(imagemeta.Options{R: bytes.NewReader(b), ImageFormat: imageFormat})
+		c.Assert(err, qt.IsNil)
+
+		var hasGPS bool
+		result, err := imagemeta.DecodeWithResult(imagemeta.Options{
+			R:           bytes.NewReader(b),
+			ImageFormat: imageFormat,
+			HandleTag: func(ti imagemeta.TagInfo) error {
+				if strings.HasSuffix(ti.Namespace, "GPSInfoIFD") {
+					hasGPS = true
+				}
+				return nil
+			},
+		})
+		c.Assert(err, qt.IsNil)
+
+		c.Assert(probe.Sources, qt.Equals, result.FoundSources, qt.Commentf(filename))
+		c.Assert(probe.HasGPS, qt.Equals, hasGPS, qt.Commentf(filename))
+	}
+
+	b, err := os.ReadFile(filepath.Join("testdata", "images", "metadata_demo_exif_only.jpg"))
+	c.Assert(err, qt.IsNil)
+	probe, err := imagemeta.Probe(imagemeta.Options{R: bytes.NewReader(b), ImageFormat: imagemeta.JPEG})
+	c.Assert(err, qt.IsNil)
+	c.Assert(probe.Sources, qt.Equals, imagemeta.EXIF)
+}
+
+func BenchmarkProbe(b *testing.B) {
+	img, close := getSunrise(qt.New(b), imagemeta.JPEG)
+	b.Cleanup(close)
+
+	b.Run("probe", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := imagemeta.Probe(imagemeta.Options{R: img, ImageFormat: imagemeta.JPEG}); err != nil {
+				b.Fatal(err)
+			}
+			img.Seek(0, 0)
+		}
+	})
+
+	b.Run("decode", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if err := imagemeta.Decode(imagemeta.Options{R: img, ImageFormat: imagemeta.JPEG}); err != nil {
+				b.Fatal(err)
+			}
+			img.Seek(0, 0)
+		}
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"sync"
 )
@@ -16,6 +17,11 @@ type bytesAndReader struct {
 	r *bytes.Reader
 }
 
+// maxPooledBufferSize is the maximum capacity of a buffer that will be returned to the pool.
+// Larger buffers (e.g. from a huge tag) are left for the garbage collector,
+// so a single big file doesn't pin memory in long running processes.
+const maxPooledBufferSize = 64 << 10
+
 var bytesAndReaderPool = &sync.Pool{
 	New: func() any {
 		return &bytesAndReader{
@@ -35,6 +41,9 @@ func getBytesAndReader(length int) *bytesAndReader {
 }
 
 func putBytesAndReader(br *bytesAndReader) {
+	if cap(br.b) > maxPooledBufferSize {
+		return
+	}
 	br.b = br.b[:0]
 	bytesAndReaderPool.Put(br)
 }
@@ -48,6 +57,26 @@ func newStreamReader(r io.Reader, byteOrder binary.ByteOrder) *streamReader {
 	}
 }
 
+// limitedReadSeeker counts the bytes read from the underlying ReadSeeker
+// and fails with ErrMaxTotalBytesExceeded when more than max bytes are read.
+type limitedReadSeeker struct {
+	io.ReadSeeker
+	n   int64
+	max int64
+}
+
+func (r *limitedReadSeeker) Read(p []byte) (int, error) {
+	if r.n >= r.max {
+		return 0, fmt.Errorf("%w: read more than %d bytes", ErrMaxTotalBytesExceeded, r.max)
+	}
+	if remaining := r.max - r.n; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := r.ReadSeeker.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
 type closerFunc func() error
 
 func (f closerFunc) Close() error {
@@ -111,6 +140,11 @@ func (e *streamReader) bufferedReader(length int64) (readerCloser, error) {
 
 	_, err := io.ReadFull(e.r, br.b)
 	if err != nil {
+		putBytesAndReader(br)
+		if err == io.EOF {
+			// We expected length bytes, so this is not a normal end of the stream.
+			err = io.ErrUnexpectedEOF
+		}
 		return nil, err
 	}
 
@@ -130,6 +164,42 @@ func (e *streamReader) bufferedReader(length int64) (readerCloser, error) {
 	}, nil
 }
 
+// bufferedReaderUpTo is like bufferedReader, but allows the stream to end
+// before length bytes are read. It returns the number of bytes read.
+func (e *streamReader) bufferedReaderUpTo(length int64) (readerCloser, int64, error) {
+	if length < 0 {
+		return nil, 0, newInvalidFormatErrorf("negative length")
+	}
+
+	br := getBytesAndReader(int(length))
+
+	n, err := io.ReadFull(e.r, br.b)
+	if err != nil && (err != io.ErrUnexpectedEOF || n == 0) {
+		putBytesAndReader(br)
+		if err == io.EOF {
+			// We expected at least some bytes, so this is not a normal end of the stream.
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+	br.b = br.b[:n]
+
+	var closer closerFunc = func() error {
+		putBytesAndReader(br)
+		return nil
+	}
+
+	br.r.Reset(br.b)
+
+	return struct {
+		io.ReadSeeker
+		io.Closer
+	}{
+		br.r,
+		closer,
+	}, int64(n), nil
+}
+
 func (e *streamReader) allocateBuf(length int) {
 	if length > cap(e.buf) {
 		e.buf = make([]byte, length)
@@ -141,6 +211,17 @@ func (e *streamReader) pos() int64 {
 	return n
 }
 
+// size returns the size of the stream, keeping the current position.
+func (e *streamReader) size() int64 {
+	pos := e.pos()
+	n, err := e.r.Seek(0, io.SeekEnd)
+	if err != nil {
+		e.stop(err)
+	}
+	e.seek(pos)
+	return n
+}
+
 func (e *streamReader) read1() uint8 {
 	return e.read1r(e.r)
 }
@@ -187,6 +268,12 @@ func (e *streamReader) read4sr(r io.Reader) int32 {
 	return int32(e.byteOrder.Uint32(e.buf[:n]))
 }
 
+func (e *streamReader) read8() uint64 {
+	const n = 8
+	e.readNIntoBuf(n)
+	return e.byteOrder.Uint64(e.buf[:n])
+}
+
 func (e *streamReader) read8r(r io.Reader) uint64 {
 	const n = 8
 	e.readNFromRIntoBuf(n, r)
@@ -293,3 +380,41 @@ func (e *streamReader) stop(err error) {
 	}
 	panic(errStop)
 }
+
+// imageStrip is the location of a part of an image in a stream.
+type imageStrip struct {
+	offset int64
+	length int64
+}
+
+// stripReader reads the strips of an image from r, in order.
+type stripReader struct {
+	r      io.ReadSeeker
+	strips []imageStrip
+	cur    io.Reader
+}
+
+func (s *stripReader) Read(p []byte) (int, error) {
+	for {
+		if s.cur == nil {
+			if len(s.strips) == 0 {
+				return 0, io.EOF
+			}
+			strip := s.strips[0]
+			s.strips = s.strips[1:]
+			if _, err := s.r.Seek(strip.offset, io.SeekStart); err != nil {
+				return 0, err
+			}
+			s.cur = io.LimitReader(s.r, strip.length)
+		}
+		n, err := s.cur.Read(p)
+		if err == io.EOF {
+			s.cur = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
@@ -9,15 +9,20 @@ import (
 	"io"
 	"math"
 	"path"
+	"strconv"
 	"strings"
 )
 
-var markerXMP = []byte("http://ns.adobe.com/xap/1.0/\x00")
+var (
+	markerXMP  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	markerEXIF = []byte("Exif\x00\x00")
+)
 
 const (
 	markerSOI             = 0xffd8
 	markerApp1EXIF        = 0xffe1
 	markerrApp1XMP        = 0xffe1
+	markerApp2            = 0xffe2
 	markerApp13           = 0xffed
 	markerSOS             = 0xffda
 	exifHeader            = 0x45786966
@@ -30,8 +35,25 @@ const (
 const (
 	xmpMarker  = 0x02bc // EXIF ApplicationNotes
 	iptcMarker = 0x83bb // EXIF IPTC-NAA
+
+	exifTagSubfileType     = 0x00fe
+	exifTagCompression     = 0x0103
+	exifTagMake            = 0x010f
+	exifTagSubIFDs         = 0x014a
+	exifTagStripOffsets    = 0x0111
+	exifTagStripByteCounts = 0x0117
+	exifTagImageWidth      = 0x0100
+	exifTagImageHeight     = 0x0101
+	exifTagThumbnailOffset = 0x0201
+	exifTagThumbnailLength = 0x0202
 )
 
+// previewTagNames are the names used for the strip tags in preview IFDs.
+var previewTagNames = map[uint16]string{
+	exifTagStripOffsets:    "PreviewImageStart",
+	exifTagStripByteCounts: "PreviewImageLength",
+}
+
 //go:generate stringer -type=exifType
 
 const (
@@ -47,6 +69,12 @@ const (
 	exifTypeSignedRat8     exifType = 10
 	exifTypeSignedFloat4   exifType = 11
 	exifTypeSignedDouble8  exifType = 12
+	exifTypeIFD4           exifType = 13
+
+	// The 8 byte types added in BigTIFF.
+	exifTypeUnsignedLong8 exifType = 16
+	exifTypeSignedLong8   exifType = 17
+	exifTypeIFD8          exifType = 18
 )
 
 // Used for +inf/-inf/nan. This is in line with Exiftool.
@@ -66,16 +94,23 @@ var exifTypeSize = map[exifType]uint32{
 	exifTypeSignedRat8:     8,
 	exifTypeSignedFloat4:   4,
 	exifTypeSignedDouble8:  8,
+	exifTypeIFD4:           4,
+	exifTypeUnsignedLong8:  8,
+	exifTypeSignedLong8:    8,
+	exifTypeIFD8:           8,
 }
 
-var (
-	exifFieldsAll   = map[uint16]string{}
-	exifIFDPointers = map[uint16]string{
-		0x8769: "ExifIFDP",
-		0x8825: "GPSInfoIFD",
-		0xa005: "InteroperabilityIFD",
-	}
-)
+var exifIFDPointers = map[uint16]string{
+	0x8769: "ExifIFDP",
+	0x8825: "GPSInfoIFD",
+	0xa005: "InteroperabilityIFD",
+}
+
+// isIFDPointerTag reports whether tagID points to one or more IFDs, including the SubIFDs.
+func isIFDPointerTag(tagID uint16) bool {
+	_, found := exifIFDPointers[tagID]
+	return found || tagID == exifTagSubIFDs
+}
 
 var (
 	exifConverters        = &vc{}
@@ -85,26 +120,61 @@ var (
 		"ShutterSpeedValue":       exifConverters.convertAPEXToSeconds,
 		"GPSLatitude":             exifConverters.convertDegreesToDecimal,
 		"GPSLongitude":            exifConverters.convertDegreesToDecimal,
+		"GPSDestLatitude":         exifConverters.convertDegreesToDecimal,
+		"GPSDestLongitude":        exifConverters.convertDegreesToDecimal,
+		"GPSMapDatum":             exifConverters.convertGPSMapDatum,
 		"GPSMeasureMode":          exifConverters.convertStringToInt,
 		"SubSecTimeDigitized":     exifConverters.convertStringToInt,
 		"SubSecTimeOriginal":      exifConverters.convertStringToInt,
 		"SubSecTime":              exifConverters.convertStringToInt,
 		"GPSSatellites":           exifConverters.convertStringToInt,
-		"GPSTimeStamp":            exifConverters.convertToTimestampString,
-		"GPSVersionID":            exifConverters.convertBytesToStringSpaceDelim,
-		"SubjectArea":             exifConverters.convertNumbersToSpaceLimited,
+		"GPSTimeStamp":            exifConverters.convertGPSTimeStamp,
+		"GPSVersionID":            exifConverters.convertBytesToStringDotDelim,
+		"ExifVersion":             exifConverters.convertBytesToString,
+		"FlashpixVersion":         exifConverters.convertBytesToString,
+		"InteropVersion":          exifConverters.convertBytesToString,
+		"SubjectArea":             exifConverters.convertSubjectArea,
 		"BitsPerSample":           exifConverters.convertNumbersToSpaceLimited,
 		"PageNumber":              exifConverters.convertNumbersToSpaceLimited,
 		"StripByteCounts":         exifConverters.convertNumbersToSpaceLimited,
 		"StripOffsets":            exifConverters.convertNumbersToSpaceLimited,
-		"PrimaryChromaticities":   exifConverters.convertRatsToSpaceLimited,
-		"WhitePoint":              exifConverters.convertRatsToSpaceLimited,
-		"ReferenceBlackWhite":     exifConverters.convertRatsToSpaceLimited,
-		"YCbCrCoefficients":       exifConverters.convertRatsToSpaceLimited,
+		"PrimaryChromaticities":   exifConverters.convertRats,
+		"WhitePoint":              exifConverters.convertRats,
+		"ReferenceBlackWhite":     exifConverters.convertRats,
+		"YCbCrCoefficients":       exifConverters.convertRats,
 		"ComponentsConfiguration": exifConverters.convertBytesToStringSpaceDelim,
 		"LensInfo":                exifConverters.convertRatsToSpaceLimited,
 		"Padding":                 exifConverters.convertBinaryData,
+		"ColorMatrix1":            exifConverters.convertColorMatrix,
+		"ColorMatrix2":            exifConverters.convertColorMatrix,
+		"ColorMatrix3":            exifConverters.convertColorMatrix,
+		"ForwardMatrix1":          exifConverters.convertForwardMatrix,
+		"ForwardMatrix2":          exifConverters.convertForwardMatrix,
+		"ForwardMatrix3":          exifConverters.convertForwardMatrix,
+		"AsShotNeutral":           exifConverters.convertRatsToFloat64s,
 		"UserComment":             exifConverters.convertUserComment,
+		"GPSProcessingMethod":     exifConverters.convertEncodedString,
+		"GPSAreaInformation":      exifConverters.convertEncodedString,
+		"ColorSpace":              exifConverters.convertEnumLabel(exifColorSpaceLabels),
+		"GPSDifferential":         exifConverters.convertEnumLabel(exifGPSDifferentialLabels),
+		"GPSStatus":               exifConverters.convertStringEnumLabel(exifGPSStatusLabels),
+		"Gamma":                   exifConverters.convertRatToFloat64,
+		"Rating":                  exifConverters.convertClamp(0, 5),
+		"RatingPercent":           exifConverters.convertClamp(0, 100),
+		"WhiteBalance":            exifConverters.convertWhiteBalance,
+		"OpcodeList1":             exifConverters.convertOpcodeList,
+		"OpcodeList2":             exifConverters.convertOpcodeList,
+		"OpcodeList3":             exifConverters.convertOpcodeList,
+		"PixelScale":              exifConverters.convertGeoTIFFDoubles,
+		"ModelTiePoint":           exifConverters.convertGeoTIFFDoubles,
+		"ModelTransform":          exifConverters.convertGeoTIFFDoubles,
+
+		// Binary data stored with the undefined type, passed on as []byte.
+		"PrintIM":                  exifConverters.convertKeepBytes,
+		"Opto-ElectricConvFactor":  exifConverters.convertKeepBytes,
+		"SpatialFrequencyResponse": exifConverters.convertKeepBytes,
+		"DeviceSettingDescription": exifConverters.convertKeepBytes,
+
 		"CFAPattern": func(ctx valueConverterContext, v any) any {
 			b := v.([]byte)
 			horizontalRepeat := ctx.s.byteOrder.Uint16(b[:2])
@@ -131,20 +201,24 @@ var (
 	}
 )
 
-func newMetaDecoderEXIF(r io.Reader, byteOrder binary.ByteOrder, thumbnailOffset int64, opts Options) *metaDecoderEXIF {
+func newMetaDecoderEXIF(r io.Reader, byteOrder binary.ByteOrder, thumbnailOffset int64, opts Options, result *DecodeResult) *metaDecoderEXIF {
 	s := newStreamReader(r, byteOrder)
-	return newMetaDecoderEXIFFromStreamReader(s, thumbnailOffset, opts)
+	return newMetaDecoderEXIFFromStreamReader(s, thumbnailOffset, opts, result)
 }
 
-func newMetaDecoderEXIFFromStreamReader(s *streamReader, thumbnailOffset int64, opts Options) *metaDecoderEXIF {
+func newMetaDecoderEXIFFromStreamReader(s *streamReader, thumbnailOffset int64, opts Options, result *DecodeResult) *metaDecoderEXIF {
 	return &metaDecoderEXIF{
+		result:          result,
 		thumbnailOffset: thumbnailOffset,
 		seenIFDs:        map[string]struct{}{},
 		streamReader:    s,
 		opts:            opts,
 		valueConverterCtx: valueConverterContext{
-			s:         s,
-			warnfFunc: opts.Warnf,
+			s:                s,
+			warnfFunc:        opts.Warnf,
+			decodeStructured: opts.DecodeStructured,
+			decodeEnumLabels: opts.DecodeEnumLabels,
+			decodeGeoTIFF:    opts.DecodeGeoTIFF,
 		},
 	}
 }
@@ -158,6 +232,39 @@ type metaDecoderEXIF struct {
 	seenIFDs          map[string]struct{}
 	valueConverterCtx valueConverterContext
 	opts              Options
+
+	// The camera make from IFD0, used to detect the MakerNote format.
+	make string
+
+	// Set when decoding a MakerNote IFD.
+	makerNote *makerNoteFormat
+
+	// Set if the preview image referenced by the MakerNote should be tracked,
+	// see trackMakerNotePreview.
+	makerNotePreview bool
+
+	// Where to store information found while decoding.
+	result *DecodeResult
+
+	// State for the IFD currently being decoded.
+	ifd ifdState
+
+	// The largest JPEG preview image found.
+	preview previewImage
+
+	// The offsets of the SubIFDs in IFD0 of a TIFF file, see decodeMetadataIFDs.
+	subIFDs []int64
+
+	// Set when an IPTC block has been decoded.
+	foundIPTC bool
+
+	// Set when decoding a BigTIFF file, where the entry counts,
+	// the value counts and the offsets in the IFDs are 8 bytes.
+	bigTIFF bool
+
+	// Scratch slice used by convertValues, reused for each tag.
+	// See ownValue.
+	values []any
 }
 
 func (e *metaDecoderEXIF) convertValue(typ exifType, r io.Reader) any {
@@ -184,8 +291,12 @@ func (e *metaDecoderEXIF) doConvertValue(typ exifType, r io.Reader) any {
 		return e.read1r(r)
 	case exifTypeUnsignedShort2, exifTypeSignedShort2:
 		return e.read2r(r)
-	case exifTypeUnsignedLong4:
+	case exifTypeUnsignedLong4, exifTypeIFD4:
 		return e.read4r(r)
+	case exifTypeUnsignedLong8, exifTypeIFD8:
+		return e.read8r(r)
+	case exifTypeSignedLong8:
+		return int64(e.read8r(r))
 	case exifTypeUnsignedRat8:
 		n, d := e.read4r(r), e.read4r(r)
 		if d == 0 {
@@ -201,6 +312,9 @@ func (e *metaDecoderEXIF) doConvertValue(typ exifType, r io.Reader) any {
 		return e.read4sr(r)
 	case exifTypeSignedRat8:
 		n, d := e.read4sr(r), e.read4sr(r)
+		if d == 0 {
+			return undef
+		}
 		r, err := NewRat[int32](n, d)
 		if err != nil {
 			e.opts.Warnf("failed to convert signed rational: %v", err)
@@ -222,39 +336,42 @@ func (e *metaDecoderEXIF) convertValues(typ exifType, count, len int, r io.Reade
 	}
 
 	if typ == exifTypeASCIIString1 {
+		// The count and the length are the same for ASCII, unless the value was cut short.
 		b := e.readBytesFromRVolatile(len, r)
-		return string(trimBytesNulls(b[:count]))
+		return string(trimBytesNulls(b))
 	}
 
 	if count == 1 {
 		return e.convertValue(typ, r)
 	}
 
-	values := make([]any, count)
-	allBytes := true
-	for i := 0; i < count; i++ {
-		v := e.convertValue(typ, r)
-		values[i] = v
-		if allBytes {
-			_, ok := v.(byte)
-			if !ok {
-				allBytes = false
-			}
-		}
+	switch typ {
+	case exifTypeUnsignedByte1, exifTypeUndef1, exifTypeSignedByte1:
+		// Read the bytes in one go instead of boxing each of them.
+		b := e.readBytesFromRVolatile(count, r)
+		return append([]byte(nil), b...)
 	}
 
-	if allBytes {
-		bs := make([]byte, count)
-		for i, v := range values {
-			b := v.(byte)
-			bs[i] = b
-		}
-		return bs
-
+	if cap(e.values) < count {
+		e.values = make([]any, count)
 	}
+	values := e.values[:count]
+	for i := range values {
+		values[i] = e.convertValue(typ, r)
+	}
+
 	return values
 }
 
+// ownValue makes sure that the value v passed on to HandleTag isn't overwritten by the next tag.
+// Any []any value may be backed by the scratch slice used by convertValues, so it's copied.
+func (e *metaDecoderEXIF) ownValue(v any) any {
+	if vals, ok := v.([]any); ok {
+		return append([]any(nil), vals...)
+	}
+	return v
+}
+
 func (e *metaDecoderEXIF) decode() (err error) {
 	e.readerOffset = e.pos()
 	byteOrderTag := e.read2()
@@ -283,6 +400,14 @@ func (e *metaDecoderEXIF) decode() (err error) {
 		return err
 	}
 
+	if e.opts.probe {
+		return nil
+	}
+
+	if !e.decodesIFD("IFD1") {
+		return nil
+	}
+
 	// Thumbnail IFD.
 	ifd1Offset := e.read4()
 	if ifd1Offset == 0 {
@@ -304,16 +429,26 @@ func (e *metaDecoderEXIF) decode() (err error) {
 //   - 4 bytes for the number of data values of the specified type
 //   - 4 bytes for the value itself, if it fits, otherwise for a pointer to another location where the data may be found;
 //     this could be a pointer to the beginning of another IFD.
+//
+// In BigTIFF, the last two fields are 8 bytes each.
 func (e *metaDecoderEXIF) decodeTag(namespace string) error {
 	tagID := e.read2()
 	dataType := e.read2()
-	count := e.read4()
+	count := e.readCount()
 	if count > 0x10000 {
-		e.skip(4)
+		e.skipValueField()
 		return nil
 	}
 
-	tagName := exifFieldsAll[tagID]
+	if e.makerNote != nil {
+		return e.decodeMakerNoteTag(namespace, tagID, exifType(dataType), count)
+	}
+
+	// The GPS tag IDs overlap with the low IFD0 tag IDs (e.g. ProcessingSoftware).
+	tagName := exifFields[tagID]
+	if path.Base(namespace) == "GPSInfoIFD" {
+		tagName = exifFieldsGPS[tagID]
+	}
 	if tagName == "" {
 		tagName = fmt.Sprintf("%s0x%x", UnknownPrefix, tagID)
 	}
@@ -325,6 +460,19 @@ func (e *metaDecoderEXIF) decodeTag(namespace string) error {
 
 	}
 
+	switch tagID {
+	case exifTagStripOffsets, exifTagStripByteCounts:
+		if e.isPreviewIFD() {
+			// E.g. the JPEG preview in IFD0 of a DNG file.
+			tagName = previewTagNames[tagID]
+		}
+	case exifTagMakerNote:
+		if e.opts.KeepMakerNoteRaw {
+			// Not one of the vendor specific names, as we don't know the format.
+			tagName = "MakerNote"
+		}
+	}
+
 	ifd, isIFDPointer := exifIFDPointers[tagID]
 	if isIFDPointer {
 		if _, ok := e.seenIFDs[ifd]; ok {
@@ -343,38 +491,39 @@ func (e *metaDecoderEXIF) decodeTag(namespace string) error {
 
 	if tagID == xmpMarker {
 		if !e.opts.Sources.Has(XMP) {
-			e.skip(4)
+			e.skipValueField()
 			return nil
 		}
 
-		valueOffset := e.read4()
+		e.result.addFoundSource(XMP)
+		valueOffset := e.readOffset()
 		return e.preservePos(func() error {
-			offset := valueOffset + uint32(e.readerOffset)
-			e.seek(int64(offset))
+			e.seek(e.valuePos(valueOffset))
 			r, err := e.bufferedReader(int64(valLen))
 			if err != nil {
 				return err
 			}
 			defer r.Close()
-			return decodeXMP(r, e.opts)
+			return decodeXMP(r, e.opts, e.result)
 		})
 
 	}
 
 	if tagID == iptcMarker {
 		if !e.opts.Sources.Has(IPTC) {
-			e.skip(4)
+			e.skipValueField()
 			return nil
 		}
-		valueOffset := e.read4()
+		e.result.addFoundSource(IPTC)
+		valueOffset := e.readOffset()
 		return e.preservePos(func() error {
-			offset := valueOffset + uint32(e.readerOffset)
-			e.seek(int64(offset))
+			e.seek(e.valuePos(valueOffset))
 			r, err := e.bufferedReader(int64(valLen))
 			if err != nil {
 				return err
 			}
 			defer r.Close()
+			e.foundIPTC = true
 			iptcDec := newMetaDecoderIPTC(r, e.opts)
 			return iptcDec.decodeRecords()
 		})
@@ -383,18 +532,417 @@ func (e *metaDecoderEXIF) decodeTag(namespace string) error {
 
 	// Below is EXIF
 	if !e.opts.Sources.Has(EXIF) {
-		e.skip(4)
+		if tagID == exifTagSubIFDs && e.isTrackedTag(namespace, tagID) {
+			// We still need the offsets to find any IPTC and XMP in the SubIFDs.
+			tagInfo := TagInfo{Source: EXIF, Tag: tagName, ID: tagID, Namespace: namespace}
+			return e.decodeTagValue(tagID, tagInfo, typ, count, valLen, func(val any) (any, bool, error) {
+				e.trackTagValue(tagID, val)
+				return nil, false, nil
+			})
+		}
+		e.skipValueField()
+		return nil
+	}
+
+	if isIFDPointer && e.opts.probe {
+		// We only need to know if there's GPS data.
+		if ifd == "GPSInfoIFD" && e.result != nil {
+			e.result.hasGPS = true
+		}
+		e.skipValueField()
+		return nil
+	}
+
+	if isIFDPointer && (e.skipIFD(ifd) || !e.decodesIFD(path.Join(namespace, ifd))) {
+		e.skipValueField()
 		return nil
 	}
 
+	if tagID == exifTagMakerNote && e.opts.DecodeMakerNotes && valLen > e.valueFieldSize() && e.decodesIFDsBelow(namespace) {
+		pos := e.pos()
+		handled, err := e.decodeMakerNote(namespace, e.readOffset())
+		if err != nil || (handled && !e.opts.KeepMakerNoteRaw) {
+			return err
+		}
+		// Unknown format or we want the raw bytes, handle it as a regular tag.
+		e.seek(pos)
+	}
+
+	if tagID == exifTagDNGPrivateData && e.opts.DecodeDNGPrivate && valLen > e.valueFieldSize() && e.decodesIFDsBelow(namespace) {
+		pos := e.pos()
+		handled, err := e.decodeDNGPrivateData(namespace, e.readOffset(), valLen)
+		if err != nil || handled {
+			return err
+		}
+		e.seek(pos)
+	}
+
 	tagInfo := TagInfo{
 		Source:    EXIF,
 		Tag:       tagName,
+		ID:        tagID,
 		Namespace: namespace,
 	}
 
-	if !isIFDPointer && !e.opts.ShouldHandleTag(tagInfo) {
-		e.skip(4)
+	if e.opts.CanonicalNamespaces && (namespace == exifNamespaceIFD0 || namespace == exifNamespaceExifIFD) {
+		// Some cameras write e.g. ExposureTime to IFD0.
+		if ns, found := exifFieldsCanonicalNamespace[tagID]; found {
+			tagInfo.Namespace = ns
+		}
+	}
+
+	isTracked := e.isTrackedTag(namespace, tagID)
+
+	// The SubIFDs offsets are only tracked, see decodeMetadataIFDs.
+	shouldHandle := isIFDPointer || (tagID != exifTagSubIFDs && e.handlesTagsIn(namespace) && e.opts.ShouldHandleTag(tagInfo))
+	if !shouldHandle && !isTracked {
+		e.skipValueField()
+		return nil
+	}
+
+	return e.decodeTagValue(tagID, tagInfo, typ, count, valLen, func(val any) (any, bool, error) {
+		if isTracked {
+			e.trackTagValue(tagID, val)
+		}
+		if !shouldHandle {
+			return nil, false, nil
+		}
+		if tagID == exifTagGeoKeyDirectory && e.opts.DecodeGeoTIFF {
+			// Passed on when the IFD is done, see handleGeoKeyDirectory.
+			e.ifd.geoTIFF.handleDirectory = true
+			return nil, false, nil
+		}
+		if isIFDPointer {
+			offset, ok := toOffset(val)
+			if !ok {
+				return nil, false, newInvalidFormatErrorf("invalid IFD pointer value: %v", val)
+			}
+			namespace := path.Join(namespace, ifd)
+			return nil, false, e.decodeTagsAt(namespace, offset)
+		}
+		return val, true, nil
+	})
+}
+
+// valueFieldSize returns the size of the value field in an IFD entry,
+// which holds either the value, if it fits, or the offset to it.
+func (e *metaDecoderEXIF) valueFieldSize() uint32 {
+	if e.bigTIFF {
+		return 8
+	}
+	return 4
+}
+
+// skipValueField skips the value field in an IFD entry.
+func (e *metaDecoderEXIF) skipValueField() {
+	e.skip(int64(e.valueFieldSize()))
+}
+
+// readCount reads the number of values in an IFD entry.
+// BigTIFF counts that don't fit in an uint32 are returned as math.MaxUint32.
+func (e *metaDecoderEXIF) readCount() uint32 {
+	if !e.bigTIFF {
+		return e.read4()
+	}
+	n := e.read8()
+	if n > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(n)
+}
+
+// readOffset reads an offset in the value field of an IFD entry.
+func (e *metaDecoderEXIF) readOffset() int64 {
+	if e.bigTIFF {
+		return int64(e.read8())
+	}
+	return int64(e.read4())
+}
+
+// valuePos returns the position of the value at valueOffset, which is relative to the TIFF header.
+func (e *metaDecoderEXIF) valuePos(valueOffset int64) int64 {
+	if !e.bigTIFF {
+		// The 32 bit offsets may wrap around, e.g. in a MakerNote moved by an editor (see OffsetSchema).
+		return int64(uint32(valueOffset) + uint32(e.readerOffset))
+	}
+	return valueOffset + e.readerOffset
+}
+
+// skipIFD reports whether the IFD pointer to ifd, e.g. GPSInfoIFD, should not be followed.
+func (e *metaDecoderEXIF) skipIFD(ifd string) bool {
+	for _, s := range e.opts.SkipIFDs {
+		if s == ifd {
+			return true
+		}
+	}
+	return false
+}
+
+// decodesIFD reports whether the IFD with the given namespace needs to be read,
+// i.e. it's Options.OnlyIFD or on the path to it, or OnlyIFD is not set.
+func (e *metaDecoderEXIF) decodesIFD(namespace string) bool {
+	return e.handlesTagsIn(namespace) || e.decodesIFDsBelow(namespace)
+}
+
+// decodesIFDsBelow reports whether any IFD below namespace, e.g. a MakerNote, needs to be read.
+func (e *metaDecoderEXIF) decodesIFDsBelow(namespace string) bool {
+	return e.opts.OnlyIFD == "" || strings.HasPrefix(e.opts.OnlyIFD, namespace+"/")
+}
+
+// handlesTagsIn reports whether the tags in the IFD with the given namespace should be passed on,
+// see Options.OnlyIFD.
+func (e *metaDecoderEXIF) handlesTagsIn(namespace string) bool {
+	return e.opts.OnlyIFD == "" || e.opts.OnlyIFD == namespace
+}
+
+// isTrackedTag reports whether we need the value of tagID to interpret other tags,
+// e.g. the camera make to detect the MakerNote format.
+func (e *metaDecoderEXIF) isTrackedTag(namespace string, tagID uint16) bool {
+	if namespace == "IFD1" && e.result != nil {
+		switch tagID {
+		case exifTagImageWidth, exifTagImageHeight, exifTagThumbnailOffset, exifTagThumbnailLength:
+			return true
+		}
+	}
+	if namespace == "IFD1" && e.opts.HandleThumbnail != nil {
+		switch tagID {
+		case exifTagThumbnailOffset, exifTagThumbnailLength, exifTagStripOffsets, exifTagStripByteCounts:
+			return true
+		}
+	}
+	switch tagID {
+	case exifTagMake:
+		return e.opts.DecodeMakerNotes || e.opts.DecodeDNGPrivate
+	case exifTagSubfileType, exifTagCompression:
+		return e.opts.ImageFormat == TIFF
+	case exifTagStripOffsets, exifTagStripByteCounts:
+		return e.opts.ImageFormat == TIFF && e.opts.HandlePreviewImage != nil
+	case exifTagGeoKeyDirectory, exifTagGeoDoubleParams, exifTagGeoASCIIParams:
+		return e.opts.DecodeGeoTIFF
+	case exifTagSubIFDs:
+		return namespace == "IFD0" && e.opts.ImageFormat == TIFF && (e.opts.Sources.Has(IPTC) || e.opts.Sources.Has(XMP))
+	}
+	return false
+}
+
+func (e *metaDecoderEXIF) trackTagValue(tagID uint16, val any) {
+	switch tagID {
+	case exifTagMake:
+		e.make = toString(val)
+	case exifTagSubfileType:
+		if v, ok := val.(uint32); ok {
+			e.ifd.subfileType = v
+		}
+	case exifTagCompression:
+		if v, ok := val.(uint16); ok {
+			e.ifd.compression = v
+		}
+	case exifTagStripOffsets:
+		// A JPEG preview is stored in one strip.
+		if v, ok := toUint32(val); ok {
+			e.ifd.stripOffset = v
+		}
+		e.ifd.stripOffsets = toUint32s(val)
+	case exifTagStripByteCounts:
+		if v, ok := toUint32(val); ok {
+			e.ifd.stripByteCount = v
+		}
+		e.ifd.stripByteCounts = toUint32s(val)
+	case exifTagImageWidth:
+		e.ifd.imageWidth, _ = toUint32(val)
+	case exifTagImageHeight:
+		e.ifd.imageHeight, _ = toUint32(val)
+	case exifTagThumbnailOffset:
+		e.ifd.thumbnailOffset, _ = toUint32(val)
+	case exifTagThumbnailLength:
+		e.ifd.thumbnailLength, _ = toUint32(val)
+	case exifTagGeoKeyDirectory, exifTagGeoDoubleParams, exifTagGeoASCIIParams:
+		e.trackGeoTIFFTagValue(tagID, val)
+	case exifTagSubIFDs:
+		vals, ok := val.([]any)
+		if !ok {
+			vals = []any{val}
+		}
+		e.subIFDs = e.subIFDs[:0]
+		for _, v := range vals {
+			if offset, ok := toOffset(v); ok {
+				e.subIFDs = append(e.subIFDs, offset)
+			}
+		}
+	}
+}
+
+// thumbnailConfig returns the dimensions of the thumbnail in the current IFD (IFD1).
+// If not set in the IFD, we look in the thumbnail JPEG.
+func (e *metaDecoderEXIF) thumbnailConfig() ImageConfig {
+	if e.ifd.imageWidth > 0 && e.ifd.imageHeight > 0 {
+		return ImageConfig{Width: int(e.ifd.imageWidth), Height: int(e.ifd.imageHeight)}
+	}
+	if e.ifd.thumbnailOffset == 0 || e.ifd.thumbnailLength == 0 {
+		return ImageConfig{}
+	}
+
+	var config ImageConfig
+	e.preservePos(func() error {
+		start := int64(e.ifd.thumbnailOffset) + e.readerOffset
+		end := start + int64(e.ifd.thumbnailLength)
+		e.seek(start)
+		config, _ = e.readJPEGConfig(end)
+		return nil
+	})
+	return config
+}
+
+// handleThumbnail passes the thumbnail in the current IFD (IFD1) to HandleThumbnail, if any.
+// This is either a JPEG (ThumbnailOffset and ThumbnailLength) or
+// an uncompressed image stored in strips (StripOffsets and StripByteCounts).
+func (e *metaDecoderEXIF) handleThumbnail() error {
+	var strips []imageStrip
+	if e.ifd.thumbnailOffset > 0 && e.ifd.thumbnailLength > 0 {
+		strips = append(strips, imageStrip{offset: int64(e.ifd.thumbnailOffset) + e.readerOffset, length: int64(e.ifd.thumbnailLength)})
+	} else if len(e.ifd.stripOffsets) > 0 && len(e.ifd.stripOffsets) == len(e.ifd.stripByteCounts) {
+		for i, offset := range e.ifd.stripOffsets {
+			strips = append(strips, imageStrip{offset: int64(offset) + e.readerOffset, length: int64(e.ifd.stripByteCounts[i])})
+		}
+	}
+	if len(strips) == 0 {
+		return nil
+	}
+	return e.preservePos(func() error {
+		return e.opts.HandleThumbnail(&stripReader{r: e.r, strips: strips})
+	})
+}
+
+// readJPEGConfig reads the image dimensions from the SOF segment of the JPEG at the current position.
+// Errors are returned, not panicked, as this is not needed to read the metadata.
+func (e *metaDecoderEXIF) readJPEGConfig(end int64) (ImageConfig, error) {
+	b, err := e.readBytesVolatileE(2)
+	if err != nil {
+		return ImageConfig{}, err
+	}
+	if binary.BigEndian.Uint16(b) != markerSOI {
+		return ImageConfig{}, errInvalidFormat
+	}
+
+	for e.pos() < end {
+		b, err := e.readBytesVolatileE(4)
+		if err != nil {
+			return ImageConfig{}, err
+		}
+		marker, length := binary.BigEndian.Uint16(b), binary.BigEndian.Uint16(b[2:])
+		if marker>>8 != 0xff || marker == markerSOS || length < 2 {
+			break
+		}
+		switch marker {
+		case 0xffc0, 0xffc1, 0xffc2, 0xffc3, 0xffc5, 0xffc6, 0xffc7, 0xffc9, 0xffca, 0xffcb, 0xffcd, 0xffce, 0xffcf:
+			// SOFn: precision (1), height (2), width (2).
+			b, err := e.readBytesVolatileE(5)
+			if err != nil {
+				return ImageConfig{}, err
+			}
+			return ImageConfig{
+				Width:  int(binary.BigEndian.Uint16(b[3:])),
+				Height: int(binary.BigEndian.Uint16(b[1:])),
+			}, nil
+		}
+		e.seek(e.pos() + int64(length) - 2)
+	}
+
+	return ImageConfig{}, errInvalidFormat
+}
+
+// isPreviewIFD reports whether the current IFD is a JPEG compressed reduced-resolution image in a TIFF file.
+func (e *metaDecoderEXIF) isPreviewIFD() bool {
+	return e.opts.ImageFormat == TIFF && e.ifd.subfileType&1 != 0 && (e.ifd.compression == 6 || e.ifd.compression == 7)
+}
+
+// ifdState holds the tag values of an IFD needed to interpret other tags in the same IFD.
+type ifdState struct {
+	subfileType    uint32
+	compression    uint16
+	stripOffset    uint32
+	stripByteCount uint32
+
+	// All the strips, e.g. of an uncompressed thumbnail.
+	stripOffsets    []uint32
+	stripByteCounts []uint32
+
+	imageWidth      uint32
+	imageHeight     uint32
+	thumbnailOffset uint32
+	thumbnailLength uint32
+
+	// The position and number of the IFD entries.
+	entriesStart int64
+	numEntries   int
+
+	geoTIFF geoTIFFState
+}
+
+// previewImage is the location of an embedded JPEG preview.
+// The offset is the position in Options.R, i.e. rebased to the start of the file.
+type previewImage struct {
+	offset int64
+	length uint32
+}
+
+// decodeMakerNoteTag decodes a tag in a MakerNote IFD.
+func (e *metaDecoderEXIF) decodeMakerNoteTag(namespace string, tagID uint16, typ exifType, count uint32) error {
+	size, ok := exifTypeSize[typ]
+	if !ok {
+		// Some vendors (e.g. Panasonic) use nonstandard types for a few entries.
+		// Skip them instead of failing the whole MakerNote.
+		e.skipValueField()
+		return nil
+	}
+
+	tagInfo := TagInfo{
+		Source:    EXIF,
+		Tag:       e.makerNote.tagName(tagID),
+		ID:        tagID,
+		Namespace: namespace,
+	}
+
+	isPreview := e.makerNotePreview && e.makerNote.previewTag != 0 && tagID == e.makerNote.previewTag
+	shouldHandle := e.handlesTagsIn(namespace) && e.opts.ShouldHandleTag(tagInfo)
+	if !shouldHandle && !isPreview {
+		e.skipValueField()
+		return nil
+	}
+
+	return e.decodeTagValue(tagID, tagInfo, typ, count, size*count, func(val any) (any, bool, error) {
+		if isPreview {
+			e.trackMakerNotePreview(val)
+		}
+		return val, shouldHandle, nil
+	})
+}
+
+// trackMakerNotePreview stores the location of the preview image in val,
+// the value of the MakerNote's previewTag, if it's the largest found.
+func (e *metaDecoderEXIF) trackMakerNotePreview(val any) {
+	f := e.makerNote
+	vals := toUint32s(val)
+	if f.previewStartIndex >= len(vals) || f.previewLengthIndex >= len(vals) {
+		return
+	}
+	start, length := vals[f.previewStartIndex], vals[f.previewLengthIndex]
+	if start == 0 || length <= e.preview.length {
+		return
+	}
+	// The offsets are relative to the same origin as the other offsets in the MakerNote.
+	e.preview = previewImage{offset: int64(start) + e.readerOffset + e.thumbnailOffset, length: length}
+}
+
+// decodeTagValue reads the value of the current tag, applies any value converter and passes it to HandleTag.
+// The handle func may be used to intercept the raw value; if it returns false, the tag is not passed on.
+func (e *metaDecoderEXIF) decodeTagValue(tagID uint16, tagInfo TagInfo, typ exifType, count, valLen uint32, handle func(val any) (any, bool, error)) error {
+	tagName := tagInfo.Tag
+
+	if count == 0 {
+		// There's no value, but the value field is always there.
+		// Treat it as absent instead of passing an empty value through the converters.
+		e.skipValueField()
 		return nil
 	}
 
@@ -402,13 +950,29 @@ func (e *metaDecoderEXIF) decodeTag(namespace string) error {
 
 	if err := func() error {
 		var r io.Reader = e.r
-		if valLen > 4 {
-			valueOffset := e.read4()
-			offset := valueOffset + uint32(e.readerOffset)
+		fieldSize := e.valueFieldSize()
+		if valLen > fieldSize {
+			valueOffset := e.readOffset()
 			oldPos := e.pos()
 			defer e.seek(oldPos)
-			e.seek(int64(offset))
-			rc, err := e.bufferedReader(int64(valLen))
+			e.seek(e.valuePos(valueOffset))
+			var (
+				rc  readerCloser
+				err error
+			)
+			if typ == exifTypeASCIIString1 {
+				// Some writers include a NUL terminator in the count that isn't there,
+				// so the value may run one byte past the end of the data.
+				var n int64
+				rc, n, err = e.bufferedReaderUpTo(int64(valLen))
+				if err == nil && n < int64(valLen)-1 {
+					rc.Close()
+					err = io.ErrUnexpectedEOF
+				}
+				valLen = uint32(n)
+			} else {
+				rc, err = e.bufferedReader(int64(valLen))
+			}
 			if err != nil {
 				return err
 			}
@@ -418,8 +982,8 @@ func (e *metaDecoderEXIF) decodeTag(namespace string) error {
 
 		val = e.convertValues(typ, int(count), int(valLen), r)
 
-		if valLen <= 4 {
-			padding := 4 - valLen
+		if valLen <= fieldSize {
+			padding := fieldSize - valLen
 			if padding > 0 {
 				e.skip(int64(padding))
 			}
@@ -429,22 +993,26 @@ func (e *metaDecoderEXIF) decodeTag(namespace string) error {
 		return err
 	}
 
-	if isIFDPointer {
-		offset, ok := val.(uint32)
-		if !ok {
-			return newInvalidFormatErrorf("invalid IFD pointer value: %v", val)
-		}
-		namespace := path.Join(namespace, ifd)
-		return e.decodeTagsAt(namespace, int64(offset))
+	val, ok, err := handle(val)
+	if err != nil || !ok {
+		return err
+	}
+
+	if isIFDPointerTag(tagID) && e.makerNote == nil {
+		// IFD pointers are followed or tracked, never passed on.
+		return fmt.Errorf("%w: %s", errIFDPointerHandled, tagName)
 	}
 
-	if convert, found := exifValueConverterMap[tagName]; found {
+	// Pass the raw MakerNote bytes on as is if requested.
+	keepRaw := tagID == exifTagMakerNote && e.makerNote == nil && e.opts.KeepMakerNoteRaw
+
+	if convert, found := exifValueConverterMap[tagName]; found && !keepRaw {
 		e.valueConverterCtx.tagName = tagName
 		val = convert(e.valueConverterCtx, val)
 		if f, ok := val.(float64); ok && isUndefined(f) {
 			val = undef
 		}
-	} else {
+	} else if !keepRaw {
 		val = toPrintableValue(val)
 	}
 
@@ -454,10 +1022,21 @@ func (e *metaDecoderEXIF) decodeTag(namespace string) error {
 
 	if tagName == tagNameThumbnailOffset {
 		// When set, thumbnailOffset is set to the offset of the EXIF data in the original file.
-		val = val.(uint32) + uint32(e.readerOffset+e.thumbnailOffset)
+		offset := e.readerOffset + e.thumbnailOffset
+		switch v := val.(type) {
+		case uint64:
+			// BigTIFF.
+			val = v + uint64(offset)
+		default:
+			if v, ok := toUint32(v); ok {
+				val = v + uint32(offset)
+			} else {
+				e.opts.Warnf("%s: expected an unsigned integer, got %T", tagName, v)
+			}
+		}
 	}
 
-	tagInfo.Value = val
+	tagInfo.Value = e.ownValue(val)
 
 	if err := e.opts.HandleTag(tagInfo); err != nil {
 		return err
@@ -467,14 +1046,47 @@ func (e *metaDecoderEXIF) decodeTag(namespace string) error {
 }
 
 func (e *metaDecoderEXIF) decodeTags(namespace string) error {
-	numTags := e.read2()
+	parent := e.ifd
+	e.ifd = ifdState{}
+	defer func() {
+		e.ifd = parent
+	}()
+
+	var numTags int
+	if e.bigTIFF {
+		numTags = int(e.read8())
+	} else {
+		numTags = int(e.read2())
+	}
+	e.ifd.entriesStart = e.pos()
+	e.ifd.numEntries = numTags
 
-	for i := 0; i < int(numTags); i++ {
+	for i := 0; i < numTags; i++ {
 		if err := e.decodeTag(namespace); err != nil {
 			return err
 		}
 	}
 
+	if e.ifd.geoTIFF.handleDirectory {
+		if err := e.handleGeoKeyDirectory(namespace); err != nil {
+			return err
+		}
+	}
+
+	if e.isPreviewIFD() && e.ifd.stripByteCount > e.preview.length {
+		e.preview = previewImage{offset: int64(e.ifd.stripOffset) + e.readerOffset + e.thumbnailOffset, length: e.ifd.stripByteCount}
+	}
+
+	if namespace == "IFD1" && e.result != nil {
+		e.result.ThumbnailConfig = e.thumbnailConfig()
+	}
+
+	if namespace == "IFD1" && e.opts.HandleThumbnail != nil {
+		if err := e.handleThumbnail(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -486,10 +1098,75 @@ func (e *metaDecoderEXIF) decodeTagsAt(namespace string, offset int64) error {
 		})
 }
 
+// decodeMetadataIFDs decodes the IPTC and XMP in the IFDs following IFD0 in a TIFF file
+// (IFD1, IFD2 ...) and in the SubIFDs of IFD0, where some tools put them.
+// The EXIF tags in these IFDs are skipped.
+// This must be called right after IFD0 at ifd0Offset is decoded.
+func (e *metaDecoderEXIF) decodeMetadataIFDs(ifd0Offset int64) error {
+	// Guards against loops and crafted files.
+	const maxIFDs = 256
+
+	sources := e.opts.Sources
+	e.opts.Sources = sources.Remove(EXIF)
+	defer func() {
+		e.opts.Sources = sources
+	}()
+
+	// As in the other formats, only the first IPTC block is decoded unless AllowDuplicateBlocks is set.
+	// All XMP packets are decoded.
+	done := func() bool {
+		if e.foundIPTC && !e.opts.AllowDuplicateBlocks {
+			e.opts.Sources = e.opts.Sources.Remove(IPTC)
+		}
+		return e.opts.Sources.IsZero()
+	}
+
+	seen := map[int64]bool{ifd0Offset: true}
+	for i := 1; i < maxIFDs && !done(); i++ {
+		offset := e.readOffset()
+		if offset == 0 || seen[offset] {
+			break
+		}
+		seen[offset] = true
+		e.seek(offset + e.readerOffset)
+		if err := e.decodeTags(fmt.Sprintf("IFD%d", i)); err != nil {
+			return err
+		}
+	}
+
+	for i, offset := range e.subIFDs {
+		if i >= maxIFDs || done() {
+			break
+		}
+		if seen[offset] {
+			continue
+		}
+		seen[offset] = true
+		namespace := "IFD0/SubIFD"
+		if i > 0 {
+			namespace += strconv.Itoa(i)
+		}
+		if err := e.decodeTagsAt(namespace, offset); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 type valueConverterContext struct {
 	tagName   string
 	s         *streamReader
 	warnfFunc func(string, ...any)
+
+	// Whether to convert to structured values (e.g. SubjectArea) where supported.
+	decodeStructured bool
+
+	// Whether to convert enumerated values to their labels (e.g. ColorSpace) where supported.
+	decodeEnumLabels bool
+
+	// Whether to convert the GeoTIFF tags to structured values.
+	decodeGeoTIFF bool
 }
 
 func (ctx valueConverterContext) warnf(format string, args ...any) {
@@ -500,16 +1177,11 @@ func (ctx valueConverterContext) warnf(format string, args ...any) {
 type valueConverter func(valueConverterContext, any) any
 
 func init() {
-	for k, v := range exifFields {
-		exifFieldsAll[k] = v
-	}
-	for k, v := range exifFieldsGPS {
-		exifFieldsAll[k] = v
-	}
-
-	for k := range exifFieldsAll {
-		if k > maxEXIFField {
-			maxEXIFField = k
+	for _, fields := range []map[uint16]string{exifFields, exifFieldsGPS} {
+		for k := range fields {
+			if k > maxEXIFField {
+				maxEXIFField = k
+			}
 		}
 	}
 }
@@ -630,3 +630,119 @@ var exifFieldsGPS = map[uint16]string{
 	0x001e: "GPSDifferential",
 	0x001f: "GPSHPositioningError",
 }
+
+const (
+	exifNamespaceIFD0    = "IFD0"
+	exifNamespaceExifIFD = "IFD0/ExifIFDP"
+)
+
+// exifFieldsCanonicalNamespace maps the tags that belong in either IFD0 or the Exif IFD
+// to the namespace they are reported in by exiftool.
+// Tags not listed here may appear in both.
+// Source: https://exiftool.org/TagNames/EXIF.html
+var exifFieldsCanonicalNamespace = map[uint16]string{
+	0x010e: exifNamespaceIFD0, // ImageDescription
+	0x010f: exifNamespaceIFD0, // Make
+	0x0110: exifNamespaceIFD0, // Model
+	0x0112: exifNamespaceIFD0, // Orientation
+	0x011a: exifNamespaceIFD0, // XResolution
+	0x011b: exifNamespaceIFD0, // YResolution
+	0x0128: exifNamespaceIFD0, // ResolutionUnit
+	0x0131: exifNamespaceIFD0, // Software
+	0x0132: exifNamespaceIFD0, // ModifyDate
+	0x013b: exifNamespaceIFD0, // Artist
+	0x013c: exifNamespaceIFD0, // HostComputer
+	0x013e: exifNamespaceIFD0, // WhitePoint
+	0x013f: exifNamespaceIFD0, // PrimaryChromaticities
+	0x0211: exifNamespaceIFD0, // YCbCrCoefficients
+	0x0213: exifNamespaceIFD0, // YCbCrPositioning
+	0x0214: exifNamespaceIFD0, // ReferenceBlackWhite
+	0x8298: exifNamespaceIFD0, // Copyright
+	0x9c9b: exifNamespaceIFD0, // XPTitle
+	0x9c9c: exifNamespaceIFD0, // XPComment
+	0x9c9d: exifNamespaceIFD0, // XPAuthor
+	0x9c9e: exifNamespaceIFD0, // XPKeywords
+	0x9c9f: exifNamespaceIFD0, // XPSubject
+
+	0x829a: exifNamespaceExifIFD, // ExposureTime
+	0x829d: exifNamespaceExifIFD, // FNumber
+	0x8822: exifNamespaceExifIFD, // ExposureProgram
+	0x8824: exifNamespaceExifIFD, // SpectralSensitivity
+	0x8827: exifNamespaceExifIFD, // ISO
+	0x8828: exifNamespaceExifIFD, // Opto-ElectricConvFactor
+	0x8830: exifNamespaceExifIFD, // SensitivityType
+	0x8831: exifNamespaceExifIFD, // StandardOutputSensitivity
+	0x8832: exifNamespaceExifIFD, // RecommendedExposureIndex
+	0x8833: exifNamespaceExifIFD, // ISOSpeed
+	0x8834: exifNamespaceExifIFD, // ISOSpeedLatitudeyyy
+	0x8835: exifNamespaceExifIFD, // ISOSpeedLatitudezzz
+	0x9000: exifNamespaceExifIFD, // ExifVersion
+	0x9003: exifNamespaceExifIFD, // DateTimeOriginal
+	0x9004: exifNamespaceExifIFD, // CreateDate
+	0x9010: exifNamespaceExifIFD, // OffsetTime
+	0x9011: exifNamespaceExifIFD, // OffsetTimeOriginal
+	0x9012: exifNamespaceExifIFD, // OffsetTimeDigitized
+	0x9101: exifNamespaceExifIFD, // ComponentsConfiguration
+	0x9102: exifNamespaceExifIFD, // CompressedBitsPerPixel
+	0x9201: exifNamespaceExifIFD, // ShutterSpeedValue
+	0x9202: exifNamespaceExifIFD, // ApertureValue
+	0x9203: exifNamespaceExifIFD, // BrightnessValue
+	0x9204: exifNamespaceExifIFD, // ExposureCompensation
+	0x9205: exifNamespaceExifIFD, // MaxApertureValue
+	0x9206: exifNamespaceExifIFD, // SubjectDistance
+	0x9207: exifNamespaceExifIFD, // MeteringMode
+	0x9208: exifNamespaceExifIFD, // LightSource
+	0x9209: exifNamespaceExifIFD, // Flash
+	0x920a: exifNamespaceExifIFD, // FocalLength
+	0x9214: exifNamespaceExifIFD, // SubjectArea
+	0x927c: exifNamespaceExifIFD, // MakerNote
+	0x9286: exifNamespaceExifIFD, // UserComment
+	0x9290: exifNamespaceExifIFD, // SubSecTime
+	0x9291: exifNamespaceExifIFD, // SubSecTimeOriginal
+	0x9292: exifNamespaceExifIFD, // SubSecTimeDigitized
+	0x9400: exifNamespaceExifIFD, // AmbientTemperature
+	0x9401: exifNamespaceExifIFD, // Humidity
+	0x9402: exifNamespaceExifIFD, // Pressure
+	0x9403: exifNamespaceExifIFD, // WaterDepth
+	0x9404: exifNamespaceExifIFD, // Acceleration
+	0x9405: exifNamespaceExifIFD, // CameraElevationAngle
+	0xa000: exifNamespaceExifIFD, // FlashpixVersion
+	0xa001: exifNamespaceExifIFD, // ColorSpace
+	0xa002: exifNamespaceExifIFD, // ExifImageWidth
+	0xa003: exifNamespaceExifIFD, // ExifImageHeight
+	0xa004: exifNamespaceExifIFD, // RelatedSoundFile
+	0xa20b: exifNamespaceExifIFD, // FlashEnergy
+	0xa20c: exifNamespaceExifIFD, // SpatialFrequencyResponse
+	0xa20e: exifNamespaceExifIFD, // FocalPlaneXResolution
+	0xa20f: exifNamespaceExifIFD, // FocalPlaneYResolution
+	0xa210: exifNamespaceExifIFD, // FocalPlaneResolutionUnit
+	0xa214: exifNamespaceExifIFD, // SubjectLocation
+	0xa215: exifNamespaceExifIFD, // ExposureIndex
+	0xa217: exifNamespaceExifIFD, // SensingMethod
+	0xa300: exifNamespaceExifIFD, // FileSource
+	0xa301: exifNamespaceExifIFD, // SceneType
+	0xa302: exifNamespaceExifIFD, // CFAPattern
+	0xa401: exifNamespaceExifIFD, // CustomRendered
+	0xa402: exifNamespaceExifIFD, // ExposureMode
+	0xa403: exifNamespaceExifIFD, // WhiteBalance
+	0xa404: exifNamespaceExifIFD, // DigitalZoomRatio
+	0xa405: exifNamespaceExifIFD, // FocalLengthIn35mmFormat
+	0xa406: exifNamespaceExifIFD, // SceneCaptureType
+	0xa407: exifNamespaceExifIFD, // GainControl
+	0xa408: exifNamespaceExifIFD, // Contrast
+	0xa409: exifNamespaceExifIFD, // Saturation
+	0xa40a: exifNamespaceExifIFD, // Sharpness
+	0xa40b: exifNamespaceExifIFD, // DeviceSettingDescription
+	0xa40c: exifNamespaceExifIFD, // SubjectDistanceRange
+	0xa420: exifNamespaceExifIFD, // ImageUniqueID
+	0xa430: exifNamespaceExifIFD, // OwnerName
+	0xa431: exifNamespaceExifIFD, // SerialNumber
+	0xa432: exifNamespaceExifIFD, // LensInfo
+	0xa433: exifNamespaceExifIFD, // LensMake
+	0xa434: exifNamespaceExifIFD, // LensModel
+	0xa435: exifNamespaceExifIFD, // LensSerialNumber
+	0xa460: exifNamespaceExifIFD, // CompositeImage
+	0xa461: exifNamespaceExifIFD, // CompositeImageCount
+	0xa462: exifNamespaceExifIFD, // CompositeImageExposureTimes
+	0xa500: exifNamespaceExifIFD, // Gamma
+}
@@ -0,0 +1,183 @@
+// Copyright 2024 Bjørn Erik Pedersen
+// SPDX-License-Identifier: MIT
+
+package imagemeta
+
+import (
+	"fmt"
+	"strings"
+)
+
+// See http://geotiff.maptools.org/spec/geotiff2.4.html
+const (
+	exifTagGeoKeyDirectory = 0x87af
+	exifTagGeoDoubleParams = 0x87b0
+	exifTagGeoASCIIParams  = 0x87b1
+)
+
+// geoKeyNames are the names of the GeoKeys, as used by exiftool.
+// See https://exiftool.org/TagNames/GeoTiff.html
+var geoKeyNames = map[uint16]string{
+	1024: "GTModelType",
+	1025: "GTRasterType",
+	1026: "GTCitation",
+	2048: "GeographicType",
+	2049: "GeogCitation",
+	2050: "GeogGeodeticDatum",
+	2051: "GeogPrimeMeridian",
+	2052: "GeogLinearUnits",
+	2053: "GeogLinearUnitSize",
+	2054: "GeogAngularUnits",
+	2055: "GeogAngularUnitSize",
+	2056: "GeogEllipsoid",
+	2057: "GeogSemiMajorAxis",
+	2058: "GeogSemiMinorAxis",
+	2059: "GeogInvFlattening",
+	2060: "GeogAzimuthUnits",
+	2061: "GeogPrimeMeridianLong",
+	3072: "ProjectedCSType",
+	3073: "PCSCitation",
+	3074: "Projection",
+	3075: "ProjCoordTrans",
+	3076: "ProjLinearUnits",
+	3077: "ProjLinearUnitSize",
+	3078: "ProjStdParallel1",
+	3079: "ProjStdParallel2",
+	3080: "ProjNatOriginLong",
+	3081: "ProjNatOriginLat",
+	3082: "ProjFalseEasting",
+	3083: "ProjFalseNorthing",
+	3084: "ProjFalseOriginLong",
+	3085: "ProjFalseOriginLat",
+	3086: "ProjFalseOriginEasting",
+	3087: "ProjFalseOriginNorthing",
+	3088: "ProjCenterLong",
+	3089: "ProjCenterLat",
+	3090: "ProjCenterEasting",
+	3091: "ProjCenterNorthing",
+	3092: "ProjScaleAtNatOrigin",
+	3093: "ProjScaleAtCenter",
+	3094: "ProjAzimuthAngle",
+	3095: "ProjStraightVertPoleLong",
+	4096: "VerticalCSType",
+	4097: "VerticalCitation",
+	4098: "VerticalDatum",
+	4099: "VerticalUnits",
+}
+
+// geoTIFFState holds the raw values of the GeoTIFF tags in an IFD.
+type geoTIFFState struct {
+	// Set if the GeoKeyDirectory tag should be passed to HandleTag.
+	handleDirectory bool
+
+	directory    []uint16
+	doubleParams []float64
+	asciiParams  string
+}
+
+// trackGeoTIFFTagValue stores the raw value of one of the GeoTIFF tags.
+func (e *metaDecoderEXIF) trackGeoTIFFTagValue(tagID uint16, val any) {
+	vals, ok := val.([]any)
+	if !ok {
+		vals = []any{val}
+	}
+	switch tagID {
+	case exifTagGeoKeyDirectory:
+		e.ifd.geoTIFF.directory = make([]uint16, 0, len(vals))
+		for _, v := range vals {
+			n, _ := v.(uint16)
+			e.ifd.geoTIFF.directory = append(e.ifd.geoTIFF.directory, n)
+		}
+	case exifTagGeoDoubleParams:
+		e.ifd.geoTIFF.doubleParams = make([]float64, len(vals))
+		for i, v := range vals {
+			e.ifd.geoTIFF.doubleParams[i] = toFloat64(v)
+		}
+	case exifTagGeoASCIIParams:
+		e.ifd.geoTIFF.asciiParams = toString(val)
+	}
+}
+
+// handleGeoKeyDirectory passes the GeoKeyDirectory tag in the IFD to HandleTag
+// as a map from GeoKey name to value, resolving the values stored in the
+// GeoTiffDoubleParams and GeoTiffAsciiParams tags.
+// This is done when the IFD is decoded, as these usually follow the directory.
+func (e *metaDecoderEXIF) handleGeoKeyDirectory(namespace string) error {
+	dir := e.ifd.geoTIFF.directory
+	if len(dir) < 4 {
+		e.opts.Warnf("GeoTiffDirectory: invalid header")
+		return nil
+	}
+	numKeys := int(dir[3])
+	if len(dir) < 4+numKeys*4 {
+		e.opts.Warnf("GeoTiffDirectory: expected %d keys, got %d", numKeys, (len(dir)-4)/4)
+		numKeys = (len(dir) - 4) / 4
+	}
+
+	keys := make(map[string]any, numKeys)
+	for i := 0; i < numKeys; i++ {
+		entry := dir[4+i*4:]
+		keyID, location, count, valueOffset := entry[0], entry[1], int(entry[2]), int(entry[3])
+		name, found := geoKeyNames[keyID]
+		if !found {
+			name = fmt.Sprintf("GeoKey%d", keyID)
+		}
+
+		switch location {
+		case 0:
+			// The value is stored in the entry.
+			keys[name] = entry[3]
+		case exifTagGeoDoubleParams:
+			params := e.ifd.geoTIFF.doubleParams
+			if valueOffset+count > len(params) {
+				e.opts.Warnf("GeoTiffDirectory: %s: invalid double params offset %d", name, valueOffset)
+				continue
+			}
+			if count == 1 {
+				keys[name] = params[valueOffset]
+			} else {
+				keys[name] = params[valueOffset : valueOffset+count]
+			}
+		case exifTagGeoASCIIParams:
+			params := e.ifd.geoTIFF.asciiParams
+			if valueOffset+count > len(params) {
+				e.opts.Warnf("GeoTiffDirectory: %s: invalid ASCII params offset %d", name, valueOffset)
+				continue
+			}
+			// Each string is terminated by a pipe.
+			keys[name] = strings.TrimSuffix(params[valueOffset:valueOffset+count], "|")
+		default:
+			e.opts.Warnf("GeoTiffDirectory: %s: unsupported location %d", name, location)
+		}
+	}
+
+	return e.opts.HandleTag(TagInfo{
+		Source:    EXIF,
+		Tag:       exifFields[exifTagGeoKeyDirectory],
+		ID:        exifTagGeoKeyDirectory,
+		Namespace: namespace,
+		Value:     keys,
+	})
+}
+
+// convertGeoTIFFDoubles converts e.g. PixelScale to []float64 if decodeGeoTIFF is set.
+func (c vc) convertGeoTIFFDoubles(ctx valueConverterContext, v any) any {
+	if !ctx.decodeGeoTIFF {
+		return v
+	}
+	vals, ok := v.([]any)
+	if !ok {
+		// A single value.
+		vals = []any{v}
+	}
+	floats := make([]float64, len(vals))
+	for i, vv := range vals {
+		f, ok := vv.(float64)
+		if !ok {
+			ctx.warnf("expected a double, got %T", vv)
+			return floats[:0]
+		}
+		floats[i] = f
+	}
+	return floats
+}
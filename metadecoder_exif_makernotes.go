@@ -0,0 +1,613 @@
+// Copyright 2024 Bjørn Erik Pedersen
+// SPDX-License-Identifier: MIT
+
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strings"
+)
+
+const (
+	exifTagMakerNote      = 0x927c
+	exifTagOffsetSchema   = 0xea1d
+	exifTagDNGPrivateData = 0xc634
+)
+
+// makerNoteFormat describes a vendor specific MakerNote stored as a plain IFD.
+type makerNoteFormat struct {
+	// The name of the vendor, used as the tag name prefix (e.g. "Apple.") and namespace.
+	name string
+
+	// The tag names.
+	fields map[uint16]string
+
+	// Reports whether the MakerNote starting with header b written by a camera from cameraMake is of this format.
+	match func(cameraMake string, b []byte) bool
+
+	// The length of the header before the IFD starts.
+	headerLen int64
+
+	// The byte order used in the MakerNote.
+	// If nil, the byte order of the EXIF block is used.
+	byteOrder binary.ByteOrder
+
+	// Whether the offsets in the IFD are relative to the start of the MakerNote
+	// or, if false, to the start of the TIFF header of the EXIF block.
+	relative bool
+
+	// The tag holding the location of a JPEG preview image, if any,
+	// and the indexes of its offset and length in the tag's array value.
+	previewTag         uint16
+	previewStartIndex  int
+	previewLengthIndex int
+}
+
+// The longest header we need to look at to detect the format.
+const makerNoteMaxHeaderLen = 16
+
+var makerNoteFormats = []*makerNoteFormat{
+	makerNoteApple,
+	makerNoteCanon,
+	makerNotePanasonic,
+	makerNoteLeica,
+}
+
+// See https://exiftool.org/TagNames/Apple.html
+var makerNoteApple = &makerNoteFormat{
+	name: "Apple",
+	fields: map[uint16]string{
+		0x0001: "MakerNoteVersion",
+		0x0002: "AEMatrix",
+		0x0003: "RunTime",
+		0x0004: "AEStable",
+		0x0005: "AETarget",
+		0x0006: "AEAverage",
+		0x0007: "AFStable",
+		0x0008: "AccelerationVector",
+		0x000a: "HDRImageType",
+		0x000b: "BurstUUID",
+		0x000c: "FocusDistanceRange",
+		0x000f: "OISMode",
+		0x0011: "ContentIdentifier",
+		0x0014: "ImageCaptureType",
+		0x0015: "ImageUniqueID",
+		0x0017: "LivePhotoVideoIndex",
+		0x0019: "ImageProcessingFlags",
+		0x001a: "QualityHint",
+		0x001d: "LuminanceNoiseAmplitude",
+		0x001f: "PhotosAppFeatureFlags",
+		0x0020: "ImageCaptureRequestID",
+		0x0021: "HDRHeadroom",
+		0x0023: "AFPerformance",
+		0x0025: "SceneFlags",
+		0x0026: "SignalToNoiseRatioType",
+		0x0027: "SignalToNoiseRatio",
+		0x002b: "PhotoIdentifier",
+		0x002d: "ColorTemperature",
+		0x002e: "CameraType",
+		0x002f: "FocusPosition",
+		0x0030: "HDRGain",
+		0x0038: "AFMeasuredDepth",
+		0x003d: "AFConfidence",
+		0x003e: "ColorCorrectionMatrix",
+		0x003f: "GreenGhostMitigationStatus",
+		0x0040: "SemanticStyle",
+		0x0041: "SemanticStyleRenderingVer",
+		0x0042: "SemanticStylePreset",
+	},
+	match: func(cameraMake string, b []byte) bool {
+		return cameraMake == "Apple" && bytes.HasPrefix(b, []byte("Apple iOS\x00"))
+	},
+	// "Apple iOS\x00\x00\x01MM"
+	headerLen: 14,
+	// The Apple MakerNote is always big endian, regardless of the EXIF block.
+	byteOrder: binary.BigEndian,
+	relative:  true,
+}
+
+// See https://exiftool.org/TagNames/Canon.html
+var makerNoteCanon = &makerNoteFormat{
+	name: "Canon",
+	fields: map[uint16]string{
+		0x0006: "CanonImageType",
+		0x0007: "CanonFirmwareVersion",
+		0x0008: "FileNumber",
+		0x0009: "OwnerName",
+		0x000c: "SerialNumber",
+		0x0010: "CanonModelID",
+		0x0095: "LensModel",
+		0x0096: "InternalSerialNumber",
+		0x00b6: "PreviewImageInfo",
+	},
+	match: func(cameraMake string, b []byte) bool {
+		return cameraMake == "Canon"
+	},
+	// PreviewImageInfo starts with its length in bytes, followed by
+	// PreviewQuality, PreviewImageLength, PreviewImageWidth, PreviewImageHeight and PreviewImageStart.
+	previewTag:         0x00b6,
+	previewStartIndex:  5,
+	previewLengthIndex: 2,
+}
+
+// See https://exiftool.org/TagNames/Panasonic.html
+var makerNoteFieldsPanasonic = map[uint16]string{
+	0x0001: "ImageQuality",
+	0x0002: "FirmwareVersion",
+	0x0003: "WhiteBalance",
+	0x0007: "FocusMode",
+	0x000f: "AFAreaMode",
+	0x001a: "ImageStabilization",
+	0x001c: "MacroMode",
+	0x001f: "ShootingMode",
+	0x0020: "Audio",
+	0x0021: "DataDump",
+	0x0023: "WhiteBalanceBias",
+	0x0024: "FlashBias",
+	0x0025: "InternalSerialNumber",
+	0x0026: "PanasonicExifVersion",
+	0x0027: "VideoFrameRate",
+	0x0028: "ColorEffect",
+	0x0029: "TimeSincePowerOn",
+	0x002a: "BurstMode",
+	0x002b: "SequenceNumber",
+	0x002c: "ContrastMode",
+	0x002d: "NoiseReduction",
+	0x002e: "SelfTimer",
+	0x0030: "Rotation",
+	0x0031: "AFAssistLamp",
+	0x0032: "ColorMode",
+	0x0033: "BabyAge",
+	0x0034: "OpticalZoomMode",
+	0x0035: "ConversionLens",
+	0x0036: "TravelDay",
+	0x0038: "BatteryLevel",
+	0x0039: "Contrast",
+	0x003a: "WorldTimeLocation",
+	0x003b: "TextStamp",
+	0x003c: "ProgramISO",
+	0x003d: "AdvancedSceneType",
+	0x003e: "TextStamp",
+	0x003f: "FacesDetected",
+	0x0040: "Saturation",
+	0x0041: "Sharpness",
+	0x0042: "FilmMode",
+	0x0044: "ColorTempKelvin",
+	0x0045: "BracketSettings",
+	0x0046: "WBShiftAB",
+	0x0047: "WBShiftGM",
+	0x0048: "FlashCurtain",
+	0x0049: "LongExposureNoiseReduction",
+	0x004b: "PanasonicImageWidth",
+	0x004c: "PanasonicImageHeight",
+	0x004d: "AFPointPosition",
+	0x004e: "FaceDetInfo",
+	0x0051: "LensType",
+	0x0052: "LensSerialNumber",
+	0x0053: "AccessoryType",
+	0x0054: "AccessorySerialNumber",
+	0x0059: "Transform",
+	0x005d: "IntelligentExposure",
+	0x0060: "LensFirmwareVersion",
+	0x0061: "FaceRecInfo",
+	0x0062: "FlashWarning",
+	0x0065: "Title",
+	0x0066: "BabyName",
+	0x0067: "Location",
+	0x0069: "Country",
+	0x006b: "State",
+	0x006d: "City",
+	0x006f: "Landmark",
+	0x0070: "IntelligentResolution",
+	0x0089: "PhotoStyle",
+	0x008f: "CameraOrientation",
+	0x0090: "RollAngle",
+	0x0091: "PitchAngle",
+	0x009f: "ShutterType",
+	0x0e00: "PrintIM",
+	0x8000: "MakerNoteVersion",
+	0x8001: "SceneMode",
+	0x8002: "HighlightWarning",
+	0x8003: "DarkFocusEnvironment",
+	0x8004: "WBRedLevel",
+	0x8005: "WBGreenLevel",
+	0x8006: "WBBlueLevel",
+	0x8007: "FlashFired",
+	0x8008: "TextStamp",
+	0x8009: "TextStamp",
+	0x8010: "BabyAge",
+	0x8012: "Transform",
+}
+
+var makerNotePanasonic = &makerNoteFormat{
+	name:   "Panasonic",
+	fields: makerNoteFieldsPanasonic,
+	match: func(cameraMake string, b []byte) bool {
+		return bytes.HasPrefix(b, []byte("Panasonic\x00\x00\x00"))
+	},
+	// "Panasonic\x00\x00\x00"
+	headerLen: 12,
+}
+
+// Leica cameras made by Panasonic use the Panasonic MakerNote with a different header.
+var makerNoteLeica = &makerNoteFormat{
+	name:   "Leica",
+	fields: makerNoteFieldsPanasonic,
+	match: func(cameraMake string, b []byte) bool {
+		return cameraMake == "LEICA" && bytes.HasPrefix(b, []byte("LEICA\x00\x00\x00"))
+	},
+	// "LEICA\x00\x00\x00"
+	headerLen: 8,
+}
+
+func init() {
+	exifValueConverterMap["Apple.AEMatrix"] = exifConverters.convertBinaryData
+	exifValueConverterMap["Apple.RunTime"] = exifConverters.convertBinaryPlist
+	exifValueConverterMap["Apple.AccelerationVector"] = exifConverters.convertRatsToSpaceLimited
+
+	// Panasonic stores some versions and strings with the undefined type.
+	for _, name := range []string{"Panasonic", "Leica"} {
+		exifValueConverterMap[name+".FirmwareVersion"] = exifConverters.convertBytesToStringSpaceDelim
+		exifValueConverterMap[name+".AFAreaMode"] = exifConverters.convertBytesToStringSpaceDelim
+		exifValueConverterMap[name+".LensFirmwareVersion"] = exifConverters.convertBytesToStringSpaceDelim
+		exifValueConverterMap[name+".InternalSerialNumber"] = exifConverters.convertBytesToString
+		exifValueConverterMap[name+".PanasonicExifVersion"] = exifConverters.convertBytesToString
+		exifValueConverterMap[name+".MakerNoteVersion"] = exifConverters.convertBytesToString
+		exifValueConverterMap[name+".DataDump"] = exifConverters.convertBinaryData
+		exifValueConverterMap[name+".FaceDetInfo"] = exifConverters.convertBinaryData
+		exifValueConverterMap[name+".FaceRecInfo"] = exifConverters.convertBinaryData
+		exifValueConverterMap[name+".PrintIM"] = exifConverters.convertKeepBytes
+	}
+}
+
+func (f *makerNoteFormat) tagName(tagID uint16) string {
+	name, ok := f.fields[tagID]
+	if !ok {
+		return fmt.Sprintf("%s.%s0x%x", f.name, UnknownPrefix, tagID)
+	}
+	return f.name + "." + name
+}
+
+// decodeMakerNote decodes the MakerNote at valueOffset if it's in a known format.
+// It returns false if the format is not known.
+func (e *metaDecoderEXIF) decodeMakerNote(namespace string, valueOffset int64) (bool, error) {
+	start := valueOffset + e.readerOffset
+	// Some editors (e.g. Windows Photo Gallery) move the MakerNote without updating
+	// the offsets inside it, and store the distance moved in OffsetSchema.
+	return e.decodeMakerNoteAt(namespace, start, e.byteOrder, e.readerOffset+int64(e.offsetSchema()), true)
+}
+
+// decodeMakerNoteAt decodes the MakerNote starting at the absolute position start if it's in a known format.
+// If trackPreview is set, any preview image referenced by the MakerNote is considered for HandlePreviewImage.
+// The byte order and readerOffset (the start of the TIFF header the offsets are relative to)
+// are used for formats that don't define their own.
+func (e *metaDecoderEXIF) decodeMakerNoteAt(namespace string, start int64, byteOrder binary.ByteOrder, readerOffset int64, trackPreview bool) (bool, error) {
+	var header []byte
+	e.preservePos(func() error {
+		e.seek(start)
+		header = make([]byte, makerNoteMaxHeaderLen)
+		n, _ := e.r.Read(header)
+		header = header[:n]
+		return nil
+	})
+
+	cameraMake := strings.TrimSpace(e.make)
+	var format *makerNoteFormat
+	for _, f := range makerNoteFormats {
+		if f.match(cameraMake, header) {
+			format = f
+			break
+		}
+	}
+	if format == nil {
+		return false, nil
+	}
+
+	if format.byteOrder != nil {
+		byteOrder = format.byteOrder
+	} else if int64(len(header)) >= format.headerLen+2 {
+		byteOrder = makerNoteByteOrder(header[format.headerLen:], byteOrder)
+	}
+	if format.relative {
+		readerOffset = start
+	}
+
+	s := &streamReader{
+		r:            e.r,
+		byteOrder:    byteOrder,
+		readerOffset: readerOffset,
+	}
+	dec := newMetaDecoderEXIFFromStreamReader(s, e.thumbnailOffset, e.opts, nil)
+	dec.makerNote = format
+	dec.makerNotePreview = trackPreview && e.opts.DecodeMakerNotes && e.opts.HandlePreviewImage != nil
+
+	return true, e.preservePos(func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				if r != errStop {
+					panic(r)
+				}
+				// MakerNotes are vendor specific and often broken,
+				// don't let that stop the decoding of the other tags.
+				e.opts.Warnf("failed to decode %s MakerNote: %v", format.name, s.readErr)
+			}
+		}()
+		s.seek(start + format.headerLen)
+		if err := dec.decodeTags(path.Join(namespace, format.name)); err != nil {
+			if !errors.Is(err, io.ErrUnexpectedEOF) {
+				return err
+			}
+			// A value outside of the data, see above.
+			e.opts.Warnf("failed to decode %s MakerNote: %v", format.name, err)
+		}
+		if dec.preview.length > e.preview.length {
+			e.preview = dec.preview
+		}
+		return nil
+	})
+}
+
+// makerNoteByteOrder returns the byte order of the MakerNote IFD starting with b.
+// Some cameras and editors (e.g. older Canon PowerShots) write the MakerNote
+// in a different byte order than the EXIF block; the entry count tells us which one is used.
+func makerNoteByteOrder(b []byte, byteOrder binary.ByteOrder) binary.ByteOrder {
+	const maxEntries = 0xff
+	swapped := binary.ByteOrder(binary.LittleEndian)
+	if byteOrder == binary.LittleEndian {
+		swapped = binary.BigEndian
+	}
+	if n := byteOrder.Uint16(b); n == 0 || n > maxEntries {
+		if n := swapped.Uint16(b); n > 0 && n <= maxEntries {
+			return swapped
+		}
+	}
+	return byteOrder
+}
+
+// decodeDNGPrivateData decodes the original MakerNote stored in the DNGPrivateData tag
+// by Adobe's DNG converter, if present and in a known format.
+// The data starts with "Adobe\x00" followed by blocks with a 4 byte type and a 4 byte big endian size.
+// The MakerNote block ("MakN") holds the byte order and offset of the MakerNote in the original file,
+// which we need to resolve the offsets inside it.
+// See https://helpx.adobe.com/camera-raw/digital-negative.html (DNG specification, DNGPrivateData)
+func (e *metaDecoderEXIF) decodeDNGPrivateData(namespace string, valueOffset int64, length uint32) (bool, error) {
+	var (
+		found                bool
+		start, originalStart int64
+		byteOrder            binary.ByteOrder
+	)
+
+	e.preservePos(func() error {
+		pos := valueOffset + e.readerOffset
+		end := pos + int64(length)
+		e.seek(pos)
+		if b, err := e.readBytesVolatileE(len(dngPrivateDataAdobe)); err != nil || !bytes.Equal(b, dngPrivateDataAdobe) {
+			return nil
+		}
+		for pos = e.pos(); pos+8 <= end; pos = e.pos() {
+			typ, err := e.readBytesVolatileE(4)
+			if err != nil {
+				return nil
+			}
+			isMakerNote := bytes.Equal(typ, dngPrivateDataMakerNote)
+			size, err := e.readBytesVolatileE(4)
+			if err != nil {
+				return nil
+			}
+			blockSize := int64(binary.BigEndian.Uint32(size))
+			if blockSize > end-pos-8 {
+				return nil
+			}
+			if !isMakerNote {
+				e.skip(blockSize)
+				continue
+			}
+			if blockSize < 6 {
+				return nil
+			}
+			b, err := e.readBytesVolatileE(6)
+			if err != nil {
+				return nil
+			}
+			switch string(b[:2]) {
+			case "II":
+				byteOrder = binary.LittleEndian
+			case "MM":
+				byteOrder = binary.BigEndian
+			default:
+				return nil
+			}
+			originalStart = int64(binary.BigEndian.Uint32(b[2:]))
+			start = e.pos()
+			found = true
+			return nil
+		}
+		return nil
+	})
+
+	if !found {
+		return false, nil
+	}
+
+	// The offsets in the MakerNote are relative to the TIFF header in the original file.
+	// Any preview image it references is in the original file, not in this one.
+	return e.decodeMakerNoteAt(path.Join(namespace, "DNGPrivateData"), start, byteOrder, start-originalStart, false)
+}
+
+var (
+	dngPrivateDataAdobe     = []byte("Adobe\x00")
+	dngPrivateDataMakerNote = []byte("MakN")
+)
+
+// offsetSchema returns the value of the OffsetSchema tag in the current IFD, or 0 if not found.
+// This tag usually comes after the MakerNote, so we need to look ahead.
+func (e *metaDecoderEXIF) offsetSchema() int32 {
+	var offset int32
+	e.preservePos(func() error {
+		e.seek(e.ifd.entriesStart)
+		for i := 0; i < e.ifd.numEntries; i++ {
+			tagID := e.read2()
+			typ := exifType(e.read2())
+			count := e.readCount()
+			if tagID == exifTagOffsetSchema && typ == exifTypeSignedLong4 && count == 1 {
+				offset = int32(e.read4())
+				break
+			}
+			e.skipValueField()
+		}
+		return nil
+	})
+	return offset
+}
+
+// convertBinaryPlist converts a binary property list dictionary (as used by Apple) to a map.
+func (c vc) convertBinaryPlist(ctx valueConverterContext, v any) any {
+	b, ok := typeAssert[[]byte](ctx, v)
+	if !ok {
+		return ""
+	}
+	m, err := decodeBinaryPlistDict(b)
+	if err != nil {
+		ctx.warnf("failed to decode binary plist: %v", err)
+		return ""
+	}
+	return m
+}
+
+// decodeBinaryPlistDict decodes a binary property list with a dictionary as the top object.
+// Only integer, real, boolean and short ASCII string values are supported.
+// See https://opensource.apple.com/source/CF/CF-1153.18/CFBinaryPList.c
+func decodeBinaryPlistDict(b []byte) (map[string]any, error) {
+	const trailerLen = 32
+	if len(b) < 8+trailerLen || string(b[:8]) != "bplist00" {
+		return nil, errors.New("not a binary plist")
+	}
+
+	readUint := func(b []byte) uint64 {
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v
+	}
+
+	trailer := b[len(b)-trailerLen:]
+	offsetIntSize := uint64(trailer[6])
+	objectRefSize := uint64(trailer[7])
+	numObjects := readUint(trailer[8:16])
+	topObject := readUint(trailer[16:24])
+	offsetTableOffset := readUint(trailer[24:32])
+
+	if offsetIntSize == 0 || offsetIntSize > 8 || objectRefSize == 0 || objectRefSize > 8 {
+		return nil, errors.New("invalid trailer")
+	}
+
+	size := uint64(len(b))
+
+	objectOffset := func(i uint64) (uint64, error) {
+		if i >= numObjects {
+			return 0, fmt.Errorf("object %d out of range", i)
+		}
+		p := offsetTableOffset + i*offsetIntSize
+		if p+offsetIntSize > size || p < offsetTableOffset {
+			return 0, errors.New("offset table out of range")
+		}
+		off := readUint(b[p : p+offsetIntSize])
+		if off >= size {
+			return 0, fmt.Errorf("object offset %d out of range", off)
+		}
+		return off, nil
+	}
+
+	object := func(i uint64) (any, error) {
+		off, err := objectOffset(i)
+		if err != nil {
+			return nil, err
+		}
+		marker := b[off]
+		n := uint64(marker & 0xf)
+		data := func(l uint64) ([]byte, error) {
+			if off+1+l > size {
+				return nil, errors.New("object data out of range")
+			}
+			return b[off+1 : off+1+l], nil
+		}
+		switch marker >> 4 {
+		case 0x0:
+			switch marker {
+			case 0x08:
+				return false, nil
+			case 0x09:
+				return true, nil
+			}
+		case 0x1:
+			d, err := data(1 << n)
+			if err != nil {
+				return nil, err
+			}
+			return int64(readUint(d)), nil
+		case 0x2:
+			d, err := data(1 << n)
+			if err != nil {
+				return nil, err
+			}
+			switch len(d) {
+			case 4:
+				return float64(math.Float32frombits(uint32(readUint(d)))), nil
+			case 8:
+				return math.Float64frombits(readUint(d)), nil
+			}
+		case 0x5:
+			if n == 0xf {
+				break
+			}
+			d, err := data(n)
+			if err != nil {
+				return nil, err
+			}
+			return string(d), nil
+		}
+		return nil, fmt.Errorf("unsupported object type 0x%x", marker)
+	}
+
+	off, err := objectOffset(topObject)
+	if err != nil {
+		return nil, err
+	}
+	marker := b[off]
+	if marker>>4 != 0xd || marker&0xf == 0xf {
+		return nil, errors.New("top object is not a dictionary")
+	}
+	count := uint64(marker & 0xf)
+	refs := off + 1
+	if refs+2*count*objectRefSize > size {
+		return nil, errors.New("dictionary out of range")
+	}
+
+	m := make(map[string]any, count)
+	for i := uint64(0); i < count; i++ {
+		kp := refs + i*objectRefSize
+		vp := refs + (count+i)*objectRefSize
+		k, err := object(readUint(b[kp : kp+objectRefSize]))
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, errors.New("dictionary key is not a string")
+		}
+		v, err := object(readUint(b[vp : vp+objectRefSize]))
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+
+	return m, nil
+}
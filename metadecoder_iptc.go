@@ -6,6 +6,7 @@ package imagemeta
 import (
 	_ "embed" // needed for the embedded IPTC fields JSON
 	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -37,6 +38,11 @@ var (
 const (
 	ipcCodedCharacterSet = 90
 	iptcMetaDataBlockID  = 0x0404
+
+	// Photoshop image resources, see https://exiftool.org/TagNames/Photoshop.html
+	photoshopResolutionInfo = 0x03ed
+	photoshopIPTCDigest     = 0x0425
+	photoshopNamespace      = "Photoshop"
 )
 
 type vcIPTC struct {
@@ -198,30 +204,35 @@ func (e *metaDecoderIPTC) decodeBlocks() (err error) {
 		identifier := e.read2()
 		isNotMeta := identifier != iptcMetaDataBlockID
 
-		nameLength := e.read1()
-		if nameLength == 0 {
-			nameLength = 2
-		} else if nameLength%2 == 1 {
+		// The name is a Pascal string padded to an even size, including the length byte.
+		nameLength := int64(e.read1())
+		if nameLength%2 == 0 {
 			nameLength++
 		}
 
-		e.skip(int64(nameLength - 1))
+		e.skip(nameLength)
 
 		dataSize := e.read4()
 
 		if isNotMeta {
-			e.skip(int64(dataSize))
+			if err := e.decodePhotoshopResource(identifier, dataSize); err != nil {
+				return err
+			}
+			if dataSize%2 != 0 {
+				// Skip padding byte.
+				e.skip(1)
+			}
 			return nil
 		}
 
+		dataEnd := e.pos() + int64(dataSize)
+		end := dataEnd
 		if dataSize%2 != 0 {
-			defer func() {
-				// Skip padding byte.
-				e.skip(1)
-			}()
+			// Padding byte.
+			end++
 		}
 
-		for {
+		for e.pos() < dataEnd {
 			marker := e.read1()
 
 			if e.isEOF || marker != 0x1C {
@@ -232,6 +243,10 @@ func (e *metaDecoderIPTC) decodeBlocks() (err error) {
 				return err
 			}
 		}
+
+		// Continue with the next block, which may be a Photoshop resource.
+		e.seek(end)
+		return nil
 	}
 
 	for {
@@ -250,6 +265,46 @@ func (e *metaDecoderIPTC) decodeBlocks() (err error) {
 	return nil
 }
 
+// decodePhotoshopResource decodes the Photoshop image resource with the given identifier
+// and passes its tags on in the Photoshop namespace.
+// Resources we don't support are skipped.
+func (e *metaDecoderIPTC) decodePhotoshopResource(identifier uint16, dataSize uint32) error {
+	handleTag := func(tag string, v any) error {
+		ti := TagInfo{
+			Source:    IPTC,
+			Tag:       tag,
+			Namespace: photoshopNamespace,
+			Value:     v,
+		}
+		if !e.opts.ShouldHandleTag(ti) {
+			return nil
+		}
+		return e.opts.HandleTag(ti)
+	}
+
+	switch {
+	case identifier == photoshopResolutionInfo && dataSize == 16:
+		// Fixed point 16.16 resolution, resolution unit and width/height unit for each direction.
+		// The resolution unit is what exiftool reports as DisplayedUnits (1 = inches, 2 = cm).
+		for _, tag := range []string{"X", "Y"} {
+			res := float64(e.read4()) / 65536
+			displayedUnits := e.read2()
+			e.skip(2)
+			if err := handleTag(tag+"Resolution", res); err != nil {
+				return err
+			}
+			if err := handleTag("DisplayedUnits"+tag, displayedUnits); err != nil {
+				return err
+			}
+		}
+	case identifier == photoshopIPTCDigest && dataSize == 16:
+		return handleTag("IPTCDigest", hex.EncodeToString(e.readBytesVolatile(16)))
+	default:
+		e.skip(int64(dataSize))
+	}
+	return nil
+}
+
 func (e *metaDecoderIPTC) decodeRecord(stringSlices map[TagInfo][]string) error {
 	recordType := e.read1()
 	datasetNumber := e.read1()
@@ -270,6 +325,7 @@ func (e *metaDecoderIPTC) decodeRecord(stringSlices map[TagInfo][]string) error
 	ti := TagInfo{
 		Source:    IPTC,
 		Tag:       recordDef.Name,
+		ID:        uint16(datasetNumber),
 		Namespace: recordDef.RecordName,
 	}
 
@@ -4,10 +4,16 @@
 package imagemeta
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
+	"strings"
+
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
 )
 
 var xmpSkipNamespaces = map[string]bool{
@@ -16,19 +22,87 @@ var xmpSkipNamespaces = map[string]bool{
 	"http://purl.org/dc/elements/1.1/":            true,
 }
 
+// xmpNamespacePrefixes maps common XMP namespace URIs to their conventional prefixes.
+// See https://exiftool.org/TagNames/XMP.html
+var xmpNamespacePrefixes = map[string]string{
+	"adobe:ns:meta/":                                            "x",
+	"http://cipa.jp/exif/1.0/":                                  "exifEX",
+	"http://darktable.sf.net/":                                  "darktable",
+	"http://iptc.org/std/Iptc4xmpCore/1.0/xmlns/":               "Iptc4xmpCore",
+	"http://iptc.org/std/Iptc4xmpExt/2008-02-29/":               "Iptc4xmpExt",
+	"http://ns.adobe.com/camera-raw-embedded-lens-profile/1.0/": "crlcp",
+	"http://ns.adobe.com/camera-raw-settings/1.0/":              "crs",
+	"http://ns.adobe.com/exif/1.0/":                             "exif",
+	"http://ns.adobe.com/exif/1.0/aux/":                         "aux",
+	"http://ns.adobe.com/hdr-gain-map/1.0/":                     "hdrgm",
+	"http://ns.adobe.com/lightroom/1.0/":                        "lr",
+	"http://ns.adobe.com/pdf/1.3/":                              "pdf",
+	"http://ns.adobe.com/photoshop/1.0/":                        "photoshop",
+	"http://ns.adobe.com/tiff/1.0/":                             "tiff",
+	"http://ns.adobe.com/xap/1.0/":                              "xmp",
+	"http://ns.adobe.com/xap/1.0/bj/":                           "xmpBJ",
+	"http://ns.adobe.com/xap/1.0/mm/":                           "xmpMM",
+	"http://ns.adobe.com/xap/1.0/rights/":                       "xmpRights",
+	"http://ns.adobe.com/xap/1.0/sType/Dimensions#":             "stDim",
+	"http://ns.adobe.com/xap/1.0/sType/ResourceEvent#":          "stEvt",
+	"http://ns.adobe.com/xap/1.0/sType/ResourceRef#":            "stRef",
+	"http://ns.adobe.com/xmp/1.0/DynamicMedia/":                 "xmpDM",
+	"http://ns.adobe.com/xmp/sType/Area#":                       "stArea",
+	"http://ns.camerabits.com/photomechanic/1.0/":               "photomechanic",
+	"http://ns.google.com/photos/1.0/camera/":                   "GCamera",
+	"http://ns.google.com/photos/1.0/container/":                "Container",
+	"http://ns.google.com/photos/1.0/panorama/":                 "GPano",
+	"http://ns.microsoft.com/photo/1.0":                         "MicrosoftPhoto",
+	"http://ns.useplus.org/ldf/xmp/1.0/":                        "plus",
+	"http://purl.org/dc/elements/1.1/":                          "dc",
+	"http://www.metadataworkinggroup.com/schemas/regions/":      "mwg-rs",
+	"http://www.w3.org/1999/02/22-rdf-syntax-ns#":               "rdf",
+}
+
+// xmpNamespace returns the namespace to use for the given namespace URI.
+func xmpNamespace(uri string, opts Options) string {
+	if !opts.XMPNamespacePrefixes {
+		return uri
+	}
+	if prefix, ok := xmpNamespacePrefixes[uri]; ok {
+		return prefix
+	}
+	return uri
+}
+
 type rdf struct {
 	Description rdfDescription `xml:"Description"`
 }
 
 type rdfDescription struct {
-	Attrs []xml.Attr `xml:",any,attr"`
+	Attrs   []xml.Attr  `xml:",any,attr"`
+	Regions *xmpRegions `xml:"http://www.metadataworkinggroup.com/schemas/regions/ Regions"`
+	History *xmpHistory `xml:"http://ns.adobe.com/xap/1.0/mm/ History"`
+	Creator *xmpSeq     `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Rights  *xmpLangAlt `xml:"http://purl.org/dc/elements/1.1/ rights"`
+
+	ContainerDirectory *xmpContainerDirectory `xml:"http://ns.google.com/photos/1.0/container/ Directory"`
 }
 
 type xmpmeta struct {
 	RDF rdf `xml:"RDF"`
 }
 
-func decodeXMP(r io.Reader, opts Options) error {
+// decodeXMP decodes the XMP packet in r.
+// result may be nil.
+func decodeXMP(r io.Reader, opts Options, result *DecodeResult) error {
+	if opts.probe {
+		// We only need to know that it's there.
+		return nil
+	}
+	if opts.KeepRawXMP && result != nil {
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		result.RawXMP = b
+		r = bytes.NewReader(b)
+	}
 	if opts.HandleXMP != nil {
 		if err := opts.HandleXMP(r); err != nil {
 			return err
@@ -42,29 +116,145 @@ func decodeXMP(r io.Reader, opts Options) error {
 	}
 
 	var meta xmpmeta
-	if err := xml.NewDecoder(r).Decode(&meta); err != nil {
+	if err := newXMPDecoder(r).Decode(&meta); err != nil {
+		var syntaxErr *xml.SyntaxError
+		if errors.As(err, &syntaxErr) && syntaxErr.Msg == "unexpected EOF" {
+			// The packet ends in the middle of an element, e.g. in a file that was cut off.
+			return newInvalidFormatError(fmt.Errorf("decoding XMP: line %d: %w", syntaxErr.Line, io.ErrUnexpectedEOF))
+		}
 		return newInvalidFormatError(fmt.Errorf("decoding XMP: %w", err))
 	}
 
-	for _, attr := range meta.RDF.Description.Attrs {
-		if xmpSkipNamespaces[attr.Name.Space] {
-			continue
+	if result != nil {
+		if hasVideo, trailer := motionPhotoTrailer(meta.RDF.Description); hasVideo {
+			result.MotionPhoto.HasVideo = true
+			result.motionPhotoTrailer = trailer
+		}
+	}
+
+	var packetPrefixes map[string]string
+	if opts.XMPQualifiedNames {
+		// Prefixes declared in the packet, used for namespaces not in xmpNamespacePrefixes.
+		packetPrefixes = make(map[string]string)
+		for _, attr := range meta.RDF.Description.Attrs {
+			if attr.Name.Space == "xmlns" {
+				packetPrefixes[attr.Value] = attr.Name.Local
+			}
+		}
+	}
+
+	handleTag := func(space, local string, value any) error {
+		tagName := local
+		if opts.XMPQualifiedNames {
+			prefix, ok := xmpNamespacePrefixes[space]
+			if !ok {
+				prefix = packetPrefixes[space]
+			}
+			if prefix != "" {
+				tagName = prefix + ":" + tagName
+			}
 		}
 
 		tagInfo := TagInfo{
 			Source:    XMP,
-			Tag:       attr.Name.Local,
-			Namespace: attr.Name.Space,
-			Value:     attr.Value,
+			Tag:       tagName,
+			Namespace: xmpNamespace(space, opts),
+			Value:     value,
 		}
 
 		if !opts.ShouldHandleTag(tagInfo) {
+			return nil
+		}
+
+		return opts.HandleTag(tagInfo)
+	}
+
+	for _, attr := range meta.RDF.Description.Attrs {
+		if xmpSkipNamespaces[attr.Name.Space] {
 			continue
 		}
+		var value any = attr.Value
+		if opts.XMPCameraRawTypes && attr.Name.Space == xmpNamespaceCRS {
+			value = convertXMPCRSValue(attr.Name.Local, attr.Value, opts)
+		}
+		if err := handleTag(attr.Name.Space, attr.Name.Local, value); err != nil {
+			return err
+		}
+	}
+
+	if regions := meta.RDF.Description.Regions; regions != nil {
+		if err := handleTag(xmpNamespaceMWGRegions, "RegionList", regions.toRegions()); err != nil {
+			return err
+		}
+	}
+
+	if history := meta.RDF.Description.History; history != nil {
+		if err := handleTag(xmpNamespaceXMPMM, "History", history.toEvents()); err != nil {
+			return err
+		}
+	}
+
+	if creator := meta.RDF.Description.Creator; creator != nil && opts.DecodeXMPDublinCore {
+		if err := handleTag(xmpNamespaceDC, "creator", creator.toStrings()); err != nil {
+			return err
+		}
+	}
 
-		if err := opts.HandleTag(tagInfo); err != nil {
+	if rights := meta.RDF.Description.Rights; rights != nil && opts.DecodeXMPDublinCore {
+		if err := handleTag(xmpNamespaceDC, "rights", rights.toString()); err != nil {
 			return err
 		}
 	}
+
 	return nil
 }
+
+var (
+	bomUTF8    = []byte{0xef, 0xbb, 0xbf}
+	bomUTF16BE = []byte{0xfe, 0xff}
+	bomUTF16LE = []byte{0xff, 0xfe}
+
+	xpacketStart = []byte("<?xpacket")
+)
+
+// newXMPDecoder creates a new XML decoder for the XMP packet in r.
+// Some writers (TIFF in particular) store the packet with a leading
+// byte order mark, which may be UTF-16, and/or a <?xpacket ...?> wrapper,
+// so we strip those before handing it over to the XML decoder.
+func newXMPDecoder(r io.Reader) *xml.Decoder {
+	br := bufio.NewReader(r)
+
+	var transcoded bool
+	if b, _ := br.Peek(3); bytes.HasPrefix(b, bomUTF8) {
+		br.Discard(len(bomUTF8))
+	} else if bytes.HasPrefix(b, bomUTF16BE) || bytes.HasPrefix(b, bomUTF16LE) {
+		// The BOM decides the byte order and is removed by the decoder.
+		dec := unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM).NewDecoder()
+		br = bufio.NewReader(transform.NewReader(br, dec))
+		transcoded = true
+	}
+
+	if b, _ := br.Peek(len(xpacketStart)); bytes.Equal(b, xpacketStart) {
+		// Skip to the end of the processing instruction.
+		var prev byte
+		for {
+			c, err := br.ReadByte()
+			if err != nil || (prev == '?' && c == '>') {
+				break
+			}
+			prev = c
+		}
+	}
+
+	d := xml.NewDecoder(br)
+	if transcoded {
+		// The content is now UTF-8, but the XML declaration may still say otherwise.
+		d.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+			if strings.HasPrefix(strings.ToLower(charset), "utf-16") {
+				return input, nil
+			}
+			return nil, fmt.Errorf("unsupported XMP charset %q", charset)
+		}
+	}
+	return d
+}
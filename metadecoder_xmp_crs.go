@@ -0,0 +1,207 @@
+// Copyright 2024 Bjørn Erik Pedersen
+// SPDX-License-Identifier: MIT
+
+package imagemeta
+
+import (
+	"strconv"
+	"strings"
+)
+
+const xmpNamespaceCRS = "http://ns.adobe.com/camera-raw-settings/1.0/"
+
+// The types of the Camera Raw Settings (crs) develop settings written by e.g. Lightroom.
+// Properties not listed here are passed on as strings.
+// See https://exiftool.org/TagNames/XMP.html#crs
+const (
+	crsTypeInt = iota + 1
+	crsTypeFloat
+	crsTypeBool
+)
+
+var xmpCRSTypes = map[string]int{
+	"AlreadyApplied":        crsTypeBool,
+	"AutoBrightness":        crsTypeBool,
+	"AutoContrast":          crsTypeBool,
+	"AutoExposure":          crsTypeBool,
+	"AutoShadows":           crsTypeBool,
+	"ConvertToGrayscale":    crsTypeBool,
+	"HasCrop":               crsTypeBool,
+	"HasSettings":           crsTypeBool,
+	"LensProfileIsEmbedded": crsTypeBool,
+	"OverrideLookVignette":  crsTypeBool,
+	"UprightPreview":        crsTypeBool,
+
+	"CropAngle":              crsTypeFloat,
+	"CropBottom":             crsTypeFloat,
+	"CropHeight":             crsTypeFloat,
+	"CropLeft":               crsTypeFloat,
+	"CropRight":              crsTypeFloat,
+	"CropTop":                crsTypeFloat,
+	"CropWidth":              crsTypeFloat,
+	"Exposure":               crsTypeFloat,
+	"Exposure2012":           crsTypeFloat,
+	"PerspectiveRotate":      crsTypeFloat,
+	"PerspectiveX":           crsTypeFloat,
+	"PerspectiveY":           crsTypeFloat,
+	"SharpenRadius":          crsTypeFloat,
+	"UprightCenterNormX":     crsTypeFloat,
+	"UprightCenterNormY":     crsTypeFloat,
+	"UprightFocalLength35mm": crsTypeFloat,
+
+	"AutoLateralCA":                       crsTypeInt,
+	"Blacks2012":                          crsTypeInt,
+	"BlueHue":                             crsTypeInt,
+	"BlueSaturation":                      crsTypeInt,
+	"Brightness":                          crsTypeInt,
+	"ChromaticAberrationB":                crsTypeInt,
+	"ChromaticAberrationR":                crsTypeInt,
+	"Clarity":                             crsTypeInt,
+	"Clarity2012":                         crsTypeInt,
+	"ColorGradeBlending":                  crsTypeInt,
+	"ColorGradeGlobalHue":                 crsTypeInt,
+	"ColorGradeGlobalLum":                 crsTypeInt,
+	"ColorGradeGlobalSat":                 crsTypeInt,
+	"ColorGradeHighlightLum":              crsTypeInt,
+	"ColorGradeMidtoneHue":                crsTypeInt,
+	"ColorGradeMidtoneLum":                crsTypeInt,
+	"ColorGradeMidtoneSat":                crsTypeInt,
+	"ColorGradeShadowLum":                 crsTypeInt,
+	"ColorNoiseReduction":                 crsTypeInt,
+	"ColorNoiseReductionDetail":           crsTypeInt,
+	"ColorNoiseReductionSmoothness":       crsTypeInt,
+	"Contrast":                            crsTypeInt,
+	"Contrast2012":                        crsTypeInt,
+	"CropConstrainToWarp":                 crsTypeInt,
+	"CropUnits":                           crsTypeInt,
+	"Defringe":                            crsTypeInt,
+	"DefringeGreenAmount":                 crsTypeInt,
+	"DefringeGreenHueHi":                  crsTypeInt,
+	"DefringeGreenHueLo":                  crsTypeInt,
+	"DefringePurpleAmount":                crsTypeInt,
+	"DefringePurpleHueHi":                 crsTypeInt,
+	"DefringePurpleHueLo":                 crsTypeInt,
+	"Dehaze":                              crsTypeInt,
+	"FillLight":                           crsTypeInt,
+	"GrainAmount":                         crsTypeInt,
+	"GrainFrequency":                      crsTypeInt,
+	"GrainSize":                           crsTypeInt,
+	"GrayMixerAqua":                       crsTypeInt,
+	"GrayMixerBlue":                       crsTypeInt,
+	"GrayMixerGreen":                      crsTypeInt,
+	"GrayMixerMagenta":                    crsTypeInt,
+	"GrayMixerOrange":                     crsTypeInt,
+	"GrayMixerPurple":                     crsTypeInt,
+	"GrayMixerRed":                        crsTypeInt,
+	"GrayMixerYellow":                     crsTypeInt,
+	"GreenHue":                            crsTypeInt,
+	"GreenSaturation":                     crsTypeInt,
+	"HighlightRecovery":                   crsTypeInt,
+	"Highlights2012":                      crsTypeInt,
+	"HueAdjustmentAqua":                   crsTypeInt,
+	"HueAdjustmentBlue":                   crsTypeInt,
+	"HueAdjustmentGreen":                  crsTypeInt,
+	"HueAdjustmentMagenta":                crsTypeInt,
+	"HueAdjustmentOrange":                 crsTypeInt,
+	"HueAdjustmentPurple":                 crsTypeInt,
+	"HueAdjustmentRed":                    crsTypeInt,
+	"HueAdjustmentYellow":                 crsTypeInt,
+	"IncrementalTemperature":              crsTypeInt,
+	"IncrementalTint":                     crsTypeInt,
+	"LensManualDistortionAmount":          crsTypeInt,
+	"LensProfileChromaticAberrationScale": crsTypeInt,
+	"LensProfileDistortionScale":          crsTypeInt,
+	"LensProfileEnable":                   crsTypeInt,
+	"LensProfileVignettingScale":          crsTypeInt,
+	"LuminanceAdjustmentAqua":             crsTypeInt,
+	"LuminanceAdjustmentBlue":             crsTypeInt,
+	"LuminanceAdjustmentGreen":            crsTypeInt,
+	"LuminanceAdjustmentMagenta":          crsTypeInt,
+	"LuminanceAdjustmentOrange":           crsTypeInt,
+	"LuminanceAdjustmentPurple":           crsTypeInt,
+	"LuminanceAdjustmentRed":              crsTypeInt,
+	"LuminanceAdjustmentYellow":           crsTypeInt,
+	"LuminanceNoiseReductionContrast":     crsTypeInt,
+	"LuminanceNoiseReductionDetail":       crsTypeInt,
+	"LuminanceSmoothing":                  crsTypeInt,
+	"ParametricDarks":                     crsTypeInt,
+	"ParametricHighlightSplit":            crsTypeInt,
+	"ParametricHighlights":                crsTypeInt,
+	"ParametricLights":                    crsTypeInt,
+	"ParametricMidtoneSplit":              crsTypeInt,
+	"ParametricShadowSplit":               crsTypeInt,
+	"ParametricShadows":                   crsTypeInt,
+	"PerspectiveAspect":                   crsTypeInt,
+	"PerspectiveHorizontal":               crsTypeInt,
+	"PerspectiveScale":                    crsTypeInt,
+	"PerspectiveUpright":                  crsTypeInt,
+	"PerspectiveVertical":                 crsTypeInt,
+	"PostCropVignetteAmount":              crsTypeInt,
+	"PostCropVignetteFeather":             crsTypeInt,
+	"PostCropVignetteHighlightContrast":   crsTypeInt,
+	"PostCropVignetteMidpoint":            crsTypeInt,
+	"PostCropVignetteRoundness":           crsTypeInt,
+	"PostCropVignetteStyle":               crsTypeInt,
+	"RedHue":                              crsTypeInt,
+	"RedSaturation":                       crsTypeInt,
+	"Saturation":                          crsTypeInt,
+	"SaturationAdjustmentAqua":            crsTypeInt,
+	"SaturationAdjustmentBlue":            crsTypeInt,
+	"SaturationAdjustmentGreen":           crsTypeInt,
+	"SaturationAdjustmentMagenta":         crsTypeInt,
+	"SaturationAdjustmentOrange":          crsTypeInt,
+	"SaturationAdjustmentPurple":          crsTypeInt,
+	"SaturationAdjustmentRed":             crsTypeInt,
+	"SaturationAdjustmentYellow":          crsTypeInt,
+	"ShadowTint":                          crsTypeInt,
+	"Shadows":                             crsTypeInt,
+	"Shadows2012":                         crsTypeInt,
+	"SharpenDetail":                       crsTypeInt,
+	"SharpenEdgeMasking":                  crsTypeInt,
+	"Sharpness":                           crsTypeInt,
+	"SplitToningBalance":                  crsTypeInt,
+	"SplitToningHighlightHue":             crsTypeInt,
+	"SplitToningHighlightSaturation":      crsTypeInt,
+	"SplitToningShadowHue":                crsTypeInt,
+	"SplitToningShadowSaturation":         crsTypeInt,
+	"Temperature":                         crsTypeInt,
+	"Texture":                             crsTypeInt,
+	"Tint":                                crsTypeInt,
+	"UprightCenterMode":                   crsTypeInt,
+	"UprightFocalMode":                    crsTypeInt,
+	"UprightVersion":                      crsTypeInt,
+	"Vibrance":                            crsTypeInt,
+	"VignetteAmount":                      crsTypeInt,
+	"VignetteMidpoint":                    crsTypeInt,
+	"Whites2012":                          crsTypeInt,
+}
+
+// convertXMPCRSValue converts the Camera Raw Settings property name with value s
+// to an int, float64 or bool if its type is known.
+// Values that don't parse are returned as is.
+func convertXMPCRSValue(name, s string, opts Options) any {
+	typ, found := xmpCRSTypes[name]
+	if !found {
+		return s
+	}
+	s = strings.TrimSpace(s)
+	switch typ {
+	case crsTypeInt:
+		if i, err := strconv.Atoi(s); err == nil {
+			return i
+		}
+	case crsTypeFloat:
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	case crsTypeBool:
+		switch strings.ToLower(s) {
+		case "true":
+			return true
+		case "false":
+			return false
+		}
+	}
+	opts.Warnf("XMP crs:%s: invalid value %q", name, s)
+	return s
+}
@@ -0,0 +1,50 @@
+// Copyright 2024 Bjørn Erik Pedersen
+// SPDX-License-Identifier: MIT
+
+package imagemeta
+
+const xmpNamespaceDC = "http://purl.org/dc/elements/1.1/"
+
+// The Dublin Core properties are stored as elements, so they're not picked up as attributes.
+// We currently only decode the ones needed for attribution.
+// See https://exiftool.org/TagNames/XMP.html#dc
+
+// xmpSeq is an ordered array, e.g. dc:creator.
+type xmpSeq struct {
+	Seq struct {
+		Items []string `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# li"`
+	} `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# Seq"`
+}
+
+func (s *xmpSeq) toStrings() []string {
+	values := make([]string, 0, len(s.Seq.Items))
+	for _, item := range s.Seq.Items {
+		if item != "" {
+			values = append(values, item)
+		}
+	}
+	return values
+}
+
+// xmpLangAlt is a language alternative, e.g. dc:rights.
+type xmpLangAlt struct {
+	Alt struct {
+		Items []struct {
+			Lang  string `xml:"lang,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# li"`
+	} `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# Alt"`
+}
+
+// toString returns the x-default value, or the first value if there's no default.
+func (a *xmpLangAlt) toString() string {
+	for _, item := range a.Alt.Items {
+		if item.Lang == "x-default" {
+			return item.Value
+		}
+	}
+	if len(a.Alt.Items) > 0 {
+		return a.Alt.Items[0].Value
+	}
+	return ""
+}
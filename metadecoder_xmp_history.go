@@ -0,0 +1,81 @@
+// Copyright 2024 Bjørn Erik Pedersen
+// SPDX-License-Identifier: MIT
+
+package imagemeta
+
+import (
+	"time"
+)
+
+const xmpNamespaceXMPMM = "http://ns.adobe.com/xap/1.0/mm/"
+
+// XMPHistoryEvent is an entry in the XMP edit history (xmpMM:History).
+// See https://exiftool.org/TagNames/XMP.html#ResourceEvent
+type XMPHistoryEvent struct {
+	// The action performed, e.g. "created", "converted", "saved".
+	Action string
+	// When the action was performed.
+	// This is the zero time if not set or if it could not be parsed.
+	When time.Time
+	// The application that performed the action, e.g. "Adobe Photoshop 25.0 (Macintosh)".
+	SoftwareAgent string
+	// The parts of the resource that were changed, e.g. "/" or "/metadata".
+	Changed string
+}
+
+type xmpHistory struct {
+	Seq struct {
+		Items []xmpHistoryEvent `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# li"`
+	} `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# Seq"`
+}
+
+type xmpHistoryEvent struct {
+	ActionAttr        string `xml:"http://ns.adobe.com/xap/1.0/sType/ResourceEvent# action,attr"`
+	Action            string `xml:"http://ns.adobe.com/xap/1.0/sType/ResourceEvent# action"`
+	WhenAttr          string `xml:"http://ns.adobe.com/xap/1.0/sType/ResourceEvent# when,attr"`
+	When              string `xml:"http://ns.adobe.com/xap/1.0/sType/ResourceEvent# when"`
+	SoftwareAgentAttr string `xml:"http://ns.adobe.com/xap/1.0/sType/ResourceEvent# softwareAgent,attr"`
+	SoftwareAgent     string `xml:"http://ns.adobe.com/xap/1.0/sType/ResourceEvent# softwareAgent"`
+	ChangedAttr       string `xml:"http://ns.adobe.com/xap/1.0/sType/ResourceEvent# changed,attr"`
+	Changed           string `xml:"http://ns.adobe.com/xap/1.0/sType/ResourceEvent# changed"`
+
+	// Some writers wrap the event in a rdf:Description.
+	Description *xmpHistoryEvent `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# Description"`
+}
+
+func (h *xmpHistory) toEvents() []XMPHistoryEvent {
+	events := make([]XMPHistoryEvent, 0, len(h.Seq.Items))
+	for _, item := range h.Seq.Items {
+		if item.Description != nil {
+			item = *item.Description
+		}
+		events = append(events, XMPHistoryEvent{
+			Action:        firstNonEmpty(item.ActionAttr, item.Action),
+			When:          parseXMPDate(firstNonEmpty(item.WhenAttr, item.When)),
+			SoftwareAgent: firstNonEmpty(item.SoftwareAgentAttr, item.SoftwareAgent),
+			Changed:       firstNonEmpty(item.ChangedAttr, item.Changed),
+		})
+	}
+	return events
+}
+
+// The XMP date formats, see https://developer.adobe.com/xmp/docs/XMPNamespaces/XMPDataTypes/#date
+var xmpDateLayouts = []string{
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+// parseXMPDate parses s as an XMP date, returning the zero time if s can not be parsed.
+func parseXMPDate(s string) time.Time {
+	for _, layout := range xmpDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,85 @@
+// Copyright 2024 Bjørn Erik Pedersen
+// SPDX-License-Identifier: MIT
+
+package imagemeta
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	xmpNamespaceGCamera   = "http://ns.google.com/photos/1.0/camera/"
+	xmpNamespaceContainer = "http://ns.google.com/photos/1.0/container/"
+)
+
+// MotionPhoto describes the video embedded in a Google/Android Motion Photo (or the older Micro Video),
+// which is appended to the end of the JPEG file.
+// See https://developer.android.com/media/platform/motion-photo-format
+type MotionPhoto struct {
+	// Whether the XMP says there's a video.
+	HasVideo bool
+	// The offset of the video (usually an MP4 file) from the start of the file.
+	VideoOffset int64
+	// The length of the video in bytes.
+	VideoLength int64
+}
+
+// The structs below supports both the attribute and the element form of the properties.
+
+type xmpContainerDirectory struct {
+	Seq struct {
+		Items []struct {
+			Item xmpContainerItem `xml:"http://ns.google.com/photos/1.0/container/ Item"`
+		} `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# li"`
+	} `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# Seq"`
+}
+
+type xmpContainerItem struct {
+	SemanticAttr string `xml:"http://ns.google.com/photos/1.0/container/item/ Semantic,attr"`
+	Semantic     string `xml:"http://ns.google.com/photos/1.0/container/item/ Semantic"`
+	LengthAttr   string `xml:"http://ns.google.com/photos/1.0/container/item/ Length,attr"`
+	Length       string `xml:"http://ns.google.com/photos/1.0/container/item/ Length"`
+}
+
+// motionPhotoTrailer returns the length of the video stored at the end of the file
+// as described in the XMP, or 0 if there's no video.
+// The Container directory takes precedence over the older GCamera MicroVideo properties.
+func motionPhotoTrailer(desc rdfDescription) (hasVideo bool, trailer int64) {
+	if dir := desc.ContainerDirectory; dir != nil {
+		// The items after the primary image are stored back to back at the end of the file.
+		var length int64
+		for i := len(dir.Seq.Items) - 1; i > 0; i-- {
+			item := dir.Seq.Items[i].Item
+			n, _ := strconv.ParseInt(strings.TrimSpace(firstNonEmpty(item.LengthAttr, item.Length)), 10, 64)
+			if n <= 0 {
+				break
+			}
+			length += n
+			if firstNonEmpty(item.SemanticAttr, item.Semantic) == "MotionPhoto" {
+				return true, length
+			}
+		}
+	}
+
+	var microVideo bool
+	for _, attr := range desc.Attrs {
+		if attr.Name.Space != xmpNamespaceGCamera {
+			continue
+		}
+		switch attr.Name.Local {
+		case "MotionPhoto", "MicroVideo":
+			if attr.Value == "1" {
+				microVideo = true
+			}
+		case "MicroVideoOffset":
+			trailer, _ = strconv.ParseInt(strings.TrimSpace(attr.Value), 10, 64)
+		}
+	}
+
+	if trailer < 0 {
+		trailer = 0
+	}
+
+	return microVideo || trailer > 0, trailer
+}
@@ -0,0 +1,91 @@
+// Copyright 2024 Bjørn Erik Pedersen
+// SPDX-License-Identifier: MIT
+
+package imagemeta
+
+import (
+	"strconv"
+	"strings"
+)
+
+const xmpNamespaceMWGRegions = "http://www.metadataworkinggroup.com/schemas/regions/"
+
+// Region is an image region (e.g. a face) as defined by the Metadata Working Group.
+// See https://exiftool.org/TagNames/MWG.html#RegionInfo
+type Region struct {
+	// The name of the region, e.g. the name of the person.
+	Name string
+	// The type of region, one of "Face", "Pet", "Focus", "BarCode".
+	Type string
+
+	// The center of the region, normalized to 0-1 relative to the image dimensions.
+	X, Y float64
+	// The width and height of the region, normalized to 0-1 relative to the image dimensions.
+	W, H float64
+}
+
+// The structs below supports both the attribute and the element form of the properties,
+// as both are common, e.g. <rdf:li mwg-rs:Name="John"/> and <rdf:li><mwg-rs:Name>John</mwg-rs:Name></rdf:li>.
+
+type xmpRegions struct {
+	RegionList struct {
+		Bag struct {
+			Items []xmpRegion `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# li"`
+		} `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# Bag"`
+	} `xml:"http://www.metadataworkinggroup.com/schemas/regions/ RegionList"`
+}
+
+type xmpRegion struct {
+	NameAttr string        `xml:"http://www.metadataworkinggroup.com/schemas/regions/ Name,attr"`
+	Name     string        `xml:"http://www.metadataworkinggroup.com/schemas/regions/ Name"`
+	TypeAttr string        `xml:"http://www.metadataworkinggroup.com/schemas/regions/ Type,attr"`
+	Type     string        `xml:"http://www.metadataworkinggroup.com/schemas/regions/ Type"`
+	Area     xmpRegionArea `xml:"http://www.metadataworkinggroup.com/schemas/regions/ Area"`
+
+	// Some writers wrap the region in a rdf:Description.
+	Description *xmpRegion `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# Description"`
+}
+
+type xmpRegionArea struct {
+	XAttr string `xml:"http://ns.adobe.com/xmp/sType/Area# x,attr"`
+	X     string `xml:"http://ns.adobe.com/xmp/sType/Area# x"`
+	YAttr string `xml:"http://ns.adobe.com/xmp/sType/Area# y,attr"`
+	Y     string `xml:"http://ns.adobe.com/xmp/sType/Area# y"`
+	WAttr string `xml:"http://ns.adobe.com/xmp/sType/Area# w,attr"`
+	W     string `xml:"http://ns.adobe.com/xmp/sType/Area# w"`
+	HAttr string `xml:"http://ns.adobe.com/xmp/sType/Area# h,attr"`
+	H     string `xml:"http://ns.adobe.com/xmp/sType/Area# h"`
+}
+
+func (r *xmpRegions) toRegions() []Region {
+	regions := make([]Region, 0, len(r.RegionList.Bag.Items))
+	for _, item := range r.RegionList.Bag.Items {
+		if item.Description != nil {
+			item = *item.Description
+		}
+		area := item.Area
+		regions = append(regions, Region{
+			Name: firstNonEmpty(item.NameAttr, item.Name),
+			Type: firstNonEmpty(item.TypeAttr, item.Type),
+			X:    parseXMPFloat(firstNonEmpty(area.XAttr, area.X)),
+			Y:    parseXMPFloat(firstNonEmpty(area.YAttr, area.Y)),
+			W:    parseXMPFloat(firstNonEmpty(area.WAttr, area.W)),
+			H:    parseXMPFloat(firstNonEmpty(area.HAttr, area.H)),
+		})
+	}
+	return regions
+}
+
+func firstNonEmpty(s ...string) string {
+	for _, v := range s {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func parseXMPFloat(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}